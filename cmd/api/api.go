@@ -3,6 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	auditCore "ips-lacpass-backend/internal/audit/core"
+	ipsCore "ips-lacpass-backend/internal/ips/core"
+	revocationCore "ips-lacpass-backend/internal/revocation/core"
+	vhlCore "ips-lacpass-backend/internal/vhl/core"
 	"net/http"
 	"time"
 )
@@ -10,16 +14,26 @@ import (
 type App struct {
 	router http.Handler
 	config Config
+	audit  *auditCore.AuditService
+	// signer signs merged IPS and verifier packages, nil when no key is configured.
+	signer *ipsCore.BundleSigner
+	// vhlIssuances is shared by the VHL routes and the revocations of verifier packages.
+	vhlIssuances *vhlCore.IssuanceStore
+	// revocations is the HCERT revocation list, checked by the VHL routes and exported to verifiers.
+	revocations *revocationCore.RevocationService
 }
 
-func New(config Config) *App {
+// New builds the app, failing when a store whose loss would let revoked credentials through cannot be loaded.
+func New(config Config) (*App, error) {
 	app := &App{
 		config: config,
 	}
 
-	app.loadRoutes()
+	if err := app.loadRoutes(); err != nil {
+		return nil, err
+	}
 
-	return app
+	return app, nil
 }
 
 func (a *App) Start(ctx context.Context) error {
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFailsOnUnreadableStores(t *testing.T) {
+	corrupt := filepath.Join(t.TempDir(), "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		config func(*Config)
+	}{
+		{"revocation list", func(c *Config) { c.RevocationListFile = corrupt }},
+		{"VHL store", func(c *Config) { c.VhlStoreFile = corrupt }},
+		{"audit log", func(c *Config) { c.AuditLogFile = t.TempDir() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := LoadConfig()
+			tt.config(&cfg)
+			if _, err := New(cfg); err == nil {
+				t.Error("Expected startup to fail")
+			}
+		})
+	}
+}
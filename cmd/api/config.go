@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type NodeConfig struct {
@@ -34,9 +35,39 @@ type Config struct {
 	WalletUrl            string
 	WalletIdentifier     string
 	WalletAPIKey         string
+	WalletProvider       string
+	WalletIssuer         string
+	WalletPreAuthUrl     string
+	WalletConfigIDs      map[string]string
+	WalletTxCodeLength   int
+	WalletPinRequired    bool
 	ICVPValidatorUrl     string
 	UseMultipleNodes     bool
 	Nodes                []NodeConfig
+	AuthNodeClaim        string
+	NodeHeaderOverride   bool
+	IpsNodeTimeout       int
+	IpsMergeStrategy     string
+	IpsSigningKeyFile    string
+	IpsSigningKeyID      string
+	QrErrorCorrection    string
+	QrSize               int
+	VhlStoreFile         string
+	VhlPassCodeMinLength int
+	VhlPassCodeClasses   int
+	VhlFetchMaxAttempts  int
+	VhlFetchLockout      int
+	VhlBatchWorkers      int
+	VhlBatchMaxItems     int
+	VerifierTrustList    string
+	VerifierValueSets    string
+	VerifierPackageTTL   int
+	AuditLogFile         string
+	AuditFhirEnabled     bool
+	RevocationListFile   string
+	RevocationSyncUrl    string
+	RevocationSyncEvery  int
+	AdminRole            string
 }
 
 func LoadConfig() Config {
@@ -58,8 +89,25 @@ func LoadConfig() Config {
 		WalletUrl:            "https://conectathon-balancer.izer.tech/",
 		WalletIdentifier:     "test",
 		WalletAPIKey:         "",
+		WalletProvider:       "credentials",
+		WalletTxCodeLength:   6,
 		ICVPValidatorUrl:     "http://lacpass.create.cl:7089",
 		UseMultipleNodes:     false,
+		AuthNodeClaim:        "node",
+		NodeHeaderOverride:   true,
+		IpsNodeTimeout:       10,
+		IpsMergeStrategy:     "current",
+		QrErrorCorrection:    "Q",
+		QrSize:               512,
+		VhlPassCodeMinLength: 6,
+		VhlPassCodeClasses:   2,
+		VhlFetchMaxAttempts:  5,
+		VhlFetchLockout:      900,
+		VhlBatchWorkers:      8,
+		VhlBatchMaxItems:     200,
+		VerifierPackageTTL:   24,
+		RevocationSyncEvery:  60,
+		AdminRole:            "admin",
 	}
 
 	if serverPort, exists := os.LookupEnv("API_PORT"); exists {
@@ -123,6 +171,32 @@ func LoadConfig() Config {
 		cfg.WalletAPIKey = walletAPIKey
 	}
 
+	if walletProvider, exists := os.LookupEnv("WALLET_PROVIDER"); exists && walletProvider != "" {
+		cfg.WalletProvider = walletProvider
+	}
+
+	if walletIssuer, exists := os.LookupEnv("WALLET_CREDENTIAL_ISSUER"); exists {
+		cfg.WalletIssuer = walletIssuer
+	}
+
+	if walletPreAuthUrl, exists := os.LookupEnv("WALLET_PRE_AUTH_URL"); exists {
+		cfg.WalletPreAuthUrl = walletPreAuthUrl
+	}
+
+	if configIDs, exists := os.LookupEnv("WALLET_CREDENTIAL_CONFIGURATIONS"); exists {
+		cfg.WalletConfigIDs = parseKeyValues(configIDs)
+	}
+
+	if pinRequired, exists := os.LookupEnv("WALLET_PIN_REQUIRED"); exists {
+		cfg.WalletPinRequired = pinRequired == "true"
+	}
+
+	if txCodeLength, exists := os.LookupEnv("WALLET_TX_CODE_LENGTH"); exists {
+		if length, err := strconv.Atoi(txCodeLength); err == nil && length > 0 {
+			cfg.WalletTxCodeLength = length
+		}
+	}
+
 	if icvpValidatorUrl, exists := os.LookupEnv("ICVP_VALIDATOR_URL"); exists {
 		cfg.ICVPValidatorUrl = icvpValidatorUrl
 	}
@@ -131,6 +205,122 @@ func LoadConfig() Config {
 		cfg.UseMultipleNodes = useMultipleNodes == "1" || useMultipleNodes == "true"
 	}
 
+	if authNodeClaim, exists := os.LookupEnv("AUTH_NODE_CLAIM"); exists {
+		cfg.AuthNodeClaim = authNodeClaim
+	}
+
+	if nodeHeaderOverride, exists := os.LookupEnv("NODE_HEADER_OVERRIDE"); exists {
+		cfg.NodeHeaderOverride = nodeHeaderOverride == "1" || nodeHeaderOverride == "true"
+	}
+
+	if ipsNodeTimeout, exists := os.LookupEnv("IPS_NODE_TIMEOUT"); exists {
+		if timeout, err := strconv.Atoi(ipsNodeTimeout); err == nil && timeout > 0 {
+			cfg.IpsNodeTimeout = timeout
+		}
+	}
+
+	if ipsMergeStrategy, exists := os.LookupEnv("IPS_MERGE_STRATEGY"); exists {
+		cfg.IpsMergeStrategy = ipsMergeStrategy
+	}
+
+	if ipsSigningKeyFile, exists := os.LookupEnv("IPS_SIGNING_KEY_FILE"); exists {
+		cfg.IpsSigningKeyFile = ipsSigningKeyFile
+	}
+
+	if ipsSigningKeyID, exists := os.LookupEnv("IPS_SIGNING_KEY_ID"); exists {
+		cfg.IpsSigningKeyID = ipsSigningKeyID
+	}
+
+	if qrErrorCorrection, exists := os.LookupEnv("QR_ERROR_CORRECTION"); exists {
+		cfg.QrErrorCorrection = qrErrorCorrection
+	}
+
+	if qrSize, exists := os.LookupEnv("QR_SIZE"); exists {
+		if size, err := strconv.Atoi(qrSize); err == nil && size > 0 {
+			cfg.QrSize = size
+		}
+	}
+
+	if vhlStoreFile, exists := os.LookupEnv("VHL_STORE_FILE"); exists {
+		cfg.VhlStoreFile = vhlStoreFile
+	}
+
+	if minLength, exists := os.LookupEnv("VHL_PASSCODE_MIN_LENGTH"); exists {
+		if length, err := strconv.Atoi(minLength); err == nil && length >= 0 {
+			cfg.VhlPassCodeMinLength = length
+		}
+	}
+
+	if minClasses, exists := os.LookupEnv("VHL_PASSCODE_MIN_CLASSES"); exists {
+		if classes, err := strconv.Atoi(minClasses); err == nil && classes >= 0 && classes <= 4 {
+			cfg.VhlPassCodeClasses = classes
+		}
+	}
+
+	if maxAttempts, exists := os.LookupEnv("VHL_FETCH_MAX_ATTEMPTS"); exists {
+		if attempts, err := strconv.Atoi(maxAttempts); err == nil && attempts > 0 {
+			cfg.VhlFetchMaxAttempts = attempts
+		}
+	}
+
+	if lockout, exists := os.LookupEnv("VHL_FETCH_LOCKOUT"); exists {
+		if seconds, err := strconv.Atoi(lockout); err == nil && seconds > 0 {
+			cfg.VhlFetchLockout = seconds
+		}
+	}
+
+	if batchWorkers, exists := os.LookupEnv("VHL_BATCH_WORKERS"); exists {
+		if workers, err := strconv.Atoi(batchWorkers); err == nil && workers > 0 {
+			cfg.VhlBatchWorkers = workers
+		}
+	}
+
+	if batchMaxItems, exists := os.LookupEnv("VHL_BATCH_MAX_ITEMS"); exists {
+		if items, err := strconv.Atoi(batchMaxItems); err == nil && items > 0 {
+			cfg.VhlBatchMaxItems = items
+		}
+	}
+
+	if trustListFile, exists := os.LookupEnv("VERIFIER_TRUST_LIST_FILE"); exists {
+		cfg.VerifierTrustList = trustListFile
+	}
+
+	if valueSetsFile, exists := os.LookupEnv("VERIFIER_VALUE_SETS_FILE"); exists {
+		cfg.VerifierValueSets = valueSetsFile
+	}
+
+	if packageTTL, exists := os.LookupEnv("VERIFIER_PACKAGE_TTL"); exists {
+		if hours, err := strconv.Atoi(packageTTL); err == nil && hours > 0 {
+			cfg.VerifierPackageTTL = hours
+		}
+	}
+
+	if auditLogFile, exists := os.LookupEnv("AUDIT_LOG_FILE"); exists {
+		cfg.AuditLogFile = auditLogFile
+	}
+
+	if auditFhirEnabled, exists := os.LookupEnv("AUDIT_FHIR_ENABLED"); exists {
+		cfg.AuditFhirEnabled = auditFhirEnabled == "1" || auditFhirEnabled == "true"
+	}
+
+	if revocationListFile, exists := os.LookupEnv("REVOCATION_LIST_FILE"); exists {
+		cfg.RevocationListFile = revocationListFile
+	}
+
+	if revocationSyncUrl, exists := os.LookupEnv("REVOCATION_SYNC_URL"); exists {
+		cfg.RevocationSyncUrl = revocationSyncUrl
+	}
+
+	if syncInterval, exists := os.LookupEnv("REVOCATION_SYNC_INTERVAL"); exists {
+		if minutes, err := strconv.Atoi(syncInterval); err == nil && minutes > 0 {
+			cfg.RevocationSyncEvery = minutes
+		}
+	}
+
+	if adminRole, exists := os.LookupEnv("ADMIN_ROLE"); exists && adminRole != "" {
+		cfg.AdminRole = adminRole
+	}
+
 	if cfg.UseMultipleNodes {
 		nodesFile := "node-services.json"
 		data, err := os.ReadFile(nodesFile)
@@ -153,3 +343,16 @@ func LoadConfig() Config {
 
 	return cfg
 }
+
+// parseKeyValues reads a comma separated list of key=value pairs, skipping pairs without a key or a value.
+func parseKeyValues(s string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if ok && key != "" && value != "" {
+			values[key] = value
+		}
+	}
+	return values
+}
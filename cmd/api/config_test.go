@@ -49,3 +49,19 @@ func TestLoadConfig(t *testing.T) {
 		t.Errorf("Expected http://test-fhir, got %s", cfg.Nodes[0].FhirBaseUrl)
 	}
 }
+
+func TestLoadWalletConfig(t *testing.T) {
+	t.Setenv("WALLET_PROVIDER", "openid4vci")
+	t.Setenv("WALLET_CREDENTIAL_CONFIGURATIONS", "ICVP=icvp_cwt, MEOW = meow_cwt,broken,VerifiableHealthLink=")
+	t.Setenv("WALLET_TX_CODE_LENGTH", "8")
+	t.Setenv("WALLET_PIN_REQUIRED", "true")
+
+	cfg := LoadConfig()
+
+	if cfg.WalletProvider != "openid4vci" || cfg.WalletTxCodeLength != 8 || !cfg.WalletPinRequired {
+		t.Errorf("Expected the OpenID4VCI provider with required 8 digit PINs, got %s, %d and %t", cfg.WalletProvider, cfg.WalletTxCodeLength, cfg.WalletPinRequired)
+	}
+	if len(cfg.WalletConfigIDs) != 2 || cfg.WalletConfigIDs["ICVP"] != "icvp_cwt" || cfg.WalletConfigIDs["MEOW"] != "meow_cwt" {
+		t.Errorf("Expected the valid configuration IDs, got %v", cfg.WalletConfigIDs)
+	}
+}
@@ -18,12 +18,16 @@ import (
 // @name						Authorization
 func main() {
 
-	app := New(LoadConfig())
+	app, err := New(LoadConfig())
+	if err != nil {
+		fmt.Println("failed to start app:", err)
+		os.Exit(1)
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	err := app.Start(ctx)
+	err = app.Start(ctx)
 	if err != nil {
 		fmt.Println("failed to start app:", err)
 	}
@@ -38,7 +38,10 @@ func TestHandleGetNodes(t *testing.T) {
 	defer os.Unsetenv("USE_MULTIPLE_NODES")
 
 	cfg := LoadConfig()
-	app := New(cfg)
+	app, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 
 	req, err := http.NewRequest("GET", "/nodes", nil)
 	if err != nil {
@@ -78,7 +81,10 @@ func TestHandleGetNodesDisabled(t *testing.T) {
 	defer os.Unsetenv("USE_MULTIPLE_NODES")
 
 	cfg := LoadConfig()
-	app := New(cfg)
+	app, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 
 	req, err := http.NewRequest("GET", "/nodes", nil)
 	if err != nil {
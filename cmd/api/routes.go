@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	customMiddleware "ips-lacpass-backend/pkg/middleware"
+	"ips-lacpass-backend/pkg/utils"
 	"log/slog"
 	"net/http"
 	"os"
@@ -32,9 +34,37 @@ import (
 	medicationClient "ips-lacpass-backend/internal/medication/client"
 	medicationCore "ips-lacpass-backend/internal/medication/core"
 	medicationHandler "ips-lacpass-backend/internal/medication/handler"
+
+	auditClient "ips-lacpass-backend/internal/audit/client"
+	auditCore "ips-lacpass-backend/internal/audit/core"
+	auditHandler "ips-lacpass-backend/internal/audit/handler"
+
+	verifierCore "ips-lacpass-backend/internal/verifier/core"
+	verifierHandler "ips-lacpass-backend/internal/verifier/handler"
+
+	revocationClient "ips-lacpass-backend/internal/revocation/client"
+	revocationCore "ips-lacpass-backend/internal/revocation/core"
+	revocationHandler "ips-lacpass-backend/internal/revocation/handler"
 )
 
-func (a *App) loadRoutes() {
+func (a *App) loadRoutes() error {
+	audit, err := a.newAuditService()
+	if err != nil {
+		return err
+	}
+	a.audit = audit
+	a.signer = a.newBundleSigner()
+	issuances, err := a.newIssuanceStore()
+	if err != nil {
+		return err
+	}
+	a.vhlIssuances = issuances
+	revocations, err := a.newRevocationService()
+	if err != nil {
+		return err
+	}
+	a.revocations = revocations
+
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
@@ -48,6 +78,7 @@ func (a *App) loadRoutes() {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, User-Agent, "+customMiddleware.NodeNameHeader)
+			w.Header().Set("Access-Control-Expose-Headers", customMiddleware.ServedNodeHeader+", "+ipsHandler.UnresolvedReferenceCountHeader+", ETag, "+verifierHandler.PackageVersionHeader)
 			next.ServeHTTP(w, r)
 		})
 	})
@@ -88,6 +119,9 @@ func (a *App) loadRoutes() {
 			a.config.AuthRealm,
 			a.config.AuthHostName,
 		)
+		authMiddleware.NodeClaim = a.config.AuthNodeClaim
+		authMiddleware.AllowNodeHeaderOverride = a.config.NodeHeaderOverride
+		authMiddleware.Nodes = a.nodeIDs()
 		authMiddleware.RefreshKeySet(24 * time.Hour)
 		r.Use(authMiddleware.Authenticator)
 
@@ -98,6 +132,9 @@ func (a *App) loadRoutes() {
 			r.Route("/wallet", a.loadWalletRoutes)
 		}
 		r.Route("/medications", a.loadMedicationRoute)
+		r.Route("/audit", a.loadAuditRoute)
+		r.Route("/verifier", a.loadVerifierRoute)
+		r.Route("/admin/revocations", a.loadRevocationRoute)
 	})
 
 	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
@@ -105,6 +142,7 @@ func (a *App) loadRoutes() {
 	})
 
 	a.router = r
+	return nil
 }
 
 func (a *App) loadUserRoutesNoAuth(router chi.Router) {
@@ -118,6 +156,7 @@ func (a *App) loadUserRoutesNoAuth(router chi.Router) {
 		a.config.AuthEmailLifespan,
 	)
 	s := userCore.NewService(&r)
+	s.Nodes = a.nodeIDs()
 	h := userHandler.NewHandler(&s)
 	router.Post("/", h.Create)
 }
@@ -140,6 +179,13 @@ func (a *App) loadUserRoutesAuth(router chi.Router) {
 func (a *App) loadIpsRoute(router chi.Router) {
 	r := ipsClient.NewClient(a.config.FhirBaseUrl, a.config.FhirMediatorBaseUrl)
 	s := ipsCore.NewService(&r)
+	s.NodeTimeout = time.Duration(a.config.IpsNodeTimeout) * time.Second
+	if strategy, ok := ipsCore.AllowedDedupStrategies[a.config.IpsMergeStrategy]; ok {
+		s.DedupStrategy = strategy
+	} else {
+		slog.Warn("Unknown IPS merge strategy, keeping current records", "strategy", a.config.IpsMergeStrategy)
+	}
+	s.Signer = a.signer
 
 	if a.config.UseMultipleNodes {
 		for _, node := range a.config.Nodes {
@@ -149,14 +195,35 @@ func (a *App) loadIpsRoute(router chi.Router) {
 	}
 
 	h := ipsHandler.NewHandler(&s)
+	h.QROptions = a.qrOptions()
+	h.Audit = a.audit
 	router.Get("/", h.Get)
+	router.Get("/all", h.GetAll)
+	router.Get("/documents", h.Documents)
+	router.Get("/diff", h.Diff)
+	router.Get("/pdf", h.PDF)
+	router.Get("/sections", h.Sections)
+	router.Get("/immunizations", h.Immunizations)
+	router.Get("/allergies", h.Allergies)
+	router.Get("/medications", h.Medications)
 	router.Post("/merge", h.Merge)
+	router.Post("/verify", h.VerifySignature)
+	router.Post("/validate", h.Validate)
 	router.Get("/icvp", h.GetICVP)
 }
 
 func (a *App) loadVhlRoute(router chi.Router) {
 	r := vhlClient.NewClient(a.config.VhlBaseUrl, a.config.ICVPValidatorUrl)
 	s := vhlCore.NewService(&r)
+	s.Issuances = a.vhlIssuances
+	s.PassCodePolicy = vhlCore.PassCodePolicy{MinLength: a.config.VhlPassCodeMinLength, MinClasses: a.config.VhlPassCodeClasses}
+	s.BatchWorkers = a.config.VhlBatchWorkers
+	s.BatchMaxItems = a.config.VhlBatchMaxItems
+	s.Revocations = a.revocations
+	if a.config.VerifierTrustList != "" {
+		s.Keys = a.newVerifierService().HCertKeys
+	}
+	s.Throttle = vhlCore.NewFetchThrottle(a.config.VhlFetchMaxAttempts, time.Duration(a.config.VhlFetchLockout)*time.Second)
 
 	if a.config.UseMultipleNodes {
 		for _, node := range a.config.Nodes {
@@ -166,18 +233,78 @@ func (a *App) loadVhlRoute(router chi.Router) {
 	}
 
 	h := vhlHandler.NewHandler(&s)
+	h.QROptions = a.qrOptions()
+	h.Audit = a.audit
 	router.Post("/", h.Create)
 	router.Post("/fetch", h.Get)
 	router.Post("/validate", h.Validate)
+	router.Post("/validate/batch", h.ValidateBatch)
+	router.Get("/issued", h.List)
+	router.Post("/issued/{id}/revoke", h.Revoke)
 }
 
 func (a *App) loadWalletRoutes(router chi.Router) {
-	r := walletClient.NewClient(a.config.WalletUrl, a.config.WalletIdentifier, a.config.WalletAPIKey)
-	s := walletCore.NewService(&r)
+	s := walletCore.NewService(a.newWalletProvider())
+	if a.config.VerifierTrustList != "" {
+		s.Keys = a.newVerifierService().HCertKeys
+	}
+	s.Revocations = a.revocations
 	h := walletHandler.NewHandler(&s)
+	h.Audit = a.audit
 	router.Post("/generate-link", h.GenerateWalletLink)
 }
 
+// newWalletProvider returns the configured wallet provider, the /credentials API of the wallet service unless
+// WALLET_PROVIDER is openid4vci.
+func (a *App) newWalletProvider() walletClient.ClientInterface {
+	switch a.config.WalletProvider {
+	case "openid4vci":
+		issuer := a.config.WalletIssuer
+		if issuer == "" {
+			issuer = a.config.WalletUrl
+		}
+		if a.config.WalletPreAuthUrl == "" {
+			slog.Error("WALLET_PRE_AUTH_URL is not set, OpenID4VCI credential offers will be refused")
+		}
+		c := walletClient.NewOpenID4VCIClient(issuer, a.config.WalletPreAuthUrl, a.config.WalletAPIKey)
+		c.TxCodeLength = a.config.WalletTxCodeLength
+		c.PinRequired = a.config.WalletPinRequired
+		c.ConfigurationIDs = map[walletClient.CredentialType]string{}
+		for credentialType, id := range a.config.WalletConfigIDs {
+			c.ConfigurationIDs[walletClient.CredentialType(credentialType)] = id
+		}
+		return &c
+	case "credentials":
+	default:
+		slog.Warn("Unknown wallet provider, using the credentials API", "provider", a.config.WalletProvider)
+	}
+	c := walletClient.NewClient(a.config.WalletUrl, a.config.WalletIdentifier, a.config.WalletAPIKey)
+	c.PinRequired = a.config.WalletPinRequired
+	return &c
+}
+
+// qrOptions returns the configured rendering of QR images, keeping the default level when the configured one is unknown.
+func (a *App) qrOptions() utils.QROptions {
+	opts := utils.QROptions{Level: utils.DefaultQROptions.Level, Size: a.config.QrSize}
+	if level, err := utils.ParseQRLevel(a.config.QrErrorCorrection); err == nil {
+		opts.Level = level
+	} else {
+		slog.Warn("Unknown QR error correction level, using Q", "level", a.config.QrErrorCorrection)
+	}
+	return opts
+}
+
+func (a *App) nodeIDs() []string {
+	if !a.config.UseMultipleNodes {
+		return nil
+	}
+	ids := make([]string, 0, len(a.config.Nodes))
+	for _, node := range a.config.Nodes {
+		ids = append(ids, node.ID)
+	}
+	return ids
+}
+
 func (a *App) handleGetNodes(w http.ResponseWriter, r *http.Request) {
 	nodes := make([]map[string]string, 0, len(a.config.Nodes))
 	for _, node := range a.config.Nodes {
@@ -193,6 +320,94 @@ func (a *App) loadMedicationRoute(router chi.Router) {
 	r := medicationClient.NewClient(a.config.FhirBaseUrl, a.config.FhirMediatorBaseUrl)
 	s := medicationCore.NewService(r)
 	h := medicationHandler.NewHandler(s)
+	h.QROptions = a.qrOptions()
+	h.Audit = a.audit
 	router.Get("/", h.Get)
 	router.Get("/meow", h.GetMeow)
 }
+
+// newAuditService creates the audit trail shared by every module. A log file that cannot be opened or read
+// fails startup, since audit events would otherwise be lost on restart.
+func (a *App) newAuditService() (*auditCore.AuditService, error) {
+	store, err := auditCore.NewStore(a.config.AuditLogFile)
+	if err != nil {
+		return nil, err
+	}
+	s := auditCore.NewService(store)
+	if a.config.AuditFhirEnabled {
+		c := auditClient.NewClient(a.config.FhirBaseUrl)
+		s.StartEmitter(context.Background(), &c)
+	}
+	return &s, nil
+}
+
+func (a *App) loadAuditRoute(router chi.Router) {
+	h := auditHandler.NewHandler(a.audit)
+	router.Get("/me", h.Me)
+}
+
+// newBundleSigner loads the signing key shared by merged IPS and verifier packages. Nil when none is configured or it is invalid.
+func (a *App) newBundleSigner() *ipsCore.BundleSigner {
+	if a.config.IpsSigningKeyFile == "" {
+		return nil
+	}
+	keyData, err := os.ReadFile(a.config.IpsSigningKeyFile)
+	if err != nil {
+		slog.Error("Error reading IPS signing key, merged IPS and verifier packages will not be signed", "file", a.config.IpsSigningKeyFile, "error", err)
+		return nil
+	}
+	signer, err := ipsCore.NewBundleSigner(keyData, a.config.IpsSigningKeyID)
+	if err != nil {
+		slog.Error("Invalid IPS signing key, merged IPS and verifier packages will not be signed", "file", a.config.IpsSigningKeyFile, "error", err)
+		return nil
+	}
+	return signer
+}
+
+// newIssuanceStore loads the issued VHLs, shared by the VHL routes and the verifier packages.
+// A store that cannot be loaded fails startup, since starting without it would forget every revoked VHL.
+func (a *App) newIssuanceStore() (*vhlCore.IssuanceStore, error) {
+	return vhlCore.NewIssuanceStore(a.config.VhlStoreFile)
+}
+
+// newVerifierService builds the verifier service, whose trust list also verifies the credentials of wallet links.
+func (a *App) newVerifierService() *verifierCore.VerifierService {
+	s := verifierCore.NewService(a.signer)
+	s.TrustListFile = a.config.VerifierTrustList
+	s.ValueSetsFile = a.config.VerifierValueSets
+	s.TTL = time.Duration(a.config.VerifierPackageTTL) * time.Hour
+	s.Revocations = append(s.Revocations, a.vhlIssuances)
+	s.Certificates = a.revocations
+	return &s
+}
+
+func (a *App) loadVerifierRoute(router chi.Router) {
+	h := verifierHandler.NewHandler(a.newVerifierService())
+	router.Get("/package", h.Package)
+	router.Get("/keys", h.Keys)
+}
+
+// newRevocationService loads the HCERT revocation list and starts syncing the revocation endpoint when one is configured.
+// A list that cannot be loaded fails startup, since starting without it would let every revoked certificate through.
+func (a *App) newRevocationService() (*revocationCore.RevocationService, error) {
+	store, err := revocationCore.NewStore(a.config.RevocationListFile)
+	if err != nil {
+		return nil, err
+	}
+	s := revocationCore.NewService(store)
+	if a.config.RevocationSyncUrl != "" {
+		c := revocationClient.NewClient(a.config.RevocationSyncUrl)
+		s.Client = &c
+		s.StartSync(context.Background(), time.Duration(a.config.RevocationSyncEvery)*time.Minute)
+	}
+	return &s, nil
+}
+
+func (a *App) loadRevocationRoute(router chi.Router) {
+	router.Use(customMiddleware.RequireRole(a.config.AdminRole))
+	h := revocationHandler.NewHandler(a.revocations)
+	h.Audit = a.audit
+	router.Get("/", h.List)
+	router.Post("/", h.Revoke)
+	router.Delete("/{hashType}/{hash}", h.Unrevoke)
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"ips-lacpass-backend/pkg/utils"
+	"net/http"
+	"time"
+)
+
+// FhirClient sends AuditEvent resources to a FHIR server.
+type FhirClient struct {
+	Client  *http.Client
+	BaseURL string
+}
+
+func NewClient(baseURL string) FhirClient {
+	return FhirClient{
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		BaseURL: baseURL,
+	}
+}
+
+// SendAuditEvent creates an AuditEvent resource in the FHIR server.
+func (c *FhirClient) SendAuditEvent(ctx context.Context, auditEvent map[string]interface{}) error {
+	body, err := json.Marshal(auditEvent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal AuditEvent: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/fhir/AuditEvent", c.BaseURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/fhir+json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send AuditEvent: %w", err)
+	}
+	defer utils.CloseBody(resp.Body)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package core
+
+// FHIR codes of the AuditEvent resources built from events.
+const (
+	auditEventTypeSystem  = "http://terminology.hl7.org/CodeSystem/audit-event-type"
+	auditActionCodeSystem = "urn:lacpass:audit-action"
+)
+
+// auditEventActions maps the actions to the AuditEvent.action codes: Create, Read, Delete or Execute.
+var auditEventActions = map[Action]string{
+	ActionVhlCreate:      "C",
+	ActionVhlFetch:       "R",
+	ActionVhlRevoke:      "D",
+	ActionQrValidate:     "E",
+	ActionIcvpGenerate:   "C",
+	ActionMeowGenerate:   "C",
+	ActionWalletLink:     "C",
+	ActionIpsPdfGenerate: "R",
+}
+
+// auditEventOutcomes maps the outcomes to the AuditEvent.outcome codes.
+var auditEventOutcomes = map[Outcome]string{
+	OutcomeSuccess: "0",
+	OutcomeFailure: "4",
+	OutcomeError:   "8",
+}
+
+// ToAuditEvent converts an event to a FHIR R4 AuditEvent resource.
+func ToAuditEvent(event Event) map[string]interface{} {
+	agent := map[string]interface{}{
+		"requestor": true,
+		"who":       map[string]interface{}{"identifier": map[string]interface{}{"value": event.Actor}},
+	}
+	if event.SourceIP != "" {
+		// network type 2 is an IP address
+		agent["network"] = map[string]interface{}{"address": event.SourceIP, "type": "2"}
+	}
+
+	resource := map[string]interface{}{
+		"resourceType": "AuditEvent",
+		"id":           event.ID,
+		"type": map[string]interface{}{
+			"system":  auditEventTypeSystem,
+			"code":    "rest",
+			"display": "Restful Operation",
+		},
+		"subtype": []interface{}{
+			map[string]interface{}{"system": auditActionCodeSystem, "code": string(event.Action)},
+		},
+		"action":   auditEventActions[event.Action],
+		"recorded": event.Timestamp.Format("2006-01-02T15:04:05.000Z07:00"),
+		"outcome":  auditEventOutcomes[event.Outcome],
+		"agent":    []interface{}{agent},
+		"source": map[string]interface{}{
+			"site":     event.Node,
+			"observer": map[string]interface{}{"display": "IPS Lacpass backend"},
+		},
+	}
+	if event.Subject != "" {
+		entity := map[string]interface{}{
+			"what": map[string]interface{}{"identifier": map[string]interface{}{"value": event.Subject}},
+		}
+		var details []interface{}
+		for k, v := range event.Detail {
+			details = append(details, map[string]interface{}{"type": k, "valueString": v})
+		}
+		if len(details) > 0 {
+			entity["detail"] = details
+		}
+		resource["entity"] = []interface{}{entity}
+	}
+	return resource
+}
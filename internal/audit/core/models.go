@@ -0,0 +1,44 @@
+package core
+
+import "time"
+
+type Action string
+
+const (
+	ActionVhlCreate      Action = "vhl.create"
+	ActionVhlFetch       Action = "vhl.fetch"
+	ActionVhlRevoke      Action = "vhl.revoke"
+	ActionQrValidate     Action = "qr.validate"
+	ActionIcvpGenerate   Action = "icvp.generate"
+	ActionMeowGenerate   Action = "meow.generate"
+	ActionWalletLink     Action = "wallet.link"
+	ActionIpsPdfGenerate Action = "ips.pdf"
+	ActionHcertRevoke    Action = "hcert.revoke"
+	ActionHcertUnrevoke  Action = "hcert.unrevoke"
+)
+
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	// OutcomeFailure is a request refused with a 4xx status.
+	OutcomeFailure Outcome = "failure"
+	// OutcomeError is a request that failed with a 5xx status or an unexpected error.
+	OutcomeError Outcome = "error"
+)
+
+// Event is an entry of the audit trail.
+type Event struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    Action    `json:"action"`
+	// Actor is the user UUID of the token that made the request.
+	Actor string `json:"actor"`
+	// Subject is the user UUID whose data was accessed, when known.
+	Subject    string            `json:"subject,omitempty"`
+	Node       string            `json:"node"`
+	Outcome    Outcome           `json:"outcome"`
+	StatusCode int               `json:"status_code,omitempty"`
+	SourceIP   string            `json:"source_ip,omitempty"`
+	Detail     map[string]string `json:"detail,omitempty"`
+}
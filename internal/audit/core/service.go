@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ips-lacpass-backend/internal/audit/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+	// EmitQueueSize is how many events can wait to be sent as FHIR AuditEvents. Events recorded
+	// while the queue is full are not sent, they are still in the store.
+	EmitQueueSize = 1000
+)
+
+type AuditService struct {
+	Store *Store
+	// emitQueue holds the events waiting to be sent by the emitter, nil when none was started.
+	emitQueue chan Event
+}
+
+func NewService(store *Store) AuditService {
+	return AuditService{
+		Store: store,
+	}
+}
+
+// Record completes an event with the actor, node and time of the request, and the outcome
+// of err, then appends it to the trail. Recording never fails the audited request, errors
+// are only logged. A nil service records nothing.
+func (as *AuditService) Record(ctx context.Context, event Event, err error) {
+	if as == nil {
+		return
+	}
+	event.ID = uuid.NewString()
+	event.Timestamp = time.Now().UTC()
+	event.Actor, _ = authMiddleware.GetUserUUIDFromContext(ctx)
+	event.Node = authMiddleware.GetNodeNameFromContext(ctx)
+	if event.Node == "" {
+		event.Node = authMiddleware.DefaultNodeName
+	}
+	event.Outcome, event.StatusCode = outcomeOf(err)
+
+	if err := as.Store.Append(event); err != nil {
+		slog.Error("Failed to record audit event", "action", event.Action, "error", err)
+	}
+	if as.emitQueue != nil {
+		select {
+		case as.emitQueue <- event:
+		default:
+			slog.Warn("FHIR AuditEvent queue full, event not emitted", "id", event.ID, "action", event.Action)
+		}
+	}
+}
+
+// StartEmitter also sends every event recorded from now on as a FHIR AuditEvent. A single worker sends
+// the events in order until ctx is done, so a slow FHIR server never holds up the audited requests.
+func (as *AuditService) StartEmitter(ctx context.Context, emitter *client.FhirClient) {
+	as.emitQueue = make(chan Event, EmitQueueSize)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-as.emitQueue:
+				if err := emitter.SendAuditEvent(ctx, ToAuditEvent(event)); err != nil {
+					slog.Error("Failed to emit FHIR AuditEvent", "id", event.ID, "action", event.Action, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func outcomeOf(err error) (Outcome, int) {
+	if err == nil {
+		return OutcomeSuccess, http.StatusOK
+	}
+	var httpErr *customErrors.HttpError
+	if errors.As(err, &httpErr) && httpErr.StatusCode < http.StatusInternalServerError {
+		return OutcomeFailure, httpErr.StatusCode
+	}
+	if httpErr != nil {
+		return OutcomeError, httpErr.StatusCode
+	}
+	return OutcomeError, http.StatusInternalServerError
+}
+
+// ListMine returns the events of the user's own requests and of accesses to the user's data, newest first.
+func (as *AuditService) ListMine(ctx context.Context, limit int) ([]Event, error) {
+	userID, err := authMiddleware.GetUserUUIDFromContext(ctx)
+	if err != nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusUnauthorized,
+			Body:       []map[string]interface{}{{"error": "user_identifier_not_found", "message": "User identifier not found in request context"}},
+			Err:        err,
+		}
+	}
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	limit = min(limit, MaxListLimit)
+
+	events, err := as.Store.ListByUser(userID, limit)
+	if err != nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusInternalServerError,
+			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to read the audit trail"}},
+			Err:        fmt.Errorf("error listing audit events: %w", err),
+		}
+	}
+	return events, nil
+}
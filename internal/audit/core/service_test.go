@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ips-lacpass-backend/internal/audit/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func userContext(userID string) context.Context {
+	return context.WithValue(context.Background(), authMiddleware.UserUUIDKey, userID)
+}
+
+func TestRecordAndListMine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service := NewService(store)
+
+	service.Record(userContext("owner"), Event{Action: ActionVhlCreate}, nil)
+	// another user fetches the VHL of owner, and is refused the second time
+	service.Record(userContext("reader"), Event{Action: ActionVhlFetch, Subject: "owner", SourceIP: "10.0.0.1"}, nil)
+	service.Record(userContext("reader"), Event{Action: ActionVhlFetch, Subject: "owner"}, &customErrors.HttpError{StatusCode: 410})
+	service.Record(userContext("other"), Event{Action: ActionIcvpGenerate}, errors.New("boom"))
+
+	events, err := service.ListMine(userContext("owner"), 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events for owner, got %+v", events)
+	}
+	if events[0].Outcome != OutcomeFailure || events[0].StatusCode != 410 || events[0].Actor != "reader" {
+		t.Errorf("Expected the refused fetch first, got %+v", events[0])
+	}
+	if events[1].Outcome != OutcomeSuccess || events[1].SourceIP != "10.0.0.1" || events[1].Node != authMiddleware.DefaultNodeName {
+		t.Errorf("Unexpected fetch event %+v", events[1])
+	}
+	if events[2].Action != ActionVhlCreate || events[2].ID == "" || events[2].Timestamp.IsZero() {
+		t.Errorf("Unexpected create event %+v", events[2])
+	}
+
+	events, _ = service.ListMine(userContext("other"), 1)
+	if len(events) != 1 || events[0].Outcome != OutcomeError || events[0].StatusCode != 500 {
+		t.Errorf("Expected one failed ICVP event, got %+v", events)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error reading the log: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("Expected 4 appended lines, got %d", lines)
+	}
+}
+
+func TestStoreIndexesExistingLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	store, _ := NewStore(path)
+	service := NewService(store)
+	service.Record(userContext("owner"), Event{Action: ActionVhlCreate}, nil)
+	service.Record(userContext("other"), Event{Action: ActionIcvpGenerate}, nil)
+
+	// a line left unreadable by a crash is skipped on restart, and appending goes on after it
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	_, _ = f.WriteString("{not json\n")
+	_ = f.Close()
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service = NewService(reopened)
+	service.Record(userContext("reader"), Event{Action: ActionVhlFetch, Subject: "owner"}, nil)
+
+	events, err := service.ListMine(userContext("owner"), 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(events) != 2 || events[0].Action != ActionVhlFetch || events[1].Action != ActionVhlCreate {
+		t.Errorf("Expected the fetch and the create of owner, got %+v", events)
+	}
+	if events, _ := service.ListMine(userContext("other"), 0); len(events) != 1 || events[0].Action != ActionIcvpGenerate {
+		t.Errorf("Expected the ICVP of other, got %+v", events)
+	}
+}
+
+func TestStoreTruncatesIncompleteLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	store, _ := NewStore(path)
+	service := NewService(store)
+	service.Record(userContext("owner"), Event{Action: ActionVhlCreate}, nil)
+
+	// a crash while appending leaves the last line without its newline
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	_, _ = f.WriteString(`{"actor":"owner","act`)
+	_ = f.Close()
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service = NewService(reopened)
+	service.Record(userContext("owner"), Event{Action: ActionVhlFetch}, nil)
+
+	// the event appended after the crash is still readable after another restart
+	reopened, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service = NewService(reopened)
+	events, err := service.ListMine(userContext("owner"), 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(events) != 2 || events[0].Action != ActionVhlFetch || events[1].Action != ActionVhlCreate {
+		t.Errorf("Expected the fetch and the create of owner, got %+v", events)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 2 || !strings.HasSuffix(string(data), "}\n") {
+		t.Errorf("Expected the incomplete line to be cut off, got %q", data)
+	}
+}
+
+func TestStartEmitter(t *testing.T) {
+	received := make(chan map[string]interface{}, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resource map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&resource)
+		received <- resource
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, _ := NewStore("")
+	service := NewService(store)
+	emitter := client.NewClient(server.URL)
+	service.StartEmitter(ctx, &emitter)
+
+	service.Record(userContext("owner"), Event{Action: ActionVhlCreate}, nil)
+	service.Record(userContext("owner"), Event{Action: ActionVhlRevoke}, nil)
+	for _, action := range []string{"C", "D"} {
+		select {
+		case resource := <-received:
+			if resource["resourceType"] != "AuditEvent" || resource["action"] != action {
+				t.Errorf("Expected the AuditEvents in order, got %+v", resource)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the event to be emitted")
+		}
+	}
+}
+
+func TestListMineNeedsUser(t *testing.T) {
+	store, _ := NewStore("")
+	service := NewService(store)
+	var httpErr *customErrors.HttpError
+	if _, err := service.ListMine(context.Background(), 0); !errors.As(err, &httpErr) || httpErr.StatusCode != 401 {
+		t.Errorf("Expected 401 without user, got %v", err)
+	}
+}
+
+func TestNilServiceRecordsNothing(t *testing.T) {
+	var service *AuditService
+	service.Record(userContext("user"), Event{Action: ActionVhlCreate}, nil)
+}
+
+func TestToAuditEvent(t *testing.T) {
+	store, _ := NewStore("")
+	service := NewService(store)
+	service.Record(userContext("reader"), Event{Action: ActionVhlFetch, Subject: "owner", SourceIP: "10.0.0.1", Detail: map[string]string{"vhl_id": "v1"}}, nil)
+	events, _ := service.ListMine(userContext("owner"), 0)
+
+	resource := ToAuditEvent(events[0])
+	if resource["resourceType"] != "AuditEvent" || resource["action"] != "R" || resource["outcome"] != "0" {
+		t.Errorf("Unexpected AuditEvent %+v", resource)
+	}
+	agent := resource["agent"].([]interface{})[0].(map[string]interface{})
+	if agent["network"].(map[string]interface{})["address"] != "10.0.0.1" {
+		t.Errorf("Expected the source IP in the agent, got %+v", agent)
+	}
+	entity := resource["entity"].([]interface{})[0].(map[string]interface{})
+	if entity["what"].(map[string]interface{})["identifier"].(map[string]interface{})["value"] != "owner" || len(entity["detail"].([]interface{})) != 1 {
+		t.Errorf("Expected the owner as entity, got %+v", entity)
+	}
+}
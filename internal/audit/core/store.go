@@ -0,0 +1,184 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Store is the append-only audit trail. With a file path every event is appended to it as
+// a JSON line and never rewritten, otherwise events only live in memory. The store indexes
+// the events of every user, so that listing them reads only their own lines of the file.
+type Store struct {
+	mu     sync.Mutex
+	path   string
+	events []Event
+	// lines are the offsets of the lines of the file, and size the length of the file.
+	lines []logLine
+	size  int64
+	// byUser holds, for every actor or subject, the positions of its events in events or lines.
+	byUser map[string][]int
+}
+
+// logLine is the place of an event in the audit log file.
+type logLine struct {
+	offset int64
+	length int
+}
+
+// NewStore creates a store appending to path, indexing the events already written to it.
+// An empty path gives an in-memory store.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, byUser: make(map[string][]int)}
+	if path == "" {
+		return s, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("error opening audit log %s: %w", path, err)
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load indexes the lines of the file. Unreadable lines are skipped, and a last line left without
+// its newline by a crash is cut off, so that the next event is appended on a line of its own.
+func (s *Store) load() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("error reading audit log %s: %w", s.path, err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) == 0 {
+				return nil
+			}
+			slog.Warn("Truncating incomplete audit log line", "line", n, "bytes", len(line))
+			return s.truncate()
+		}
+		if err != nil {
+			return fmt.Errorf("error reading audit log %s: %w", s.path, err)
+		}
+		var e Event
+		if jsonErr := json.Unmarshal(line, &e); jsonErr != nil {
+			slog.Warn("Skipping unreadable audit log line", "line", n, "error", jsonErr)
+		} else {
+			s.index(e, len(s.lines))
+			s.lines = append(s.lines, logLine{offset: s.size, length: len(line)})
+		}
+		s.size += int64(len(line))
+	}
+}
+
+// truncate cuts the file back to its indexed size. Callers hold mu.
+func (s *Store) truncate() error {
+	if err := os.Truncate(s.path, s.size); err != nil {
+		return fmt.Errorf("error truncating audit log %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// index adds the position of an event to its actor and subject. Callers hold mu.
+func (s *Store) index(e Event, position int) {
+	if e.Actor != "" {
+		s.byUser[e.Actor] = append(s.byUser[e.Actor], position)
+	}
+	if e.Subject != "" && e.Subject != e.Actor {
+		s.byUser[e.Subject] = append(s.byUser[e.Subject], position)
+	}
+}
+
+// Append adds an event at the end of the trail.
+func (s *Store) Append(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		s.index(event, len(s.events))
+		s.events = append(s.events, event)
+		return nil
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding audit event: %w", err)
+	}
+	line = append(line, '\n')
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(line); err != nil {
+		// a short write would leave a partial line the offsets of later events do not account for
+		if truncErr := s.truncate(); truncErr != nil {
+			slog.Error("Failed to remove partial audit log line", "error", truncErr)
+		}
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+	s.index(event, len(s.lines))
+	s.lines = append(s.lines, logLine{offset: s.size, length: len(line)})
+	s.size += int64(len(line))
+	return nil
+}
+
+// ListByUser returns up to limit events where the user is the actor or the subject, newest first.
+func (s *Store) ListByUser(userID string, limit int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := s.byUser[userID]
+	if len(positions) > limit {
+		positions = positions[len(positions)-limit:]
+	}
+	events := make([]Event, 0, len(positions))
+	if s.path == "" {
+		for _, position := range positions {
+			events = append(events, s.events[position])
+		}
+	} else if err := s.read(positions, func(e Event) { events = append(events, e) }); err != nil {
+		return nil, err
+	}
+	slices.Reverse(events)
+	return events, nil
+}
+
+// read decodes the lines of the file at the given positions.
+func (s *Store) read(positions []int, fn func(Event)) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("error reading audit log: %w", err)
+	}
+	defer f.Close()
+
+	for _, position := range positions {
+		line := s.lines[position]
+		data := make([]byte, line.length)
+		if _, err := f.ReadAt(data, line.offset); err != nil {
+			return fmt.Errorf("error reading audit log: %w", err)
+		}
+		var e Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("error reading audit log at offset %d: %w", line.offset, err)
+		}
+		fn(e)
+	}
+	return nil
+}
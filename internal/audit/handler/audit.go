@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"ips-lacpass-backend/internal/audit/core"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"net/http"
+	"strconv"
+)
+
+type Handler struct {
+	AuditService *core.AuditService
+}
+
+func NewHandler(s *core.AuditService) *Handler {
+	return &Handler{
+		AuditService: s,
+	}
+}
+
+// Me List the audit events of the user's requests and of accesses to the user's data, newest first
+func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if param := r.URL.Query().Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil || limit <= 0 {
+			res, _ := json.Marshal([]map[string]interface{}{{"error": "bad_request", "message": "limit must be a positive integer"}})
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write(res)
+			return
+		}
+	}
+
+	events, err := h.AuditService.ListMine(r.Context(), limit)
+	if err != nil {
+		var httpErr *customErrors.HttpError
+		if errors.As(err, &httpErr) {
+			res, err := json.Marshal(httpErr.Body)
+			if err != nil {
+				http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(httpErr.StatusCode)
+			_, err = w.Write(res)
+			if err != nil {
+				http.Error(w, "Failed to write response", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res, err := json.Marshal(events)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	stderrors "errors"
 	"fmt"
@@ -9,11 +10,16 @@ import (
 	"ips-lacpass-backend/pkg/utils"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"time"
 )
 
+// DefaultTimeout bounds every request to the FHIR service. Callers set shorter deadlines through the context.
+const DefaultTimeout = 30 * time.Second
+
 type ClientInterface interface {
-	GetDocumentReference(identifier string) (*Bundle, error)
-	GetIpsBundle(url string) (map[string]interface{}, error)
+	GetDocumentReference(ctx context.Context, identifier string) (*Bundle, error)
+	GetIpsBundle(ctx context.Context, url string) (map[string]interface{}, error)
 	GetIpsICVP(idBundle string, immunizationId *string) (string, error)
 }
 
@@ -25,7 +31,7 @@ type IpsClient struct {
 
 func NewClient(baseURL string, mediatorBaseURL string) IpsClient {
 	return IpsClient{
-		Client:          &http.Client{},
+		Client:          &http.Client{Timeout: DefaultTimeout},
 		BaseURL:         baseURL,
 		MediatorBaseURL: mediatorBaseURL,
 	}
@@ -45,6 +51,7 @@ func request(client *http.Client, req *http.Request) (*http.Response, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		utils.CloseBody(resp.Body)
 		slog.Error("FHIR Request error response", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "body", string(body))
 		return nil, &errors.HttpError{
 			StatusCode: resp.StatusCode,
@@ -84,22 +91,45 @@ func preserveFhirError(err error) error {
 	}
 }
 
-func (c *IpsClient) GetDocumentReference(identifier string) (*Bundle, error) {
-	// TODO: To be implemented by the participant
-	return nil, &errors.HttpError{
-		StatusCode: 500,
-		Body:       []map[string]interface{}{{"error": "Not implemented error", "message": "this method is not implemented yet"}},
-		Err:        fmt.Errorf("failed to get document reference"),
+// GetDocumentReference searches the DocumentReferences of a patient identifier. The request is cancelled with ctx.
+func (c *IpsClient) GetDocumentReference(ctx context.Context, identifier string) (*Bundle, error) {
+	searchUrl := fmt.Sprintf("%s/fhir/DocumentReference?patient.identifier=%s", c.BaseURL, url.QueryEscape(identifier))
+	var bundle Bundle
+	if err := c.getJSON(ctx, searchUrl, &bundle); err != nil {
+		return nil, err
 	}
+	return &bundle, nil
 }
 
-func (c *IpsClient) GetIpsBundle(url string) (map[string]interface{}, error) {
-	// TODO: To be implemented by the participant
-	return nil, &errors.HttpError{
-		StatusCode: 500,
-		Body:       []map[string]interface{}{{"error": "Not implemented error", "message": "this method is not implemented yet"}},
-		Err:        fmt.Errorf("failed to get document reference"),
+// GetIpsBundle reads the IPS bundle at the URL of a DocumentReference attachment. The request is cancelled with ctx.
+func (c *IpsClient) GetIpsBundle(ctx context.Context, bundleUrl string) (map[string]interface{}, error) {
+	var bundle map[string]interface{}
+	if err := c.getJSON(ctx, bundleUrl, &bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+func (c *IpsClient) getJSON(ctx context.Context, resourceUrl string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resourceUrl, nil)
+	if err != nil {
+		return &errors.HttpError{
+			StatusCode: 500,
+			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to create request"}},
+			Err:        err,
+		}
+	}
+	req.Header.Set("Accept", "application/fhir+json")
+
+	resp, err := request(c.Client, req)
+	if err != nil {
+		return err
+	}
+	defer utils.CloseBody(resp.Body)
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return preserveFhirError(fmt.Errorf("failed to decode FHIR response: %w", err))
 	}
+	return nil
 }
 
 func (c *IpsClient) GetIpsICVP(idBundle string, immunizationId *string) (string, error) {
@@ -0,0 +1,296 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const originExtensionURL = "http://lacpass.org/fhir/StructureDefinition/resource-origin"
+
+// DedupStrategy decides which record is kept when the same clinical fact is found in two IPSs.
+// IPSs are merged in order, so the current record is the one of the earlier IPS.
+type DedupStrategy string
+
+const (
+	// DedupKeepCurrent keeps the record from the current IPS.
+	DedupKeepCurrent DedupStrategy = "current"
+	// DedupKeepNew keeps the record from the new IPS.
+	DedupKeepNew DedupStrategy = "new"
+	// DedupKeepLatest keeps the most recently updated record, falling back to the current one.
+	DedupKeepLatest DedupStrategy = "latest"
+)
+
+var AllowedDedupStrategies = map[string]DedupStrategy{
+	"current": DedupKeepCurrent,
+	"new":     DedupKeepNew,
+	"latest":  DedupKeepLatest,
+}
+
+// matchRule describes how two resources of the same type are recognised as the same clinical fact.
+// Resources match when they share a coding (system and code) in one of the code fields, and when
+// their dates and lot numbers do not contradict each other.
+type matchRule struct {
+	codeFields []string
+	dateFields []string
+	// requireDate makes resources without a date never match, for facts that repeat over time.
+	requireDate bool
+	lotNumber   bool
+}
+
+var matchRules = map[string]matchRule{
+	"AllergyIntolerance":  {codeFields: []string{"code"}},
+	"Condition":           {codeFields: []string{"code"}, dateFields: []string{"onsetDateTime", "onsetPeriod.start"}},
+	"Immunization":        {codeFields: []string{"vaccineCode"}, dateFields: []string{"occurrenceDateTime"}, requireDate: true, lotNumber: true},
+	"MedicationStatement": {codeFields: []string{"medicationCodeableConcept"}, dateFields: []string{"effectiveDateTime", "effectivePeriod.start"}},
+	"Observation":         {codeFields: []string{"code"}, dateFields: []string{"effectiveDateTime", "effectivePeriod.start"}, requireDate: true},
+}
+
+// isClinicalDuplicate reports whether two resources record the same clinical fact.
+// Medication references are resolved against entries so statements can be compared by medication code.
+func isClinicalDuplicate(a map[string]interface{}, b map[string]interface{}, entries []Entry) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	rtype, _ := a["resourceType"].(string)
+	if other, _ := b["resourceType"].(string); other != rtype {
+		return false
+	}
+	rule, ok := matchRules[rtype]
+	if !ok {
+		return false
+	}
+
+	if !sharesCoding(resourceCodings(a, rule, entries), resourceCodings(b, rule, entries)) {
+		return false
+	}
+
+	dateA, dateB := firstValue(a, rule.dateFields), firstValue(b, rule.dateFields)
+	if rule.requireDate && (dateA == "" || dateB == "") {
+		return false
+	}
+	if dateA != "" && dateB != "" && !sameDay(dateA, dateB) {
+		return false
+	}
+
+	if rule.lotNumber {
+		lotA, _ := a["lotNumber"].(string)
+		lotB, _ := b["lotNumber"].(string)
+		if lotA != "" && lotB != "" && !strings.EqualFold(strings.TrimSpace(lotA), strings.TrimSpace(lotB)) {
+			return false
+		}
+	}
+	return true
+}
+
+func resourceCodings(resource map[string]interface{}, rule matchRule, entries []Entry) []string {
+	var codings []string
+	for _, field := range rule.codeFields {
+		codings = append(codings, conceptCodings(resource[field])...)
+	}
+	if ref, ok := resource["medicationReference"].(map[string]interface{}); ok {
+		if reference, _ := ref["reference"].(string); reference != "" {
+			if medication := findEntry(reference, entries); medication != nil {
+				codings = append(codings, conceptCodings(medication.Resource["code"])...)
+			}
+		}
+	}
+	return codings
+}
+
+// conceptCodings returns the "system|code" keys of a CodeableConcept.
+func conceptCodings(concept interface{}) []string {
+	cc, ok := concept.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	codings, ok := cc["coding"].([]interface{})
+	if !ok {
+		return nil
+	}
+	var keys []string
+	for _, c := range codings {
+		coding, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		code, _ := coding["code"].(string)
+		if code == "" {
+			continue
+		}
+		system, _ := coding["system"].(string)
+		keys = append(keys, fmt.Sprintf("%s|%s", strings.TrimSuffix(system, "/"), code))
+	}
+	return keys
+}
+
+func sharesCoding(a []string, b []string) bool {
+	for _, key := range a {
+		if slices.Contains(b, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstValue returns the first non-empty string found in the given dotted paths.
+func firstValue(resource map[string]interface{}, paths []string) string {
+	for _, path := range paths {
+		var current interface{} = resource
+		for _, key := range strings.Split(path, ".") {
+			m, ok := current.(map[string]interface{})
+			if !ok {
+				current = nil
+				break
+			}
+			current = m[key]
+		}
+		if v, ok := current.(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func sameDay(a string, b string) bool {
+	if len(a) > 10 {
+		a = a[:10]
+	}
+	if len(b) > 10 {
+		b = b[:10]
+	}
+	return a == b
+}
+
+func findEntry(reference string, entries []Entry) *Entry {
+	i := slices.IndexFunc(entries, func(e Entry) bool {
+		return e.FullURL == reference
+	})
+	if i == -1 {
+		return nil
+	}
+	return &entries[i]
+}
+
+// parseFhirTime parses FHIR dateTime values with any of their allowed precisions.
+func parseFhirTime(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func lastUpdated(resource map[string]interface{}) (time.Time, bool) {
+	value := firstValue(resource, []string{"meta.lastUpdated", "recordedDate", "dateAsserted", "issued"})
+	if value == "" {
+		return time.Time{}, false
+	}
+	return parseFhirTime(value)
+}
+
+// prefersNew reports whether the strategy keeps the new record over the current one.
+func (ds DedupStrategy) prefersNew(current map[string]interface{}, newResource map[string]interface{}) bool {
+	switch ds {
+	case DedupKeepNew:
+		return true
+	case DedupKeepLatest:
+		newTime, okNew := lastUpdated(newResource)
+		currentTime, okCurrent := lastUpdated(current)
+		if !okNew {
+			return false
+		}
+		return !okCurrent || newTime.After(currentTime)
+	default:
+		return false
+	}
+}
+
+// mergeOriginExtensions copies the resource-origin extensions of the discarded record into the kept one,
+// so the kept record lists every IPS it was found in.
+func mergeOriginExtensions(kept map[string]interface{}, discarded map[string]interface{}) {
+	extensions, _ := discarded["extension"].([]interface{})
+	for _, ext := range extensions {
+		extMap, ok := ext.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if url, _ := extMap["url"].(string); url != originExtensionURL {
+			continue
+		}
+		appendOriginExtension(kept, extMap)
+	}
+}
+
+// appendOriginExtension adds an origin extension unless the same origin is already present.
+func appendOriginExtension(resource map[string]interface{}, origin map[string]interface{}) {
+	extensions, ok := resource["extension"].([]interface{})
+	if !ok {
+		extensions = []interface{}{}
+	}
+
+	key := originKey(origin)
+	for _, ext := range extensions {
+		extMap, ok := ext.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if url, _ := extMap["url"].(string); url == originExtensionURL && originKey(extMap) == key {
+			return
+		}
+	}
+
+	resource["extension"] = append(extensions, origin)
+}
+
+// originKey identifies an origin extension by its bundle id and country.
+func originKey(origin map[string]interface{}) string {
+	bundleID, country := originValues(origin)
+	return bundleID + "|" + country
+}
+
+// originExtension builds a resource-origin extension for a bundle and its country.
+func originExtension(bundleID string, country string) map[string]interface{} {
+	return map[string]interface{}{
+		"url": originExtensionURL,
+		"extension": []map[string]interface{}{
+			{
+				"url":         "bundleId",
+				"valueString": bundleID,
+			},
+			{
+				"url":         "country",
+				"valueString": country,
+			},
+		},
+	}
+}
+
+// originValues reads the bundle id and country of a resource-origin extension.
+func originValues(origin map[string]interface{}) (string, string) {
+	var bundleID, country string
+	var subExtensions []interface{}
+	switch v := origin["extension"].(type) {
+	case []interface{}:
+		subExtensions = v
+	case []map[string]interface{}:
+		for _, e := range v {
+			subExtensions = append(subExtensions, e)
+		}
+	}
+	for _, e := range subExtensions {
+		sub, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch sub["url"] {
+		case "bundleId":
+			bundleID, _ = sub["valueString"].(string)
+		case "country":
+			country, _ = sub["valueString"].(string)
+		}
+	}
+	return bundleID, country
+}
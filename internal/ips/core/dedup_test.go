@@ -0,0 +1,116 @@
+package core
+
+import "testing"
+
+func coding(system string, code string) map[string]interface{} {
+	return map[string]interface{}{
+		"coding": []interface{}{
+			map[string]interface{}{"system": system, "code": code},
+		},
+	}
+}
+
+func TestIsClinicalDuplicate(t *testing.T) {
+	tests := []struct {
+		name string
+		a    map[string]interface{}
+		b    map[string]interface{}
+		want bool
+	}{
+		{
+			name: "Allergy with same code",
+			a:    map[string]interface{}{"resourceType": "AllergyIntolerance", "code": coding("http://snomed.info/sct", "91936005"), "recordedDate": "2020-01-01"},
+			b:    map[string]interface{}{"resourceType": "AllergyIntolerance", "code": coding("http://snomed.info/sct/", "91936005"), "recordedDate": "2024-05-01"},
+			want: true,
+		},
+		{
+			name: "Allergy with different code",
+			a:    map[string]interface{}{"resourceType": "AllergyIntolerance", "code": coding("http://snomed.info/sct", "91936005")},
+			b:    map[string]interface{}{"resourceType": "AllergyIntolerance", "code": coding("http://snomed.info/sct", "300913006")},
+			want: false,
+		},
+		{
+			name: "Immunization same vaccine and day",
+			a:    map[string]interface{}{"resourceType": "Immunization", "vaccineCode": coding("http://hl7.org/fhir/sid/cvx", "37"), "occurrenceDateTime": "2017-12-11T10:00:00Z"},
+			b:    map[string]interface{}{"resourceType": "Immunization", "vaccineCode": coding("http://hl7.org/fhir/sid/cvx", "37"), "occurrenceDateTime": "2017-12-11"},
+			want: true,
+		},
+		{
+			name: "Immunization with different lot number",
+			a:    map[string]interface{}{"resourceType": "Immunization", "vaccineCode": coding("http://hl7.org/fhir/sid/cvx", "37"), "occurrenceDateTime": "2017-12-11", "lotNumber": "A1"},
+			b:    map[string]interface{}{"resourceType": "Immunization", "vaccineCode": coding("http://hl7.org/fhir/sid/cvx", "37"), "occurrenceDateTime": "2017-12-11", "lotNumber": "B2"},
+			want: false,
+		},
+		{
+			name: "Immunization without date",
+			a:    map[string]interface{}{"resourceType": "Immunization", "vaccineCode": coding("http://hl7.org/fhir/sid/cvx", "37")},
+			b:    map[string]interface{}{"resourceType": "Immunization", "vaccineCode": coding("http://hl7.org/fhir/sid/cvx", "37"), "occurrenceDateTime": "2017-12-11"},
+			want: false,
+		},
+		{
+			name: "Condition with different onset",
+			a:    map[string]interface{}{"resourceType": "Condition", "code": coding("http://snomed.info/sct", "38341003"), "onsetDateTime": "2010-01-01"},
+			b:    map[string]interface{}{"resourceType": "Condition", "code": coding("http://snomed.info/sct", "38341003"), "onsetDateTime": "2015-01-01"},
+			want: false,
+		},
+		{
+			name: "Different resource types",
+			a:    map[string]interface{}{"resourceType": "Condition", "code": coding("http://snomed.info/sct", "38341003")},
+			b:    map[string]interface{}{"resourceType": "AllergyIntolerance", "code": coding("http://snomed.info/sct", "38341003")},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isClinicalDuplicate(tt.a, tt.b, nil); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsClinicalDuplicateMedicationReference(t *testing.T) {
+	entries := []Entry{
+		{FullURL: "urn:uuid:med-1", Resource: map[string]interface{}{"resourceType": "Medication", "code": coding("http://www.whocc.no/atc", "C10AA05")}},
+	}
+	a := map[string]interface{}{"resourceType": "MedicationStatement", "medicationReference": map[string]interface{}{"reference": "urn:uuid:med-1"}}
+	b := map[string]interface{}{"resourceType": "MedicationStatement", "medicationCodeableConcept": coding("http://www.whocc.no/atc", "C10AA05")}
+
+	if !isClinicalDuplicate(a, b, entries) {
+		t.Errorf("Expected medication reference to match the coded medication")
+	}
+}
+
+func TestMergeOriginExtensions(t *testing.T) {
+	kept := map[string]interface{}{"resourceType": "Immunization"}
+	discarded := map[string]interface{}{"resourceType": "Immunization"}
+	addOriginExtension(kept, "bundle-cl", "CL")
+	addOriginExtension(discarded, "bundle-uy", "UY")
+
+	mergeOriginExtensions(kept, discarded)
+	mergeOriginExtensions(kept, discarded)
+
+	extensions := kept["extension"].([]interface{})
+	if len(extensions) != 2 {
+		t.Fatalf("Expected 2 origin extensions, got %d", len(extensions))
+	}
+}
+
+func TestDedupStrategyPrefersNew(t *testing.T) {
+	older := map[string]interface{}{"meta": map[string]interface{}{"lastUpdated": "2024-01-01T00:00:00Z"}}
+	newer := map[string]interface{}{"meta": map[string]interface{}{"lastUpdated": "2025-01-01T00:00:00.000+00:00"}}
+
+	if DedupKeepCurrent.prefersNew(older, newer) {
+		t.Errorf("Expected current strategy to keep the current record")
+	}
+	if !DedupKeepNew.prefersNew(newer, older) {
+		t.Errorf("Expected new strategy to keep the new record")
+	}
+	if !DedupKeepLatest.prefersNew(older, newer) {
+		t.Errorf("Expected latest strategy to keep the newer record")
+	}
+	if DedupKeepLatest.prefersNew(newer, older) {
+		t.Errorf("Expected latest strategy to keep the current record when it is newer")
+	}
+}
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"ips-lacpass-backend/internal/ips/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"log/slog"
+	"strings"
+)
+
+// IpsDocument describes one of the IPS DocumentReferences of the user.
+type IpsDocument struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title,omitempty"`
+	Date        string   `json:"date,omitempty"`
+	LastUpdated string   `json:"last_updated,omitempty"`
+	Status      string   `json:"status"`
+	Author      []string `json:"author"`
+	Custodian   string   `json:"custodian,omitempty"`
+	// Current marks the newest document, the one GET /ips returns without documentId.
+	Current bool `json:"current"`
+}
+
+// DiffItem is an entry present in only one of the compared IPS, or in both.
+type DiffItem struct {
+	ResourceType string          `json:"resource_type"`
+	Display      string          `json:"display"`
+	Codes        []ProjectedCode `json:"codes"`
+	Date         string          `json:"date,omitempty"`
+	Status       string          `json:"status,omitempty"`
+	// PreviousStatus is set on unchanged items whose status changed between the two versions.
+	PreviousStatus string `json:"previous_status,omitempty"`
+}
+
+// SectionDiff lists the entries of a section added, removed and kept between two IPS versions.
+type SectionDiff struct {
+	Code      string     `json:"code"`
+	Title     string     `json:"title"`
+	Added     []DiffItem `json:"added"`
+	Removed   []DiffItem `json:"removed"`
+	Unchanged []DiffItem `json:"unchanged"`
+}
+
+// IpsDiff compares two IPS documents of the user section by section.
+type IpsDiff struct {
+	From         string        `json:"from"`
+	To           string        `json:"to"`
+	FromBundleID string        `json:"from_bundle_id"`
+	ToBundleID   string        `json:"to_bundle_id"`
+	Sections     []SectionDiff `json:"sections"`
+}
+
+// ListDocuments returns the IPS DocumentReferences of the user, newest first.
+func (is *IpsService) ListDocuments(ctx context.Context) ([]IpsDocument, error) {
+	userId, err := authMiddleware.GetUserDocIDFromContext(ctx)
+	if err != nil {
+		slog.Error("User identifier not found in context", "error", err)
+		return nil, &customErrors.HttpError{
+			StatusCode: 401,
+			Body:       []map[string]interface{}{{"error": "user_identifier_not_found", "message": "User identifier not found in request context"}},
+			Err:        err,
+		}
+	}
+
+	references, err := listDocumentReferences(ctx, is.getClient(ctx), userId)
+	if err != nil {
+		return nil, err
+	}
+	documents := make([]IpsDocument, 0, len(references))
+	for i, reference := range references {
+		document := IpsDocument{
+			ID:          reference.ID,
+			Title:       reference.Title,
+			Date:        reference.Date,
+			LastUpdated: lastUpdatedOf(reference),
+			Status:      reference.Status,
+			Author:      []string{},
+			Current:     i == 0,
+		}
+		for _, author := range reference.Author {
+			document.Author = append(document.Author, referenceName(author))
+		}
+		if reference.Custodian != nil {
+			document.Custodian = referenceName(*reference.Custodian)
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// referenceName prefers the display of a reference over the reference itself.
+func referenceName(reference client.Reference) string {
+	if reference.Display != "" {
+		return reference.Display
+	}
+	return reference.Reference
+}
+
+// DiffIps compares two IPS documents of the user. An empty to compares against the newest document.
+func (is *IpsService) DiffIps(ctx context.Context, from string, to string, locale string) (*IpsDiff, error) {
+	if from == "" {
+		return nil, &customErrors.HttpError{
+			StatusCode: 400,
+			Body:       []map[string]interface{}{{"error": "bad_request", "message": "from document id is required"}},
+			Err:        fmt.Errorf("from document id is required"),
+		}
+	}
+	if to == "" {
+		documents, err := is.ListDocuments(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(documents) > 0 {
+			to = documents[0].ID
+		}
+	}
+
+	fromIps, err := is.GetIps(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	toIps, err := is.GetIps(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := DiffIPS(fromIps, toIps, locale)
+	if err != nil {
+		return nil, err
+	}
+	diff.From, diff.To = from, to
+	return diff, nil
+}
+
+// DiffIPS compares the sections of two IPS bundles. Entries are matched by resource type, main code
+// and date, so the same clinical fact in both versions is unchanged even if its resource id changed.
+// Sections keep the order of the newer IPS, followed by the sections only the older one has.
+func DiffIPS(from map[string]interface{}, to map[string]interface{}, locale string) (*IpsDiff, error) {
+	before, err := ProjectIPS(from, locale)
+	if err != nil {
+		return nil, err
+	}
+	after, err := ProjectIPS(to, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &IpsDiff{FromBundleID: before.BundleID, ToBundleID: after.BundleID, Sections: []SectionDiff{}}
+	beforeSections := map[string]ProjectedSection{}
+	for _, section := range before.Sections {
+		beforeSections[section.Code] = section
+	}
+
+	seen := map[string]bool{}
+	for _, section := range after.Sections {
+		seen[section.Code] = true
+		diff.Sections = append(diff.Sections, diffSection(section.Code, section.Title, beforeSections[section.Code].Items, section.Items))
+	}
+	for _, section := range before.Sections {
+		if !seen[section.Code] {
+			diff.Sections = append(diff.Sections, diffSection(section.Code, section.Title, section.Items, nil))
+		}
+	}
+	return diff, nil
+}
+
+func diffSection(code string, title string, before []ProjectedItem, after []ProjectedItem) SectionDiff {
+	section := SectionDiff{Code: code, Title: title, Added: []DiffItem{}, Removed: []DiffItem{}, Unchanged: []DiffItem{}}
+
+	// keys can repeat inside a section, so they are matched one to one
+	remaining := map[string][]ProjectedItem{}
+	for _, item := range before {
+		key := diffKey(item)
+		remaining[key] = append(remaining[key], item)
+	}
+	for _, item := range after {
+		key := diffKey(item)
+		previous, ok := remaining[key]
+		if !ok || len(previous) == 0 {
+			section.Added = append(section.Added, diffItem(item))
+			continue
+		}
+		remaining[key] = previous[1:]
+		unchanged := diffItem(item)
+		if previous[0].Status != item.Status {
+			unchanged.PreviousStatus = previous[0].Status
+		}
+		section.Unchanged = append(section.Unchanged, unchanged)
+	}
+	for _, item := range before {
+		key := diffKey(item)
+		if len(remaining[key]) > 0 {
+			section.Removed = append(section.Removed, diffItem(remaining[key][0]))
+			remaining[key] = remaining[key][1:]
+		}
+	}
+	return section
+}
+
+// diffKey identifies an entry across IPS versions by resource type, first coding and day.
+func diffKey(item ProjectedItem) string {
+	identity := strings.ToLower(item.Display)
+	if len(item.Codes) > 0 {
+		identity = item.Codes[0].System + "|" + item.Codes[0].Code
+	}
+	date := item.Date
+	if len(date) > 10 {
+		date = date[:10]
+	}
+	return item.ResourceType + "|" + identity + "|" + date
+}
+
+func diffItem(item ProjectedItem) DiffItem {
+	return DiffItem{
+		ResourceType: item.ResourceType,
+		Display:      item.Display,
+		Codes:        item.Codes,
+		Date:         item.Date,
+		Status:       item.Status,
+	}
+}
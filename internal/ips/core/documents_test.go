@@ -0,0 +1,94 @@
+package core
+
+import (
+	"ips-lacpass-backend/internal/ips/client"
+	"testing"
+)
+
+func statementEntry(fullURL string, code string, status string) map[string]interface{} {
+	return map[string]interface{}{
+		"fullUrl": fullURL,
+		"resource": map[string]interface{}{
+			"resourceType":              "MedicationStatement",
+			"status":                    status,
+			"medicationCodeableConcept": coding("http://snomed.info/sct", code),
+			"effectiveDateTime":         "2024-03-01T10:00:00Z",
+		},
+	}
+}
+
+func TestDiffIPS(t *testing.T) {
+	before := ipsBundle("bundle-v1",
+		ipsComposition("urn:uuid:comp-1", "urn:uuid:paracetamol", "urn:uuid:ibuprofen"),
+		statementEntry("urn:uuid:paracetamol", "387517004", "active"),
+		statementEntry("urn:uuid:ibuprofen", "387207008", "active"),
+	)
+	// the same paracetamol statement comes back with another fullUrl and a new status
+	after := ipsBundle("bundle-v2",
+		ipsComposition("urn:uuid:comp-2", "urn:uuid:statement-1", "urn:uuid:statement-2"),
+		statementEntry("urn:uuid:statement-1", "387517004", "stopped"),
+		statementEntry("urn:uuid:statement-2", "387458008", "active"),
+	)
+
+	diff, err := DiffIPS(before, after, "en")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if diff.FromBundleID != "bundle-v1" || diff.ToBundleID != "bundle-v2" || len(diff.Sections) != 1 {
+		t.Fatalf("Expected one section between bundle-v1 and bundle-v2, got %+v", diff)
+	}
+
+	section := diff.Sections[0]
+	if section.Code != "10160-0" || section.Title != "Medication Summary" {
+		t.Errorf("Unexpected section %s %q", section.Code, section.Title)
+	}
+	if len(section.Added) != 1 || section.Added[0].Codes[0].Code != "387458008" {
+		t.Errorf("Expected aspirin to be added, got %+v", section.Added)
+	}
+	if len(section.Removed) != 1 || section.Removed[0].Codes[0].Code != "387207008" {
+		t.Errorf("Expected ibuprofen to be removed, got %+v", section.Removed)
+	}
+	if len(section.Unchanged) != 1 || section.Unchanged[0].Status != "stopped" || section.Unchanged[0].PreviousStatus != "active" {
+		t.Errorf("Expected paracetamol to be kept with its previous status, got %+v", section.Unchanged)
+	}
+}
+
+func TestDiffIPSSectionOnlyInOlderVersion(t *testing.T) {
+	composition := ipsComposition("urn:uuid:comp-1")
+	resource := composition["resource"].(map[string]interface{})
+	resource["section"] = append(resource["section"].([]interface{}), map[string]interface{}{
+		"title": "Immunizations",
+		"code":  coding("http://loinc.org", SectionImmunizations),
+		"entry": []interface{}{map[string]interface{}{"reference": "urn:uuid:imm"}},
+	})
+	before := ipsBundle("bundle-v1", composition, immunizationEntry("urn:uuid:imm"))
+	after := ipsBundle("bundle-v2", ipsComposition("urn:uuid:comp-2"))
+
+	diff, err := DiffIPS(before, after, "es")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(diff.Sections) != 2 {
+		t.Fatalf("Expected two sections, got %+v", diff.Sections)
+	}
+	immunizations := diff.Sections[1]
+	if immunizations.Code != SectionImmunizations || immunizations.Title != "Historial de vacunación" ||
+		len(immunizations.Removed) != 1 || len(immunizations.Added) != 0 {
+		t.Errorf("Expected the removed immunization in the last section, got %+v", immunizations)
+	}
+}
+
+func TestOwnsBundle(t *testing.T) {
+	documents := []*client.EntryResource{
+		{ID: "doc-1", Content: []client.DocumentContent{{Attachment: client.Attachment{URL: "http://fhir.example.com/fhir/Bundle/ips-1"}}}},
+		{ID: "doc-2"},
+	}
+	if !ownsBundle(documents, "ips-1") {
+		t.Error("Expected the bundle of a DocumentReference of the user to be owned")
+	}
+	for _, idBundle := range []string{"ips-2", "doc-1", ""} {
+		if ownsBundle(documents, idBundle) {
+			t.Errorf("Expected %q not to be owned", idBundle)
+		}
+	}
+}
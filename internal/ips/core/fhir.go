@@ -0,0 +1,538 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// The types below model the FHIR R4 resources and data types the IPS core works with. They only declare
+// the elements the backend reads or writes: every other element, including primitive extensions such as
+// "_birthDate", is kept in Extra and written back as it was, so a resource survives a decode/encode round trip.
+// Bundle entries keep their resource as a generic map, use DecodeResource to get a typed resource from it.
+
+// Extra holds the JSON elements of a FHIR element that its Go type does not declare.
+type Extra map[string]json.RawMessage
+
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Version string `json:"version,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+	Extra   Extra  `json:"-"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+	Extra  Extra    `json:"-"`
+}
+
+type Identifier struct {
+	Use    string `json:"use,omitempty"`
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Extra  Extra  `json:"-"`
+}
+
+type Reference struct {
+	Reference  string      `json:"reference,omitempty"`
+	Type       string      `json:"type,omitempty"`
+	Identifier *Identifier `json:"identifier,omitempty"`
+	Display    string      `json:"display,omitempty"`
+	Extra      Extra       `json:"-"`
+}
+
+// Extension only declares valueString, other value[x] elements are kept in Extra.
+type Extension struct {
+	URL         string      `json:"url"`
+	ValueString string      `json:"valueString,omitempty"`
+	Extension   []Extension `json:"extension,omitempty"`
+	Extra       Extra       `json:"-"`
+}
+
+type Narrative struct {
+	Status string `json:"status"`
+	Div    string `json:"div"`
+	Extra  Extra  `json:"-"`
+}
+
+type Period struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	Extra Extra  `json:"-"`
+}
+
+type Address struct {
+	Use        string   `json:"use,omitempty"`
+	Text       string   `json:"text,omitempty"`
+	Line       []string `json:"line,omitempty"`
+	City       string   `json:"city,omitempty"`
+	State      string   `json:"state,omitempty"`
+	PostalCode string   `json:"postalCode,omitempty"`
+	Country    string   `json:"country,omitempty"`
+	Extra      Extra    `json:"-"`
+}
+
+type HumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+	Extra  Extra    `json:"-"`
+}
+
+// DomainResource holds the elements shared by every resource below.
+type DomainResource struct {
+	ResourceType string                 `json:"resourceType"`
+	ID           string                 `json:"id,omitempty"`
+	Meta         map[string]interface{} `json:"meta,omitempty"`
+	Text         *Narrative             `json:"text,omitempty"`
+	Extension    []Extension            `json:"extension,omitempty"`
+}
+
+type Bundle struct {
+	ResourceType string                 `json:"resourceType"`
+	ID           string                 `json:"id,omitempty"`
+	Meta         map[string]interface{} `json:"meta,omitempty"`
+	Identifier   *Identifier            `json:"identifier,omitempty"`
+	Type         string                 `json:"type"`
+	Timestamp    string                 `json:"timestamp,omitempty"`
+	Entry        []Entry                `json:"entry,omitempty"`
+	Signature    map[string]interface{} `json:"signature,omitempty"`
+	Extra        Extra                  `json:"-"`
+}
+
+type Entry struct {
+	FullURL  string                 `json:"fullUrl"`
+	Resource map[string]interface{} `json:"resource"` // This could be any FHIR resource, it will be treated as a map
+	Extra    Extra                  `json:"-"`
+}
+
+type Composition struct {
+	DomainResource
+	Identifier      *Identifier     `json:"identifier,omitempty"`
+	Status          string          `json:"status,omitempty"`
+	Type            CodeableConcept `json:"type"`
+	Subject         *Reference      `json:"subject,omitempty"`
+	Date            string          `json:"date,omitempty"`
+	Author          []Reference     `json:"author,omitempty"`
+	Title           string          `json:"title,omitempty"`
+	Confidentiality string          `json:"confidentiality,omitempty"`
+	Custodian       *Reference      `json:"custodian,omitempty"`
+	Section         []Section       `json:"section,omitempty"`
+	Extra           Extra           `json:"-"`
+}
+
+type Section struct {
+	Title       string           `json:"title,omitempty"`
+	Code        *CodeableConcept `json:"code,omitempty"`
+	Text        *Narrative       `json:"text,omitempty"`
+	Entry       []Reference      `json:"entry,omitempty"`
+	EmptyReason *CodeableConcept `json:"emptyReason,omitempty"`
+	Section     []Section        `json:"section,omitempty"`
+	Extra       Extra            `json:"-"`
+}
+
+type Patient struct {
+	DomainResource
+	Identifier []Identifier `json:"identifier,omitempty"`
+	Active     *bool        `json:"active,omitempty"`
+	Name       []HumanName  `json:"name,omitempty"`
+	Gender     string       `json:"gender,omitempty"`
+	BirthDate  string       `json:"birthDate,omitempty"`
+	Address    []Address    `json:"address,omitempty"`
+	Extra      Extra        `json:"-"`
+}
+
+type Immunization struct {
+	DomainResource
+	Identifier         []Identifier     `json:"identifier,omitempty"`
+	Status             string           `json:"status,omitempty"`
+	VaccineCode        *CodeableConcept `json:"vaccineCode,omitempty"`
+	Patient            *Reference       `json:"patient,omitempty"`
+	OccurrenceDateTime string           `json:"occurrenceDateTime,omitempty"`
+	LotNumber          string           `json:"lotNumber,omitempty"`
+	Extra              Extra            `json:"-"`
+}
+
+type AllergyIntolerance struct {
+	DomainResource
+	Identifier         []Identifier     `json:"identifier,omitempty"`
+	ClinicalStatus     *CodeableConcept `json:"clinicalStatus,omitempty"`
+	VerificationStatus *CodeableConcept `json:"verificationStatus,omitempty"`
+	Type               string           `json:"type,omitempty"`
+	Category           []string         `json:"category,omitempty"`
+	Criticality        string           `json:"criticality,omitempty"`
+	Code               *CodeableConcept `json:"code,omitempty"`
+	Patient            *Reference       `json:"patient,omitempty"`
+	OnsetDateTime      string           `json:"onsetDateTime,omitempty"`
+	RecordedDate       string           `json:"recordedDate,omitempty"`
+	Extra              Extra            `json:"-"`
+}
+
+type Condition struct {
+	DomainResource
+	Identifier         []Identifier      `json:"identifier,omitempty"`
+	ClinicalStatus     *CodeableConcept  `json:"clinicalStatus,omitempty"`
+	VerificationStatus *CodeableConcept  `json:"verificationStatus,omitempty"`
+	Category           []CodeableConcept `json:"category,omitempty"`
+	Severity           *CodeableConcept  `json:"severity,omitempty"`
+	Code               *CodeableConcept  `json:"code,omitempty"`
+	Subject            *Reference        `json:"subject,omitempty"`
+	OnsetDateTime      string            `json:"onsetDateTime,omitempty"`
+	OnsetPeriod        *Period           `json:"onsetPeriod,omitempty"`
+	RecordedDate       string            `json:"recordedDate,omitempty"`
+	Extra              Extra             `json:"-"`
+}
+
+type MedicationStatement struct {
+	DomainResource
+	Identifier                []Identifier     `json:"identifier,omitempty"`
+	Status                    string           `json:"status,omitempty"`
+	MedicationCodeableConcept *CodeableConcept `json:"medicationCodeableConcept,omitempty"`
+	MedicationReference       *Reference       `json:"medicationReference,omitempty"`
+	Subject                   *Reference       `json:"subject,omitempty"`
+	EffectiveDateTime         string           `json:"effectiveDateTime,omitempty"`
+	EffectivePeriod           *Period          `json:"effectivePeriod,omitempty"`
+	DateAsserted              string           `json:"dateAsserted,omitempty"`
+	Extra                     Extra            `json:"-"`
+}
+
+type Organization struct {
+	DomainResource
+	Identifier []Identifier `json:"identifier,omitempty"`
+	Active     *bool        `json:"active,omitempty"`
+	Name       string       `json:"name,omitempty"`
+	Address    []Address    `json:"address,omitempty"`
+	Extra      Extra        `json:"-"`
+}
+
+type Practitioner struct {
+	DomainResource
+	Identifier []Identifier `json:"identifier,omitempty"`
+	Active     *bool        `json:"active,omitempty"`
+	Name       []HumanName  `json:"name,omitempty"`
+	Gender     string       `json:"gender,omitempty"`
+	Address    []Address    `json:"address,omitempty"`
+	Extra      Extra        `json:"-"`
+}
+
+// DecodeResource converts a generic resource, such as a Bundle entry resource, into a typed one.
+func DecodeResource[T any](resource map[string]interface{}) (*T, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var typed T
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, err
+	}
+	return &typed, nil
+}
+
+// EncodeResource converts a typed resource back into a generic one.
+func EncodeResource(resource interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
+
+// hasCode reports whether the concept has a coding with the given code, in any system.
+func (cc *CodeableConcept) hasCode(code string) bool {
+	if cc == nil {
+		return false
+	}
+	for _, c := range cc.Coding {
+		if c.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// firstCode returns the code of the first coding of the concept, or "" when it has none.
+func (cc *CodeableConcept) firstCode() string {
+	if cc == nil || len(cc.Coding) == 0 {
+		return ""
+	}
+	return cc.Coding[0].Code
+}
+
+var knownFieldsCache sync.Map
+
+// knownFields returns the JSON names of the fields of a struct type, including those of embedded structs.
+func knownFields(t reflect.Type) map[string]bool {
+	if cached, ok := knownFieldsCache.Load(t); ok {
+		return cached.(map[string]bool)
+	}
+	fields := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for name := range knownFields(f.Type) {
+				fields[name] = true
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = true
+	}
+	knownFieldsCache.Store(t, fields)
+	return fields
+}
+
+// unmarshalElement decodes data into v, a pointer to a struct without JSON methods, and stores the elements
+// the struct does not declare in extra.
+func unmarshalElement(data []byte, v interface{}, extra *Extra) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	known := knownFields(reflect.TypeOf(v).Elem())
+	*extra = nil
+	for name, value := range all {
+		if known[name] {
+			continue
+		}
+		if *extra == nil {
+			*extra = Extra{}
+		}
+		(*extra)[name] = value
+	}
+	return nil
+}
+
+// marshalElement encodes v, a struct without JSON methods, adding the elements kept in extra.
+func marshalElement(v interface{}, extra Extra) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for name, value := range extra {
+		if _, ok := all[name]; !ok {
+			all[name] = value
+		}
+	}
+	return json.Marshal(all)
+}
+
+func (e *Coding) UnmarshalJSON(data []byte) error {
+	type plain Coding
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Coding) MarshalJSON() ([]byte, error) {
+	type plain Coding
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *CodeableConcept) UnmarshalJSON(data []byte) error {
+	type plain CodeableConcept
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e CodeableConcept) MarshalJSON() ([]byte, error) {
+	type plain CodeableConcept
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Identifier) UnmarshalJSON(data []byte) error {
+	type plain Identifier
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Identifier) MarshalJSON() ([]byte, error) {
+	type plain Identifier
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Reference) UnmarshalJSON(data []byte) error {
+	type plain Reference
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Reference) MarshalJSON() ([]byte, error) {
+	type plain Reference
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Extension) UnmarshalJSON(data []byte) error {
+	type plain Extension
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Extension) MarshalJSON() ([]byte, error) {
+	type plain Extension
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Narrative) UnmarshalJSON(data []byte) error {
+	type plain Narrative
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Narrative) MarshalJSON() ([]byte, error) {
+	type plain Narrative
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Period) UnmarshalJSON(data []byte) error {
+	type plain Period
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Period) MarshalJSON() ([]byte, error) {
+	type plain Period
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Address) UnmarshalJSON(data []byte) error {
+	type plain Address
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Address) MarshalJSON() ([]byte, error) {
+	type plain Address
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *HumanName) UnmarshalJSON(data []byte) error {
+	type plain HumanName
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e HumanName) MarshalJSON() ([]byte, error) {
+	type plain HumanName
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Bundle) UnmarshalJSON(data []byte) error {
+	type plain Bundle
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Bundle) MarshalJSON() ([]byte, error) {
+	type plain Bundle
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	type plain Entry
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type plain Entry
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Composition) UnmarshalJSON(data []byte) error {
+	type plain Composition
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Composition) MarshalJSON() ([]byte, error) {
+	type plain Composition
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Section) UnmarshalJSON(data []byte) error {
+	type plain Section
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Section) MarshalJSON() ([]byte, error) {
+	type plain Section
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Patient) UnmarshalJSON(data []byte) error {
+	type plain Patient
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Patient) MarshalJSON() ([]byte, error) {
+	type plain Patient
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Immunization) UnmarshalJSON(data []byte) error {
+	type plain Immunization
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Immunization) MarshalJSON() ([]byte, error) {
+	type plain Immunization
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *AllergyIntolerance) UnmarshalJSON(data []byte) error {
+	type plain AllergyIntolerance
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e AllergyIntolerance) MarshalJSON() ([]byte, error) {
+	type plain AllergyIntolerance
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Condition) UnmarshalJSON(data []byte) error {
+	type plain Condition
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Condition) MarshalJSON() ([]byte, error) {
+	type plain Condition
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *MedicationStatement) UnmarshalJSON(data []byte) error {
+	type plain MedicationStatement
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e MedicationStatement) MarshalJSON() ([]byte, error) {
+	type plain MedicationStatement
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Organization) UnmarshalJSON(data []byte) error {
+	type plain Organization
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Organization) MarshalJSON() ([]byte, error) {
+	type plain Organization
+	return marshalElement(plain(e), e.Extra)
+}
+
+func (e *Practitioner) UnmarshalJSON(data []byte) error {
+	type plain Practitioner
+	return unmarshalElement(data, (*plain)(e), &e.Extra)
+}
+
+func (e Practitioner) MarshalJSON() ([]byte, error) {
+	type plain Practitioner
+	return marshalElement(plain(e), e.Extra)
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOrganizationRoundTrip(t *testing.T) {
+	original := map[string]interface{}{
+		"resourceType": "Organization",
+		"id":           "org",
+		"name":         "Ministerio de Salud",
+		"_name":        map[string]interface{}{"extension": []interface{}{map[string]interface{}{"url": "http://example.org/translation", "valueCode": "es"}}},
+		"extension": []interface{}{
+			map[string]interface{}{"url": "http://example.org/level", "valueInteger": float64(2)},
+		},
+		"address": []interface{}{
+			map[string]interface{}{"country": "CL", "district": "Santiago"},
+		},
+		"telecom": []interface{}{map[string]interface{}{"system": "phone", "value": "+56 2 2574 0100"}},
+	}
+
+	organization, err := DecodeResource[Organization](original)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if organization.Name != "Ministerio de Salud" || organization.Address[0].Country != "CL" || organization.Extension[0].URL != "http://example.org/level" {
+		t.Errorf("Typed fields were not decoded: %+v", organization)
+	}
+
+	generic, err := EncodeResource(organization)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(original, generic) {
+		a, _ := json.Marshal(original)
+		b, _ := json.Marshal(generic)
+		t.Errorf("Organization did not survive a round trip.\nOriginal: %s\nGot:      %s", a, b)
+	}
+}
+
+func TestBundleRoundTripWithoutIDAndTimestamp(t *testing.T) {
+	original := map[string]interface{}{
+		"resourceType": "Bundle",
+		"type":         "document",
+		"entry":        []interface{}{map[string]interface{}{"fullUrl": "urn:uuid:patient", "resource": map[string]interface{}{"resourceType": "Patient"}}},
+	}
+
+	bundle, err := DecodeResource[Bundle](original)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	generic, err := EncodeResource(bundle)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(original, generic) {
+		a, _ := json.Marshal(original)
+		b, _ := json.Marshal(generic)
+		t.Errorf("Bundle did not survive a round trip.\nOriginal: %s\nGot:      %s", a, b)
+	}
+}
+
+func TestGetIPSCompositionWithoutCoding(t *testing.T) {
+	entries := []Entry{
+		{FullURL: "urn:uuid:other", Resource: map[string]interface{}{"resourceType": "Composition", "type": map[string]interface{}{"text": "Note"}}},
+		{FullURL: "urn:uuid:ips", Resource: map[string]interface{}{
+			"resourceType": "Composition",
+			"type":         coding("http://loinc.org", "60591-5"),
+			"section": []interface{}{
+				map[string]interface{}{"title": "No code"},
+				map[string]interface{}{"code": coding("http://loinc.org", "10160-0")},
+			},
+		}},
+	}
+
+	composition, fullURL, err := getIPSComposition(entries)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fullURL != "urn:uuid:ips" || len(composition.Section) != 1 {
+		t.Errorf("Expected the IPS composition with one coded section, got %s with %d sections", fullURL, len(composition.Section))
+	}
+}
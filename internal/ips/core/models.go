@@ -1,49 +1,36 @@
 package core
 
-type Bundle struct {
-	ID           string                 `json:"id"`
-	Identifier   map[string]interface{} `json:"identifier,omitempty"`
-	Meta         map[string]interface{} `json:"meta,omitempty"`
-	ResourceType string                 `json:"resourceType"`
-	Signature    map[string]interface{} `json:"signature,omitempty"`
-	Timestamp    string                 `json:"timestamp"`
-	Type         string                 `json:"type"`
-	Entry        []Entry                `json:"entry,omitempty"`
-}
-
-type Entry struct {
-	FullURL  string                 `json:"fullUrl"`
-	Resource map[string]interface{} `json:"resource"` // This could be any FHIR resource, it will be treated as a map
-}
+type NodeStatus string
 
-type Composition struct {
-	URL             string                   `json:"url,omitempty"` // This will be here to then convert to Bundle easily
-	ID              string                   `json:"id"`
-	ResourceType    string                   `json:"resourceType"`
-	Text            map[string]interface{}   `json:"text,omitempty"`
-	Meta            map[string]interface{}   `json:"meta,omitempty"`
-	Status          string                   `json:"status,omitempty"`
-	Subject         map[string]interface{}   `json:"subject,omitempty"`
-	Code            map[string]interface{}   `json:"code,omitempty"`
-	Type            CodeableConcept          `json:"type,omitempty"`
-	Author          []map[string]interface{} `json:"author,omitempty"`
-	Confidentiality string                   `json:"confidentiality,omitempty"`
-	Custodian       map[string]interface{}   `json:"custodian,omitempty"`
-	Date            string                   `json:"date,omitempty"`
-	Section         []Section                `json:"section,omitempty"`
-	Title           string                   `json:"title,omitempty"`
-}
+const (
+	NodeStatusOK       NodeStatus = "ok"
+	NodeStatusNotFound NodeStatus = "not_found"
+	NodeStatusTimeout  NodeStatus = "timeout"
+	NodeStatusError    NodeStatus = "error"
+)
 
-type CodeableConcept struct {
-	Coding []map[string]interface{} `json:"coding,omitempty"`
+type NodeIpsResult struct {
+	Node       string                   `json:"node"`
+	Status     NodeStatus               `json:"status"`
+	StatusCode int                      `json:"status_code"`
+	Errors     []map[string]interface{} `json:"errors,omitempty"`
+	IPS        map[string]interface{}   `json:"ips,omitempty"`
 }
 
-type Section struct {
-	Title string                   `json:"title,omitempty"`
-	Code  CodeableConcept          `json:"code,omitempty"`
-	Entry []map[string]interface{} `json:"entry,omitempty"`
+type AggregatedIps struct {
+	Nodes   []NodeIpsResult        `json:"nodes"`
+	Partial bool                   `json:"partial"`
+	Merged  map[string]interface{} `json:"merged,omitempty"`
+	// UnresolvedReferences lists references of the merged bundle that no node bundle could resolve.
+	UnresolvedReferences []string `json:"unresolved_references,omitempty"`
+	// MergeErrors explains why the bundles of the nodes could not be merged, when Merged is missing.
+	MergeErrors []map[string]interface{} `json:"merge_errors,omitempty"`
 }
 
-type Reference struct {
-	Reference string `json:"reference"`
+type MergeResult struct {
+	Bundle map[string]interface{}
+	// UnresolvedReferences lists references that point to resources missing from every merged bundle.
+	UnresolvedReferences []string
+	// Report is only set when requested with MergeOptions.Report.
+	Report *MergeReport
 }
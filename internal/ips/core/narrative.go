@@ -0,0 +1,251 @@
+package core
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// DefaultNarrativeLocale is used for generated narrative when no supported locale is requested.
+const DefaultNarrativeLocale = "en"
+
+// narrativeText holds the fixed strings of a generated narrative in one locale.
+type narrativeText struct {
+	title   string
+	sources string
+	entries string
+	item    string
+	date    string
+	status  string
+	empty   string
+	unknown string
+	// labels of the printable IPS
+	patient    string
+	birthDate  string
+	gender     string
+	identifier string
+	origin     string
+	scan       string
+	generated  string
+}
+
+// narrativeTexts are keyed by the locales accepted for users.
+var narrativeTexts = map[string]narrativeText{
+	"en": {
+		title:   "International Patient Summary",
+		sources: "Assembled from the following documents",
+		entries: "entries",
+		item:    "Item",
+		date:    "Date",
+		status:  "Status",
+		empty:   "No information available",
+		unknown: "Unknown item",
+
+		patient:    "Patient",
+		birthDate:  "Date of birth",
+		gender:     "Gender",
+		identifier: "Identifier",
+		origin:     "Origin",
+		scan:       "Scan to verify",
+		generated:  "Generated on",
+	},
+	"es": {
+		title:   "Resumen Internacional del Paciente",
+		sources: "Generado a partir de los siguientes documentos",
+		entries: "registros",
+		item:    "Elemento",
+		date:    "Fecha",
+		status:  "Estado",
+		empty:   "No hay información disponible",
+		unknown: "Elemento desconocido",
+
+		patient:    "Paciente",
+		birthDate:  "Fecha de nacimiento",
+		gender:     "Sexo",
+		identifier: "Identificador",
+		origin:     "Origen",
+		scan:       "Escanear para verificar",
+		generated:  "Generado el",
+	},
+	"pt-br": {
+		title:   "Sumário Internacional do Paciente",
+		sources: "Gerado a partir dos seguintes documentos",
+		entries: "registros",
+		item:    "Item",
+		date:    "Data",
+		status:  "Situação",
+		empty:   "Nenhuma informação disponível",
+		unknown: "Item desconhecido",
+
+		patient:    "Paciente",
+		birthDate:  "Data de nascimento",
+		gender:     "Sexo",
+		identifier: "Identificador",
+		origin:     "Origem",
+		scan:       "Escaneie para verificar",
+		generated:  "Gerado em",
+	},
+}
+
+// sectionTitles translates the titles of the IPS sections, keyed by LOINC code.
+// Sections not listed here keep the title they came with.
+var sectionTitles = map[string]map[string]string{
+	"10160-0": {"en": "Medication Summary", "es": "Resumen de medicamentos", "pt-br": "Resumo de medicamentos"},
+	"48765-2": {"en": "Allergies and Intolerances", "es": "Alergias e intolerancias", "pt-br": "Alergias e intolerâncias"},
+	"11450-4": {"en": "Problem List", "es": "Lista de problemas", "pt-br": "Lista de problemas"},
+	"11369-6": {"en": "History of Immunizations", "es": "Historial de vacunación", "pt-br": "Histórico de vacinação"},
+	"30954-2": {"en": "Results", "es": "Resultados", "pt-br": "Resultados"},
+	"47519-4": {"en": "History of Procedures", "es": "Historial de procedimientos", "pt-br": "Histórico de procedimentos"},
+	"46264-8": {"en": "Medical Devices", "es": "Dispositivos médicos", "pt-br": "Dispositivos médicos"},
+	"8716-3":  {"en": "Vital Signs", "es": "Signos vitales", "pt-br": "Sinais vitais"},
+	"11348-0": {"en": "History of Past Illness", "es": "Antecedentes de enfermedades", "pt-br": "Histórico de doenças"},
+	"29762-2": {"en": "Social History", "es": "Historia social", "pt-br": "História social"},
+	"10162-6": {"en": "History of Pregnancy", "es": "Historial de embarazos", "pt-br": "Histórico de gestações"},
+	"42348-3": {"en": "Advance Directives", "es": "Voluntades anticipadas", "pt-br": "Diretivas antecipadas"},
+}
+
+var narrativeDateFields = []string{
+	"occurrenceDateTime", "effectiveDateTime", "effectivePeriod.start", "onsetDateTime", "onsetPeriod.start",
+	"performedDateTime", "performedPeriod.start", "recordedDate", "dateAsserted", "issued", "date",
+}
+
+// NarrativeLocale maps a language tag such as "pt-BR" or "es-CL" to a supported narrative locale.
+func NarrativeLocale(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if _, ok := narrativeTexts[tag]; ok {
+		return tag, true
+	}
+	language, _, _ := strings.Cut(tag, "-")
+	if language == "pt" {
+		return "pt-br", true
+	}
+	if _, ok := narrativeTexts[language]; ok {
+		return language, true
+	}
+	return "", false
+}
+
+// generateNarrative rebuilds the narrative of the composition and of every section from their entries,
+// so the human-readable text matches the merged content. References are resolved in sources.
+func generateNarrative(comp *Composition, mergeSources []MergeSource, sources [][]Entry, locale string) {
+	texts, ok := narrativeTexts[locale]
+	if !ok {
+		locale = DefaultNarrativeLocale
+		texts = narrativeTexts[locale]
+	}
+
+	var summary strings.Builder
+	for i := range comp.Section {
+		section := &comp.Section[i]
+		title := sectionTitle(*section, locale)
+
+		var div strings.Builder
+		if len(section.Entry) == 0 {
+			fmt.Fprintf(&div, "<p>%s</p>", html.EscapeString(texts.empty))
+		} else {
+			fmt.Fprintf(&div, "<table><thead><tr><th>%s</th><th>%s</th><th>%s</th></tr></thead><tbody>",
+				html.EscapeString(texts.item), html.EscapeString(texts.date), html.EscapeString(texts.status))
+			for _, e := range section.Entry {
+				ref := e.Reference
+				description, date, status := texts.unknown, "", ""
+				if entry, _ := resolveInSources(ref, -1, sources); entry != nil && entry.Resource != nil {
+					description = resourceDescription(entry.Resource, sources, texts.unknown)
+					date = firstValue(entry.Resource, narrativeDateFields)
+					status = resourceStatus(entry.Resource)
+				}
+				fmt.Fprintf(&div, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>",
+					html.EscapeString(description), html.EscapeString(date), html.EscapeString(status))
+			}
+			div.WriteString("</tbody></table>")
+		}
+		section.Text = xhtmlNarrative(div.String())
+
+		fmt.Fprintf(&summary, "<li>%s: %d %s</li>", html.EscapeString(title), len(section.Entry), html.EscapeString(texts.entries))
+	}
+
+	var div strings.Builder
+	fmt.Fprintf(&div, "<h1>%s</h1>", html.EscapeString(texts.title))
+	if len(mergeSources) > 0 {
+		fmt.Fprintf(&div, "<p>%s:</p><ul>", html.EscapeString(texts.sources))
+		for _, source := range mergeSources {
+			name := source.BundleID
+			if source.Country != "" {
+				name += " (" + source.Country + ")"
+			}
+			fmt.Fprintf(&div, "<li>%s</li>", html.EscapeString(name))
+		}
+		div.WriteString("</ul>")
+	}
+	if summary.Len() > 0 {
+		fmt.Fprintf(&div, "<ul>%s</ul>", summary.String())
+	}
+	comp.Text = xhtmlNarrative(div.String())
+}
+
+func xhtmlNarrative(content string) *Narrative {
+	return &Narrative{
+		Status: "generated",
+		Div:    `<div xmlns="http://www.w3.org/1999/xhtml">` + content + "</div>",
+	}
+}
+
+func sectionTitle(section Section, locale string) string {
+	if title, ok := sectionTitles[section.Code.firstCode()][locale]; ok {
+		return title
+	}
+	return section.Title
+}
+
+// resourceDescription returns a human-readable name for a clinical resource, taken from its main code.
+func resourceDescription(resource map[string]interface{}, sources [][]Entry, fallback string) string {
+	for _, field := range []string{"code", "vaccineCode", "medicationCodeableConcept"} {
+		if text := conceptText(resource[field]); text != "" {
+			return text
+		}
+	}
+	if ref, ok := resource["medicationReference"].(map[string]interface{}); ok {
+		reference, _ := ref["reference"].(string)
+		if medication, _ := resolveInSources(reference, -1, sources); medication != nil {
+			if text := conceptText(medication.Resource["code"]); text != "" {
+				return text
+			}
+		}
+		if display, _ := ref["display"].(string); display != "" {
+			return display
+		}
+	}
+	return fallback
+}
+
+// conceptText returns the text of a CodeableConcept, or the display or code of its first coding.
+func conceptText(concept interface{}) string {
+	cc, ok := concept.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if text, _ := cc["text"].(string); text != "" {
+		return text
+	}
+	codings, _ := cc["coding"].([]interface{})
+	for _, c := range codings {
+		coding, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if display, _ := coding["display"].(string); display != "" {
+			return display
+		}
+		if code, _ := coding["code"].(string); code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+func resourceStatus(resource map[string]interface{}) string {
+	if status := conceptText(resource["clinicalStatus"]); status != "" {
+		return status
+	}
+	status, _ := resource["status"].(string)
+	return status
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNarrativeLocale(t *testing.T) {
+	tests := []struct {
+		tag    string
+		locale string
+		ok     bool
+	}{
+		{tag: "es", locale: "es", ok: true},
+		{tag: "es-CL", locale: "es", ok: true},
+		{tag: "pt-BR", locale: "pt-br", ok: true},
+		{tag: "pt", locale: "pt-br", ok: true},
+		{tag: " EN ", locale: "en", ok: true},
+		{tag: "fr", ok: false},
+	}
+	for _, tt := range tests {
+		locale, ok := NarrativeLocale(tt.tag)
+		if locale != tt.locale || ok != tt.ok {
+			t.Errorf("NarrativeLocale(%q) = %q, %v, want %q, %v", tt.tag, locale, ok, tt.locale, tt.ok)
+		}
+	}
+}
+
+func TestMergeIPSNarrative(t *testing.T) {
+	service := NewService(nil)
+	current := ipsBundle("bundle-cl", ipsComposition("urn:uuid:comp-cl", "urn:uuid:imm-cl"), immunizationEntry("urn:uuid:imm-cl"))
+	current["entry"].([]interface{})[0].(map[string]interface{})["resource"].(map[string]interface{})["text"] = map[string]interface{}{
+		"status": "generated",
+		"div":    `<div xmlns="http://www.w3.org/1999/xhtml">Stale</div>`,
+	}
+	newIps := ipsBundle("bundle-uy", ipsComposition("urn:uuid:comp-uy", "urn:uuid:statement"),
+		map[string]interface{}{"fullUrl": "urn:uuid:statement", "resource": map[string]interface{}{
+			"resourceType":              "MedicationStatement",
+			"status":                    "active",
+			"medicationCodeableConcept": map[string]interface{}{"text": "Paracetamol <500mg>"},
+			"effectiveDateTime":         "2024-03-01",
+		}},
+	)
+
+	result, err := service.MergeIPS(context.Background(), []map[string]interface{}{current, newIps}, MergeOptions{Locale: "es"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	composition := result.Bundle["entry"].([]interface{})[0].(map[string]interface{})["resource"].(map[string]interface{})
+	div := composition["text"].(map[string]interface{})["div"].(string)
+	if strings.Contains(div, "Stale") || !strings.Contains(div, "Resumen Internacional del Paciente") {
+		t.Errorf("Expected a regenerated spanish narrative, got %s", div)
+	}
+
+	section := composition["section"].([]interface{})[0].(map[string]interface{})
+	sectionDiv := section["text"].(map[string]interface{})["div"].(string)
+	for _, want := range []string{"Paracetamol &lt;500mg&gt;", "2024-03-01", "active", "2017-12-11", "<th>Fecha</th>"} {
+		if !strings.Contains(sectionDiv, want) {
+			t.Errorf("Expected section narrative to contain %q, got %s", want, sectionDiv)
+		}
+	}
+}
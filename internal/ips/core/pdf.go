@@ -0,0 +1,257 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"ips-lacpass-backend/pkg/utils"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	pdfMargin     = 15.0
+	pdfLineHeight = 5.0
+	pdfQRSize     = 40.0
+	// pdfQRPixels is the resolution of the embedded QR image, enough to be scanned once printed.
+	pdfQRPixels = 512
+)
+
+// pdfColumns are the widths of the item, date, status and origin columns of a section table, in mm.
+var pdfColumns = []float64{95, 30, 30, 25}
+
+// PDFOptions selects the IPS rendered by GetIpsPDF.
+type PDFOptions struct {
+	// DocumentID selects one of the user's IPS documents, the newest one when empty.
+	DocumentID string
+	// Merge renders the IPS of every node merged into one instead.
+	Merge  bool
+	Locale string
+	// QRPayload is an HC1 payload, such as a VHL, printed as a QR code. When empty, the ICVP of
+	// the first immunization is used if the repository can issue it.
+	QRPayload string
+	// QRLevel is the error-correction level of the printed QR code.
+	QRLevel qrcode.RecoveryLevel
+}
+
+// GetIpsPDF renders the user's IPS as a printable PDF.
+func (is *IpsService) GetIpsPDF(ctx context.Context, opts PDFOptions) ([]byte, error) {
+	var ips map[string]interface{}
+	if opts.Merge {
+		aggregated, err := is.GetIpsFromAllNodes(ctx, true, opts.Locale)
+		if err != nil {
+			return nil, err
+		}
+		if aggregated.Merged == nil && len(aggregated.MergeErrors) > 0 {
+			return nil, &customErrors.HttpError{
+				StatusCode: 502,
+				Body:       aggregated.MergeErrors,
+				Err:        fmt.Errorf("IPS of the nodes could not be merged"),
+			}
+		}
+		if aggregated.Merged == nil {
+			return nil, &customErrors.HttpError{
+				StatusCode: 404,
+				Body:       []map[string]interface{}{{"error": "not_found", "message": "No IPS found for the user"}},
+				Err:        fmt.Errorf("no IPS found in any node"),
+			}
+		}
+		ips = aggregated.Merged
+	} else {
+		var err error
+		ips, err = is.GetIps(ctx, opts.DocumentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	qrPayload := opts.QRPayload
+	if qrPayload == "" && !opts.Merge {
+		qrPayload = is.firstImmunizationICVP(ctx, ips)
+	}
+	return RenderIpsPDF(ips, opts.Locale, qrPayload, opts.QRLevel)
+}
+
+// firstImmunizationICVP issues the ICVP of the first immunization of a repository IPS.
+// The PDF is still rendered without QR when there is none or the repository fails.
+func (is *IpsService) firstImmunizationICVP(ctx context.Context, ips map[string]interface{}) string {
+	projection, err := ProjectIPS(ips, DefaultNarrativeLocale)
+	if err != nil {
+		return ""
+	}
+	for _, section := range projection.Sections {
+		if section.Code != SectionImmunizations || len(section.Items) == 0 {
+			continue
+		}
+		immunizationId := section.Items[0].ID
+		icvp, err := is.GetIpsICVP(ctx, projection.BundleID, &immunizationId)
+		if err != nil {
+			slog.Warn("ICVP not available for IPS PDF", "bundleId", projection.BundleID, "error", err)
+			return ""
+		}
+		return icvp
+	}
+	return ""
+}
+
+// RenderIpsPDF renders an IPS bundle with the patient demographics, every section as a table with
+// the origin country of each entry, and qrPayload as a QR code when not empty.
+func RenderIpsPDF(ips map[string]interface{}, locale string, qrPayload string, qrLevel qrcode.RecoveryLevel) ([]byte, error) {
+	projection, err := ProjectIPS(ips, locale)
+	if err != nil {
+		return nil, err
+	}
+	texts, ok := narrativeTexts[locale]
+	if !ok {
+		texts = narrativeTexts[DefaultNarrativeLocale]
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetTitle(texts.title, true)
+	pdf.AliasNbPages("")
+	// core fonts are cp1252, which covers the accents of the supported locales
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin + 5)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 4, tr(fmt.Sprintf("%s %s - %s", texts.generated, time.Now().UTC().Format("2006-01-02"), projection.BundleID)), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 4, fmt.Sprintf("%d/{nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+
+	pageWidth, _ := pdf.GetPageSize()
+	textWidth := pageWidth - 2*pdfMargin
+	if qrPayload != "" {
+		png, err := utils.QRCodePNG(qrPayload, qrLevel, pdfQRPixels)
+		if err != nil {
+			return nil, err
+		}
+		pdf.RegisterImageOptionsReader("qr", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(png))
+		x := pageWidth - pdfMargin - pdfQRSize
+		pdf.ImageOptions("qr", x, pdfMargin, pdfQRSize, pdfQRSize, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+		pdf.SetXY(x, pdfMargin+pdfQRSize)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.CellFormat(pdfQRSize, 4, tr(texts.scan), "", 0, "C", false, 0, "")
+		pdf.SetXY(pdfMargin, pdfMargin)
+		textWidth -= pdfQRSize + 5
+	}
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.MultiCell(textWidth, 8, tr(texts.title), "", "L", false)
+	pdf.Ln(2)
+	pdf.SetFont("Helvetica", "", 10)
+	for _, line := range patientLines(ips, texts) {
+		pdf.MultiCell(textWidth, pdfLineHeight, tr(line), "", "L", false)
+	}
+	if qrPayload != "" && pdf.GetY() < pdfMargin+pdfQRSize+6 {
+		pdf.SetY(pdfMargin + pdfQRSize + 6)
+	}
+
+	for _, section := range projection.Sections {
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.SetFillColor(225, 232, 240)
+		pdf.CellFormat(0, 7, tr(section.Title), "", 1, "L", true, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		if len(section.Items) == 0 {
+			pdf.CellFormat(0, 6, tr(texts.empty), "", 1, "L", false, 0, "")
+			continue
+		}
+		pdf.SetFont("Helvetica", "B", 9)
+		pdfRow(pdf, tr, []string{texts.item, texts.date, texts.status, texts.origin})
+		pdf.SetFont("Helvetica", "", 9)
+		for _, item := range section.Items {
+			pdfRow(pdf, tr, []string{item.Display, item.Date, item.Status, itemCountries(item)})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("error rendering IPS PDF: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// pdfRow writes a table row whose height fits the longest cell, starting a new page when it does not fit.
+func pdfRow(pdf *fpdf.Fpdf, tr func(string) string, cells []string) {
+	lines := make([][]string, len(cells))
+	height := pdfLineHeight
+	for i, cell := range cells {
+		lines[i] = pdf.SplitText(tr(cell), pdfColumns[i]-2)
+		height = max(height, float64(len(lines[i]))*pdfLineHeight)
+	}
+	_, pageHeight := pdf.GetPageSize()
+	if pdf.GetY()+height > pageHeight-pdfMargin {
+		pdf.AddPage()
+	}
+
+	x, y := pdf.GetXY()
+	for i := range cells {
+		pdf.Rect(x, y, pdfColumns[i], height, "D")
+		pdf.SetXY(x, y)
+		pdf.MultiCell(pdfColumns[i], pdfLineHeight, strings.Join(lines[i], "\n"), "", "L", false)
+		x += pdfColumns[i]
+	}
+	pdf.SetXY(pdfMargin, y+height)
+}
+
+// itemCountries lists the countries an entry came from, from its resource-origin extensions.
+func itemCountries(item ProjectedItem) string {
+	var countries []string
+	for _, origin := range item.Origins {
+		if origin.Country != "" && !slices.Contains(countries, origin.Country) {
+			countries = append(countries, origin.Country)
+		}
+	}
+	return strings.Join(countries, " ")
+}
+
+// patientLines describes the patient of the IPS composition: name, birth date, gender and identifiers.
+func patientLines(ips map[string]interface{}, texts narrativeText) []string {
+	bundle, err := DecodeResource[Bundle](ips)
+	if err != nil {
+		return nil
+	}
+	composition, _, err := getIPSComposition(bundle.Entry)
+	if err != nil || composition.Subject == nil {
+		return nil
+	}
+	entry := resolveReference(composition.Subject.Reference, bundle.Entry)
+	if entry == nil {
+		return nil
+	}
+	patient, err := DecodeResource[Patient](entry.Resource)
+	if err != nil {
+		return nil
+	}
+
+	var lines []string
+	if len(patient.Name) > 0 {
+		name := patient.Name[0].Text
+		if name == "" {
+			name = strings.TrimSpace(strings.Join(patient.Name[0].Given, " ") + " " + patient.Name[0].Family)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", texts.patient, name))
+	}
+	if patient.BirthDate != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", texts.birthDate, patient.BirthDate))
+	}
+	if patient.Gender != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", texts.gender, patient.Gender))
+	}
+	for _, identifier := range patient.Identifier {
+		if identifier.Value != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", texts.identifier, identifier.Value))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+)
+
+func TestRenderIpsPDF(t *testing.T) {
+	immunization := immunizationEntry("urn:uuid:imm")
+	immunization["resource"].(map[string]interface{})["extension"] = []interface{}{originExtension("bundle-cl", "CL")}
+	composition := ipsComposition("urn:uuid:comp")
+	resource := composition["resource"].(map[string]interface{})
+	resource["section"] = append(resource["section"].([]interface{}), map[string]interface{}{
+		"title": "Immunizations",
+		"code":  coding("http://loinc.org", SectionImmunizations),
+		"entry": []interface{}{map[string]interface{}{"reference": "urn:uuid:imm"}},
+	})
+	ips := ipsBundle("bundle", composition, immunization,
+		map[string]interface{}{"fullUrl": "urn:uuid:patient", "resource": map[string]interface{}{
+			"resourceType": "Patient",
+			"name":         []interface{}{map[string]interface{}{"family": "Muñoz", "given": []interface{}{"José"}}},
+			"birthDate":    "1980-02-01",
+			"identifier":   []interface{}{map[string]interface{}{"value": "12345678-9"}},
+		}},
+	)
+
+	for _, qr := range []string{"", "HC1:6BFOXN%TS3DH0YOJ58S S-W5HDC *M0II5XHC9B5G2+$N"} {
+		pdf, err := RenderIpsPDF(ips, "es", qr, qrcode.High)
+		if err != nil {
+			t.Fatalf("Unexpected error rendering with QR %q: %v", qr, err)
+		}
+		if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+			t.Errorf("Expected a PDF document, got %q", pdf[:min(len(pdf), 16)])
+		}
+	}
+}
+
+func TestRenderIpsPDFWithoutComposition(t *testing.T) {
+	_, err := RenderIpsPDF(ipsBundle("bundle", immunizationEntry("urn:uuid:imm")), "en", "", qrcode.High)
+	if err == nil {
+		t.Fatal("Expected an error for an IPS without composition")
+	}
+}
+
+func TestPatientLines(t *testing.T) {
+	ips := ipsBundle("bundle", ipsComposition("urn:uuid:comp"),
+		map[string]interface{}{"fullUrl": "urn:uuid:patient", "resource": map[string]interface{}{
+			"resourceType": "Patient",
+			"name":         []interface{}{map[string]interface{}{"family": "Silva", "given": []interface{}{"Ana", "Maria"}}},
+			"gender":       "female",
+		}},
+	)
+	lines := patientLines(ips, narrativeTexts["pt-br"])
+	if len(lines) != 2 || lines[0] != "Paciente: Ana Maria Silva" || lines[1] != "Sexo: female" {
+		t.Errorf("Unexpected patient lines %q", lines)
+	}
+}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"log/slog"
+	"strings"
+)
+
+// LOINC codes of the IPS sections with their own projection endpoint.
+const (
+	SectionMedications   = "10160-0"
+	SectionAllergies     = "48765-2"
+	SectionImmunizations = "11369-6"
+)
+
+// ProjectedCode is a coding of a projected item.
+type ProjectedCode struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+// ProjectedItem is a section entry flattened for display, with its references resolved.
+type ProjectedItem struct {
+	// ID is the resource id, the one /ips/icvp expects as immunizationId. It falls back to the
+	// last segment of the entry fullUrl for resources without id.
+	ID           string          `json:"id"`
+	FullURL      string          `json:"full_url"`
+	ResourceType string          `json:"resource_type"`
+	Display      string          `json:"display"`
+	Codes        []ProjectedCode `json:"codes"`
+	Date         string          `json:"date,omitempty"`
+	Status       string          `json:"status,omitempty"`
+	// Country is the country of the first IPS the resource came from, Origins lists all of them.
+	Country string            `json:"country,omitempty"`
+	Origins []MergeSource     `json:"origins,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ProjectedSection is an IPS section with its entries flattened for display.
+type ProjectedSection struct {
+	Code  string          `json:"code"`
+	Title string          `json:"title"`
+	Items []ProjectedItem `json:"items"`
+}
+
+// IpsProjection is the display-ready view of the user's IPS.
+type IpsProjection struct {
+	BundleID string             `json:"bundle_id"`
+	Sections []ProjectedSection `json:"sections"`
+}
+
+// IpsSectionProjection is the display-ready view of a single section of the user's IPS.
+type IpsSectionProjection struct {
+	BundleID string          `json:"bundle_id"`
+	Items    []ProjectedItem `json:"items"`
+}
+
+// GetIpsSections returns every section of the user's IPS with its entries resolved and flattened.
+func (is *IpsService) GetIpsSections(ctx context.Context, locale string) (*IpsProjection, error) {
+	ips, err := is.GetIps(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return ProjectIPS(ips, locale)
+}
+
+// GetIpsSection returns the entries of one section of the user's IPS, identified by its LOINC code.
+// A missing section gives an empty list.
+func (is *IpsService) GetIpsSection(ctx context.Context, code string, locale string) (*IpsSectionProjection, error) {
+	projection, err := is.GetIpsSections(ctx, locale)
+	if err != nil {
+		return nil, err
+	}
+	result := &IpsSectionProjection{BundleID: projection.BundleID, Items: []ProjectedItem{}}
+	for _, section := range projection.Sections {
+		if section.Code == code {
+			result.Items = append(result.Items, section.Items...)
+		}
+	}
+	return result, nil
+}
+
+// ProjectIPS flattens the sections of an IPS bundle. Section titles are translated when the locale is supported.
+func ProjectIPS(ips map[string]interface{}, locale string) (*IpsProjection, error) {
+	bundle, err := DecodeResource[Bundle](ips)
+	if err != nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: 502,
+			Body:       []map[string]interface{}{{"error": "invalid_ips", "message": "IPS returned by the repository is malformed"}},
+			Err:        fmt.Errorf("malformed IPS: %w", err),
+		}
+	}
+	composition, _, err := getIPSComposition(bundle.Entry)
+	if err != nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: 502,
+			Body:       []map[string]interface{}{{"error": "invalid_ips", "message": "IPS returned by the repository does not have its composition"}},
+			Err:        err,
+		}
+	}
+	if _, ok := narrativeTexts[locale]; !ok {
+		locale = DefaultNarrativeLocale
+	}
+
+	sources := [][]Entry{bundle.Entry}
+	projection := &IpsProjection{BundleID: bundle.ID, Sections: []ProjectedSection{}}
+	for _, section := range composition.Section {
+		projected := ProjectedSection{Code: section.Code.firstCode(), Title: sectionTitle(section, locale), Items: []ProjectedItem{}}
+		for _, ref := range section.Entry {
+			entry, _ := resolveInSources(ref.Reference, 0, sources)
+			if entry == nil || entry.Resource == nil {
+				slog.Warn("IPS section entry does not resolve", "bundleId", bundle.ID, "reference", ref.Reference)
+				continue
+			}
+			projected.Items = append(projected.Items, projectEntry(*entry, sources))
+		}
+		projection.Sections = append(projection.Sections, projected)
+	}
+	return projection, nil
+}
+
+func projectEntry(entry Entry, sources [][]Entry) ProjectedItem {
+	resource := entry.Resource
+	rtype, _ := resource["resourceType"].(string)
+	id, _ := resource["id"].(string)
+	if id == "" {
+		id = entry.FullURL[strings.LastIndexAny(entry.FullURL, "/:")+1:]
+	}
+
+	item := ProjectedItem{
+		ID:           id,
+		FullURL:      entry.FullURL,
+		ResourceType: rtype,
+		Display:      resourceDescription(resource, sources, rtype),
+		Codes:        []ProjectedCode{},
+		Date:         firstValue(resource, narrativeDateFields),
+		Status:       resourceStatus(resource),
+		Origins:      originsOf(resource),
+	}
+	if len(item.Origins) > 0 {
+		item.Country = item.Origins[0].Country
+	}
+
+	for _, field := range []string{"code", "vaccineCode", "medicationCodeableConcept"} {
+		item.Codes = append(item.Codes, projectedCodes(resource[field])...)
+	}
+	if ref, ok := resource["medicationReference"].(map[string]interface{}); ok {
+		reference, _ := ref["reference"].(string)
+		if medication, _ := resolveInSources(reference, 0, sources); medication != nil {
+			item.Codes = append(item.Codes, projectedCodes(medication.Resource["code"])...)
+		}
+	}
+	item.Details = projectedDetails(rtype, resource)
+	return item
+}
+
+func projectedCodes(concept interface{}) []ProjectedCode {
+	cc, ok := concept.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	typed, err := DecodeResource[CodeableConcept](cc)
+	if err != nil {
+		return nil
+	}
+	var codes []ProjectedCode
+	for _, c := range typed.Coding {
+		if c.Code == "" {
+			continue
+		}
+		codes = append(codes, ProjectedCode{System: c.System, Code: c.Code, Display: c.Display})
+	}
+	return codes
+}
+
+// projectedDetails adds the type specific values the app shows next to an item.
+func projectedDetails(rtype string, resource map[string]interface{}) map[string]string {
+	details := map[string]string{}
+	switch rtype {
+	case "Immunization":
+		if immunization, err := DecodeResource[Immunization](resource); err == nil && immunization.LotNumber != "" {
+			details["lot_number"] = immunization.LotNumber
+		}
+	case "AllergyIntolerance":
+		if allergy, err := DecodeResource[AllergyIntolerance](resource); err == nil {
+			if allergy.Criticality != "" {
+				details["criticality"] = allergy.Criticality
+			}
+			if allergy.Type != "" {
+				details["type"] = allergy.Type
+			}
+			if len(allergy.Category) > 0 {
+				details["category"] = strings.Join(allergy.Category, ", ")
+			}
+		}
+	case "MedicationStatement":
+		if dosages, ok := resource["dosage"].([]interface{}); ok && len(dosages) > 0 {
+			if dosage, ok := dosages[0].(map[string]interface{}); ok {
+				if text, _ := dosage["text"].(string); text != "" {
+					details["dosage"] = text
+				}
+			}
+		}
+	case "Condition":
+		if severity := conceptText(resource["severity"]); severity != "" {
+			details["severity"] = severity
+		}
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	return details
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestProjectIPS(t *testing.T) {
+	composition := ipsComposition("urn:uuid:comp", "urn:uuid:statement")
+	resource := composition["resource"].(map[string]interface{})
+	resource["section"] = append(resource["section"].([]interface{}), map[string]interface{}{
+		"title": "Immunizations",
+		"code":  coding("http://loinc.org", SectionImmunizations),
+		"entry": []interface{}{map[string]interface{}{"reference": "Immunization/imm-1"}},
+	})
+	immunization := immunizationEntry("http://node.cl/fhir/Immunization/imm-1")
+	immunization["resource"].(map[string]interface{})["id"] = "imm-1"
+	immunization["resource"].(map[string]interface{})["lotNumber"] = "AB123"
+	immunization["resource"].(map[string]interface{})["extension"] = []interface{}{originExtension("bundle-cl", "CL")}
+
+	ips := ipsBundle("bundle", composition, immunization,
+		map[string]interface{}{"fullUrl": "urn:uuid:statement", "resource": map[string]interface{}{
+			"resourceType":        "MedicationStatement",
+			"status":              "active",
+			"medicationReference": map[string]interface{}{"reference": "urn:uuid:medication"},
+			"dosage":              []interface{}{map[string]interface{}{"text": "1 tablet daily"}},
+		}},
+		map[string]interface{}{"fullUrl": "urn:uuid:medication", "resource": map[string]interface{}{
+			"resourceType": "Medication",
+			"code": map[string]interface{}{"coding": []interface{}{
+				map[string]interface{}{"system": "http://snomed.info/sct", "code": "387517004", "display": "Paracetamol"},
+			}},
+		}},
+	)
+
+	projection, err := ProjectIPS(ips, "es")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if projection.BundleID != "bundle" || len(projection.Sections) != 2 {
+		t.Fatalf("Expected two sections of bundle, got %+v", projection)
+	}
+
+	medications := projection.Sections[0]
+	if medications.Title != "Resumen de medicamentos" || len(medications.Items) != 1 {
+		t.Fatalf("Expected the translated medication section with one item, got %+v", medications)
+	}
+	statement := medications.Items[0]
+	if statement.ID != "statement" || statement.Display != "Paracetamol" || statement.Status != "active" ||
+		len(statement.Codes) != 1 || statement.Codes[0].Code != "387517004" || statement.Details["dosage"] != "1 tablet daily" {
+		t.Errorf("Unexpected medication item %+v", statement)
+	}
+
+	immunizations := projection.Sections[1]
+	if len(immunizations.Items) != 1 {
+		t.Fatalf("Expected one immunization, got %+v", immunizations)
+	}
+	vaccine := immunizations.Items[0]
+	if vaccine.ID != "imm-1" || vaccine.Country != "CL" || vaccine.Date != "2017-12-11" || vaccine.Details["lot_number"] != "AB123" {
+		t.Errorf("Unexpected immunization item %+v", vaccine)
+	}
+}
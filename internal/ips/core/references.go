@@ -0,0 +1,132 @@
+package core
+
+import (
+	"sort"
+	"strings"
+)
+
+// collectReferences returns every Reference.reference value found in a FHIR element, at any depth.
+// Map keys are visited in order so the result is deterministic. Contained references ("#id") are skipped
+// since they resolve inside the resource itself.
+func collectReferences(element interface{}) []string {
+	var refs []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if ref, ok := t[k].(string); ok && k == "reference" {
+					if ref != "" && !strings.HasPrefix(ref, "#") {
+						refs = append(refs, ref)
+					}
+					continue
+				}
+				walk(t[k])
+			}
+		case []interface{}:
+			for _, item := range t {
+				walk(item)
+			}
+		case []map[string]interface{}:
+			for _, item := range t {
+				walk(item)
+			}
+		}
+	}
+	walk(element)
+	return refs
+}
+
+// resolveReference finds the entry a reference points to. Absolute references and urn:uuid
+// references match the entry fullUrl, relative ones ("Type/id") match the resource type and id.
+func resolveReference(reference string, entries []Entry) *Entry {
+	relative := strings.Split(reference, "/_history/")[0]
+	for i := range entries {
+		e := &entries[i]
+		if e.FullURL == reference {
+			return e
+		}
+		if e.Resource == nil || strings.Contains(relative, ":") {
+			continue
+		}
+		rtype, _ := e.Resource["resourceType"].(string)
+		id, _ := e.Resource["id"].(string)
+		if id != "" && rtype+"/"+id == relative {
+			return e
+		}
+		if strings.HasSuffix(e.FullURL, "/"+relative) {
+			return e
+		}
+	}
+	return nil
+}
+
+// resolveClosure walks the reference graph starting at root and returns every entry reachable from it,
+// in breadth first order. The root itself, identified by rootURL, is never part of the closure.
+// Each source is a bundle's entries. References are resolved first in the bundle the referring
+// resource came from, then in the others in order, so ids reused across bundles resolve to the
+// right resource. References that cannot be resolved are returned instead of dropped.
+func resolveClosure(root map[string]interface{}, rootURL string, sources ...[]Entry) ([]Entry, []string) {
+	type node struct {
+		resource map[string]interface{}
+		source   int
+	}
+
+	var closure []Entry
+	var unresolved []string
+	visited := map[string]bool{rootURL: true}
+	missing := map[string]bool{}
+	queue := []node{{resource: root, source: -1}}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, ref := range collectReferences(current.resource) {
+			entry, source := resolveInSources(ref, current.source, sources)
+			if entry == nil {
+				if !missing[ref] {
+					missing[ref] = true
+					unresolved = append(unresolved, ref)
+				}
+				continue
+			}
+
+			key := entry.FullURL
+			if key == "" {
+				key = ref
+			}
+			if visited[key] {
+				continue
+			}
+			visited[key] = true
+			closure = append(closure, *entry)
+			if entry.Resource != nil {
+				queue = append(queue, node{resource: entry.Resource, source: source})
+			}
+		}
+	}
+	return closure, unresolved
+}
+
+func resolveInSources(reference string, preferred int, sources [][]Entry) (*Entry, int) {
+	if preferred >= 0 && preferred < len(sources) {
+		if e := resolveReference(reference, sources[preferred]); e != nil {
+			return e, preferred
+		}
+	}
+	for i, entries := range sources {
+		if i == preferred {
+			continue
+		}
+		if e := resolveReference(reference, entries); e != nil {
+			return e, i
+		}
+	}
+	return nil, -1
+}
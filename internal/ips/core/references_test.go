@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestCollectReferences(t *testing.T) {
+	resource := map[string]interface{}{
+		"resourceType":        "MedicationStatement",
+		"subject":             map[string]interface{}{"reference": "urn:uuid:patient"},
+		"medicationReference": map[string]interface{}{"reference": "urn:uuid:medication"},
+		"contained":           []interface{}{map[string]interface{}{"reference": "#contained"}},
+		"informationSource": map[string]interface{}{
+			"reference": "urn:uuid:practitioner",
+			"display":   "Dr. Who",
+		},
+		"performer": []interface{}{
+			map[string]interface{}{"actor": map[string]interface{}{"reference": "Organization/org-1"}},
+		},
+	}
+
+	refs := collectReferences(resource)
+	expected := []string{"urn:uuid:practitioner", "urn:uuid:medication", "Organization/org-1", "urn:uuid:patient"}
+	if !slices.Equal(refs, expected) {
+		t.Errorf("Expected %v, got %v", expected, refs)
+	}
+}
+
+func TestResolveClosure(t *testing.T) {
+	current := []Entry{
+		{FullURL: "urn:uuid:patient", Resource: map[string]interface{}{"resourceType": "Patient", "id": "patient"}},
+		{FullURL: "urn:uuid:statement", Resource: map[string]interface{}{
+			"resourceType":        "MedicationStatement",
+			"subject":             map[string]interface{}{"reference": "urn:uuid:patient"},
+			"medicationReference": map[string]interface{}{"reference": "Medication/med-1"},
+		}},
+		{FullURL: "http://fhir/Medication/med-1", Resource: map[string]interface{}{
+			"resourceType": "Medication",
+			"id":           "med-1",
+			"manufacturer": map[string]interface{}{"reference": "urn:uuid:missing"},
+		}},
+	}
+	root := map[string]interface{}{
+		"resourceType": "Composition",
+		"subject":      map[string]interface{}{"reference": "urn:uuid:patient"},
+		"section": []interface{}{
+			map[string]interface{}{"entry": []interface{}{map[string]interface{}{"reference": "urn:uuid:statement"}}},
+		},
+	}
+
+	closure, unresolved := resolveClosure(root, "urn:uuid:composition", current)
+
+	var urls []string
+	for _, e := range closure {
+		urls = append(urls, e.FullURL)
+	}
+	expected := []string{"urn:uuid:statement", "urn:uuid:patient", "http://fhir/Medication/med-1"}
+	if !slices.Equal(urls, expected) {
+		t.Errorf("Expected closure %v, got %v", expected, urls)
+	}
+	if !slices.Equal(unresolved, []string{"urn:uuid:missing"}) {
+		t.Errorf("Expected urn:uuid:missing to be unresolved, got %v", unresolved)
+	}
+}
+
+func TestResolveClosurePrefersReferringBundle(t *testing.T) {
+	current := []Entry{
+		{FullURL: "urn:uuid:a", Resource: map[string]interface{}{"resourceType": "Condition", "subject": map[string]interface{}{"reference": "Patient/1"}}},
+		{FullURL: "http://cl/Patient/1", Resource: map[string]interface{}{"resourceType": "Patient", "id": "1"}},
+	}
+	other := []Entry{
+		{FullURL: "urn:uuid:b", Resource: map[string]interface{}{"resourceType": "Condition", "subject": map[string]interface{}{"reference": "Patient/1"}}},
+		{FullURL: "http://uy/Patient/1", Resource: map[string]interface{}{"resourceType": "Patient", "id": "1"}},
+	}
+	root := map[string]interface{}{
+		"section": []interface{}{
+			map[string]interface{}{"entry": []interface{}{
+				map[string]interface{}{"reference": "urn:uuid:a"},
+				map[string]interface{}{"reference": "urn:uuid:b"},
+			}},
+		},
+	}
+
+	closure, unresolved := resolveClosure(root, "", current, other)
+	if len(unresolved) != 0 {
+		t.Fatalf("Expected no unresolved references, got %v", unresolved)
+	}
+	var urls []string
+	for _, e := range closure {
+		urls = append(urls, e.FullURL)
+	}
+	expected := []string{"urn:uuid:a", "urn:uuid:b", "http://cl/Patient/1", "http://uy/Patient/1"}
+	if !slices.Equal(urls, expected) {
+		t.Errorf("Expected closure %v, got %v", expected, urls)
+	}
+}
+
+func ipsBundle(id string, entries ...map[string]interface{}) map[string]interface{} {
+	var e []interface{}
+	for _, entry := range entries {
+		e = append(e, entry)
+	}
+	return map[string]interface{}{
+		"resourceType": "Bundle",
+		"id":           id,
+		"type":         "document",
+		"entry":        e,
+	}
+}
+
+func ipsComposition(fullURL string, sectionRefs ...string) map[string]interface{} {
+	var refs []interface{}
+	for _, ref := range sectionRefs {
+		refs = append(refs, map[string]interface{}{"reference": ref})
+	}
+	return map[string]interface{}{
+		"fullUrl": fullURL,
+		"resource": map[string]interface{}{
+			"resourceType": "Composition",
+			"id":           fullURL,
+			"type":         map[string]interface{}{"coding": []interface{}{map[string]interface{}{"system": "http://loinc.org", "code": "60591-5"}}},
+			"subject":      map[string]interface{}{"reference": "urn:uuid:patient"},
+			"section": []interface{}{
+				map[string]interface{}{
+					"title": "Medication Summary",
+					"code":  map[string]interface{}{"coding": []interface{}{map[string]interface{}{"system": "http://loinc.org", "code": "10160-0"}}},
+					"entry": refs,
+				},
+			},
+		},
+	}
+}
+
+func TestMergeIPSUnresolvedReferences(t *testing.T) {
+	service := NewService(nil)
+	current := ipsBundle("current",
+		ipsComposition("urn:uuid:comp-1", "urn:uuid:missing-statement", "urn:uuid:statement"),
+		map[string]interface{}{"fullUrl": "urn:uuid:patient", "resource": map[string]interface{}{"resourceType": "Patient"}},
+		map[string]interface{}{"fullUrl": "urn:uuid:statement", "resource": map[string]interface{}{
+			"resourceType":        "MedicationStatement",
+			"medicationReference": map[string]interface{}{"reference": "urn:uuid:medication"},
+		}},
+		map[string]interface{}{"fullUrl": "urn:uuid:medication", "resource": map[string]interface{}{"resourceType": "Medication"}},
+	)
+	newIps := ipsBundle("new", ipsComposition("urn:uuid:comp-2"))
+
+	result, err := service.MergeIPS(context.Background(), []map[string]interface{}{current, newIps}, MergeOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(result.UnresolvedReferences, []string{"urn:uuid:missing-statement"}) {
+		t.Errorf("Expected urn:uuid:missing-statement to be unresolved, got %v", result.UnresolvedReferences)
+	}
+
+	entries := result.Bundle["entry"].([]interface{})
+	var urls []string
+	for _, e := range entries {
+		urls = append(urls, e.(map[string]interface{})["fullUrl"].(string))
+	}
+	for _, url := range []string{"urn:uuid:comp-1", "urn:uuid:patient", "urn:uuid:statement", "urn:uuid:medication"} {
+		if !slices.Contains(urls, url) {
+			t.Errorf("Expected %s in merged bundle, got %v", url, urls)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MergeOptions tunes a MergeIPS call.
+type MergeOptions struct {
+	// Report asks MergeIPS to describe what it did in MergeResult.Report.
+	Report bool
+	// Locale of the generated narrative, one of "es", "en" or "pt-br". Defaults to DefaultNarrativeLocale.
+	Locale string
+	// Validate runs ValidateIPS on every bundle first and refuses to merge invalid ones.
+	Validate bool
+}
+
+// MergeSource is one of the IPS bundles combined by a merge.
+type MergeSource struct {
+	BundleID string `json:"bundle_id"`
+	Country  string `json:"country,omitempty"`
+}
+
+// MergedResource is an entry of the merged bundle together with the IPSs it was found in.
+type MergedResource struct {
+	FullURL      string        `json:"full_url"`
+	ResourceType string        `json:"resource_type"`
+	Origins      []MergeSource `json:"origins,omitempty"`
+}
+
+// MergeDuplicate is a section entry that was dropped because it was already in the merged IPS.
+type MergeDuplicate struct {
+	Reference     string `json:"reference"`
+	KeptReference string `json:"kept_reference"`
+	// Reason is "same_reference" when both IPSs point to the same resource, or "clinical_match"
+	// when two resources record the same clinical fact.
+	Reason   string `json:"reason"`
+	BundleID string `json:"bundle_id"`
+}
+
+// MergeConflict is a resource left out of the merged bundle because another resource, kept in it, has
+// the same fullUrl. The bundles are read from the origin extensions of both resources.
+type MergeConflict struct {
+	FullURL      string `json:"full_url"`
+	BundleID     string `json:"bundle_id"`
+	KeptBundleID string `json:"kept_bundle_id"`
+}
+
+// MergedSection is a section added to the merged Composition because the current IPS did not have it.
+type MergedSection struct {
+	Title    string `json:"title,omitempty"`
+	Code     string `json:"code"`
+	BundleID string `json:"bundle_id"`
+}
+
+type MergeReport struct {
+	Sources              []MergeSource    `json:"sources"`
+	Resources            []MergedResource `json:"resources"`
+	Duplicates           []MergeDuplicate `json:"duplicates"`
+	CreatedSections      []MergedSection  `json:"created_sections"`
+	Conflicts            []MergeConflict  `json:"conflicts"`
+	UnresolvedReferences []string         `json:"unresolved_references"`
+}
+
+const (
+	duplicateSameReference = "same_reference"
+	duplicateClinicalMatch = "clinical_match"
+)
+
+// originsOf reads the sources of a resource from its resource-origin extensions.
+func originsOf(resource map[string]interface{}) []MergeSource {
+	extensions, _ := resource["extension"].([]interface{})
+	var origins []MergeSource
+	for _, ext := range extensions {
+		extMap, ok := ext.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if url, _ := extMap["url"].(string); url != originExtensionURL {
+			continue
+		}
+		bundleID, country := originValues(extMap)
+		origins = append(origins, MergeSource{BundleID: bundleID, Country: country})
+	}
+	return origins
+}
+
+// buildProvenance creates a Provenance resource stating that the composition was assembled from the sources.
+// Each source bundle is an entity carrying its id and country in a resource-origin extension.
+func buildProvenance(compositionURL string, sources []MergeSource) Entry {
+	var entities []interface{}
+	for _, source := range sources {
+		display := "IPS " + source.BundleID
+		if source.Country != "" {
+			display += " (" + source.Country + ")"
+		}
+		entities = append(entities, map[string]interface{}{
+			"role": "source",
+			"what": map[string]interface{}{
+				"type":       "Bundle",
+				"identifier": map[string]interface{}{"value": source.BundleID},
+				"display":    display,
+			},
+			"extension": []interface{}{originExtension(source.BundleID, source.Country)},
+		})
+	}
+
+	id := uuid.NewString()
+	return Entry{
+		FullURL: "urn:uuid:" + id,
+		Resource: map[string]interface{}{
+			"resourceType": "Provenance",
+			"id":           id,
+			"target":       []interface{}{map[string]interface{}{"reference": compositionURL}},
+			"recorded":     time.Now().UTC().Format(time.RFC3339),
+			"agent": []interface{}{
+				map[string]interface{}{
+					"type": map[string]interface{}{
+						"coding": []interface{}{
+							map[string]interface{}{
+								"system":  "http://terminology.hl7.org/CodeSystem/provenance-participant-type",
+								"code":    "assembler",
+								"display": "Assembler",
+							},
+						},
+					},
+					"who": map[string]interface{}{"display": "IPS Lacpass backend"},
+				},
+			},
+			"entity": entities,
+		},
+	}
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"context"
+	"errors"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"strings"
+	"testing"
+)
+
+func organizationEntry(fullURL string, country string) map[string]interface{} {
+	return map[string]interface{}{
+		"fullUrl": fullURL,
+		"resource": map[string]interface{}{
+			"resourceType": "Organization",
+			"address":      []interface{}{map[string]interface{}{"country": country}},
+		},
+	}
+}
+
+func immunizationEntry(fullURL string) map[string]interface{} {
+	return map[string]interface{}{
+		"fullUrl": fullURL,
+		"resource": map[string]interface{}{
+			"resourceType":       "Immunization",
+			"vaccineCode":        coding("http://hl7.org/fhir/sid/cvx", "37"),
+			"occurrenceDateTime": "2017-12-11",
+		},
+	}
+}
+
+func TestMergeIPSReport(t *testing.T) {
+	service := NewService(nil)
+
+	current := ipsBundle("bundle-cl",
+		ipsComposition("urn:uuid:comp-cl", "urn:uuid:imm-cl"),
+		immunizationEntry("urn:uuid:imm-cl"),
+		organizationEntry("urn:uuid:org-cl", "CL"),
+	)
+	newComposition := ipsComposition("urn:uuid:comp-uy", "urn:uuid:imm-uy")
+	sections := newComposition["resource"].(map[string]interface{})["section"].([]interface{})
+	newComposition["resource"].(map[string]interface{})["section"] = append(sections, map[string]interface{}{
+		"title": "Allergies",
+		"code":  map[string]interface{}{"coding": []interface{}{map[string]interface{}{"system": "http://loinc.org", "code": "48765-2"}}},
+	})
+	newIps := ipsBundle("bundle-uy",
+		newComposition,
+		immunizationEntry("urn:uuid:imm-uy"),
+		organizationEntry("urn:uuid:org-uy", "UY"),
+	)
+
+	result, err := service.MergeIPS(context.Background(), []map[string]interface{}{current, newIps}, MergeOptions{Report: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	report := result.Report
+	if report == nil {
+		t.Fatalf("Expected a merge report")
+	}
+
+	if len(report.Sources) != 2 || report.Sources[0].Country != "CL" || report.Sources[1].Country != "UY" {
+		t.Errorf("Expected CL and UY sources, got %v", report.Sources)
+	}
+	if len(report.Duplicates) != 1 || report.Duplicates[0].Reference != "urn:uuid:imm-uy" || report.Duplicates[0].Reason != duplicateClinicalMatch {
+		t.Errorf("Expected urn:uuid:imm-uy to be dropped as a clinical match, got %v", report.Duplicates)
+	}
+	if len(report.CreatedSections) != 1 || report.CreatedSections[0].Code != "48765-2" {
+		t.Errorf("Expected the allergies section to be created, got %v", report.CreatedSections)
+	}
+
+	var provenance, immunization *MergedResource
+	for i, r := range report.Resources {
+		switch r.ResourceType {
+		case "Provenance":
+			provenance = &report.Resources[i]
+		case "Immunization":
+			immunization = &report.Resources[i]
+		}
+	}
+	if provenance == nil {
+		t.Fatalf("Expected a Provenance resource in the merged bundle")
+	}
+	if immunization == nil || len(immunization.Origins) != 2 {
+		t.Errorf("Expected the kept immunization to have both origins, got %v", immunization)
+	}
+}
+
+func TestMergeIPSWithoutReport(t *testing.T) {
+	service := NewService(nil)
+	current := ipsBundle("current", ipsComposition("urn:uuid:comp-1"))
+	newIps := ipsBundle("new", ipsComposition("urn:uuid:comp-2"))
+
+	result, err := service.MergeIPS(context.Background(), []map[string]interface{}{current, newIps}, MergeOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Report != nil {
+		t.Errorf("Expected no report when it is not requested")
+	}
+}
+
+func TestMergeIPSMultipleBundles(t *testing.T) {
+	service := NewService(nil)
+	bundles := []map[string]interface{}{
+		ipsBundle("bundle-cl", ipsComposition("urn:uuid:comp-cl"), organizationEntry("urn:uuid:org-cl", "CL")),
+		ipsBundle("bundle-uy", ipsComposition("urn:uuid:comp-uy", "urn:uuid:imm-uy"), immunizationEntry("urn:uuid:imm-uy"), organizationEntry("urn:uuid:org-uy", "UY")),
+		ipsBundle("bundle-ar", ipsComposition("urn:uuid:comp-ar", "urn:uuid:imm-ar"), immunizationEntry("urn:uuid:imm-ar"), organizationEntry("urn:uuid:org-ar", "AR")),
+	}
+
+	result, err := service.MergeIPS(context.Background(), bundles, MergeOptions{Report: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var compositions []string
+	for _, e := range result.Bundle["entry"].([]interface{}) {
+		entry := e.(map[string]interface{})
+		if entry["resource"].(map[string]interface{})["resourceType"] == "Composition" {
+			compositions = append(compositions, entry["fullUrl"].(string))
+		}
+	}
+	if len(compositions) != 1 || compositions[0] != "urn:uuid:comp-cl" {
+		t.Errorf("Expected only the composition of the first bundle, got %v", compositions)
+	}
+
+	var sources []string
+	for _, s := range result.Report.Sources {
+		sources = append(sources, s.Country)
+	}
+	if strings.Join(sources, ",") != "CL,UY,AR" {
+		t.Errorf("Expected sources in bundle order, got %v", sources)
+	}
+	if len(result.Report.Duplicates) != 1 || result.Report.Duplicates[0].Reference != "urn:uuid:imm-ar" {
+		t.Errorf("Expected the immunization of the last bundle to be dropped, got %v", result.Report.Duplicates)
+	}
+}
+
+func TestMergeIPSOrderDecidesConflicts(t *testing.T) {
+	service := NewService(nil)
+	uy := ipsBundle("bundle-uy", ipsComposition("urn:uuid:comp-uy", "urn:uuid:imm-uy"), immunizationEntry("urn:uuid:imm-uy"))
+	ar := ipsBundle("bundle-ar", ipsComposition("urn:uuid:comp-ar", "urn:uuid:imm-ar"), immunizationEntry("urn:uuid:imm-ar"))
+
+	for _, tc := range []struct {
+		bundles []map[string]interface{}
+		kept    string
+	}{
+		{bundles: []map[string]interface{}{uy, ar}, kept: "urn:uuid:imm-uy"},
+		{bundles: []map[string]interface{}{ar, uy}, kept: "urn:uuid:imm-ar"},
+	} {
+		result, err := service.MergeIPS(context.Background(), tc.bundles, MergeOptions{Report: true})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(result.Report.Duplicates) != 1 || result.Report.Duplicates[0].KeptReference != tc.kept {
+			t.Errorf("Expected %s to be kept, got %v", tc.kept, result.Report.Duplicates)
+		}
+	}
+}
+
+func TestMergeIPSNeedsTwoBundles(t *testing.T) {
+	service := NewService(nil)
+	_, err := service.MergeIPS(context.Background(), []map[string]interface{}{ipsBundle("only", ipsComposition("urn:uuid:comp"))}, MergeOptions{})
+
+	var httpErr *customErrors.HttpError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 400 {
+		t.Errorf("Expected a 400 error, got %v", err)
+	}
+}
+
+func TestMergeIPSResolvesSectionEntriesInTheirBundle(t *testing.T) {
+	service := NewService(nil)
+	immunization := func(fullURL string, id string, vaccine string) map[string]interface{} {
+		entry := immunizationEntry(fullURL)
+		resource := entry["resource"].(map[string]interface{})
+		resource["id"] = id
+		resource["vaccineCode"] = coding("http://hl7.org/fhir/sid/cvx", vaccine)
+		return entry
+	}
+	// CL and UY both have an Immunization/1, only the one of UY is a section entry
+	bundles := []map[string]interface{}{
+		ipsBundle("bundle-cl", ipsComposition("urn:uuid:comp-cl", "urn:uuid:imm-cl"), immunization("urn:uuid:imm-cl", "cl", "20"), immunization("https://cl.example/fhir/Immunization/1", "1", "37")),
+		ipsBundle("bundle-uy", ipsComposition("urn:uuid:comp-uy", "Immunization/1"), immunization("https://uy.example/fhir/Immunization/1", "1", "08")),
+		ipsBundle("bundle-ar", ipsComposition("urn:uuid:comp-ar", "urn:uuid:imm-ar"), immunization("urn:uuid:imm-ar", "ar", "08")),
+	}
+
+	result, err := service.MergeIPS(context.Background(), bundles, MergeOptions{Report: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	duplicates := result.Report.Duplicates
+	if len(duplicates) != 1 || duplicates[0].Reference != "urn:uuid:imm-ar" || duplicates[0].KeptReference != "Immunization/1" || duplicates[0].BundleID != "bundle-ar" {
+		t.Errorf("Expected the immunization of AR to match the one of UY, got %v", duplicates)
+	}
+}
+
+func TestMergeIPSReportsFullURLConflicts(t *testing.T) {
+	service := NewService(nil)
+	bundles := []map[string]interface{}{
+		ipsBundle("bundle-cl", ipsComposition("urn:uuid:comp-cl"), organizationEntry("urn:uuid:org", "CL"), organizationEntry("urn:uuid:org-shared", "XX")),
+		ipsBundle("bundle-uy", ipsComposition("urn:uuid:comp-uy"), organizationEntry("urn:uuid:org", "UY"), organizationEntry("urn:uuid:org-shared", "XX")),
+	}
+
+	result, err := service.MergeIPS(context.Background(), bundles, MergeOptions{Report: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conflicts := result.Report.Conflicts
+	if len(conflicts) != 1 || conflicts[0].FullURL != "urn:uuid:org" || conflicts[0].BundleID != "bundle-uy" || conflicts[0].KeptBundleID != "bundle-cl" {
+		t.Errorf("Expected the organization of UY to conflict with the one of CL, got %v", conflicts)
+	}
+}
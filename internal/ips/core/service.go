@@ -2,30 +2,41 @@ package core
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"ips-lacpass-backend/internal/ips/client"
 	customErrors "ips-lacpass-backend/pkg/errors"
 	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"ips-lacpass-backend/pkg/utils"
 	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
 	"slices"
 	"sort"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/mitchellh/mapstructure"
 )
 
 type IpsService struct {
 	DefaultRepository *client.IpsClient
 	Repositories      map[string]*client.IpsClient
+	// NodeTimeout bounds each node request when fetching the IPS from all nodes.
+	NodeTimeout time.Duration
+	// DedupStrategy decides which record wins when MergeIPS finds the same clinical fact twice.
+	DedupStrategy DedupStrategy
+	// Signer signs merged bundles when set. Without it merged bundles are not signed.
+	Signer *BundleSigner
 }
 
 func NewService(r *client.IpsClient) IpsService {
 	return IpsService{
 		DefaultRepository: r,
 		Repositories:      make(map[string]*client.IpsClient),
+		NodeTimeout:       10 * time.Second,
+		DedupStrategy:     DedupKeepCurrent,
 	}
 }
 
@@ -39,7 +50,9 @@ func (is *IpsService) getClient(ctx context.Context) *client.IpsClient {
 	return is.DefaultRepository
 }
 
-func (is *IpsService) GetIps(ctx context.Context) (map[string]interface{}, error) {
+// GetIps returns the user's IPS. documentID selects one of the DocumentReferences listed by
+// ListDocuments, an empty documentID returns the newest one.
+func (is *IpsService) GetIps(ctx context.Context, documentID string) (map[string]interface{}, error) {
 	userId, err := authMiddleware.GetUserDocIDFromContext(ctx)
 	if err != nil {
 		slog.Error("User identifier not found in context", "error", err)
@@ -50,15 +63,18 @@ func (is *IpsService) GetIps(ctx context.Context) (map[string]interface{}, error
 		}
 	}
 
-	slog.Info("Fetching IPS for user", "userId", userId)
-	repo := is.getClient(ctx)
-	bundle, err := repo.GetDocumentReference(userId)
+	slog.Info("Fetching IPS for user", "userId", userId, "documentId", documentID)
+	return fetchIps(ctx, is.getClient(ctx), userId, documentID)
+}
+
+// fetchIps returns the IPS bundle of a DocumentReference of the user in a repository,
+// the newest one when documentID is empty.
+func fetchIps(ctx context.Context, repo *client.IpsClient, userId string, documentID string) (map[string]interface{}, error) {
+	documents, err := listDocumentReferences(ctx, repo, userId)
 	if err != nil {
-		slog.Error("Error fetching document reference", "userId", userId, "error", err)
 		return nil, err
 	}
-	entries := bundle.Entry
-	if len(entries) == 0 {
+	if len(documents) == 0 {
 		slog.Warn("No IPS found for user", "userId", userId)
 		return nil, &customErrors.HttpError{
 			StatusCode: 404,
@@ -66,25 +82,180 @@ func (is *IpsService) GetIps(ctx context.Context) (map[string]interface{}, error
 			Err:        fmt.Errorf("no IPS found for the user"),
 		}
 	}
-	slog.Info("Found DocumentReferences for user", "userId", userId, "count", len(entries))
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Resource.Meta.LastUpdated > entries[j].Resource.Meta.LastUpdated
-	})
 
-	ipsUrl := entries[0].Resource.Content[0].Attachment.URL
+	document := documents[0]
+	if documentID != "" {
+		i := slices.IndexFunc(documents, func(d *client.EntryResource) bool { return d.ID == documentID })
+		if i == -1 {
+			slog.Warn("IPS document not found for user", "userId", userId, "documentId", documentID)
+			return nil, &customErrors.HttpError{
+				StatusCode: 404,
+				Body:       []map[string]interface{}{{"error": "document_not_found", "message": "IPS document not found for the user"}},
+				Err:        fmt.Errorf("document %s not found for the user", documentID),
+			}
+		}
+		document = documents[i]
+	}
+
+	if len(document.Content) == 0 || document.Content[0].Attachment.URL == "" {
+		slog.Error("DocumentReference has no IPS attachment", "userId", userId, "documentId", document.ID)
+		return nil, &customErrors.HttpError{
+			StatusCode: 502,
+			Body:       []map[string]interface{}{{"error": "invalid_document", "message": "IPS document has no attachment"}},
+			Err:        fmt.Errorf("document %s has no attachment", document.ID),
+		}
+	}
+	ipsUrl := document.Content[0].Attachment.URL
 	slog.Info("Fetching IPS bundle", "userId", userId, "url", ipsUrl)
-	ipsBundle, err := repo.GetIpsBundle(entries[0].Resource.Content[0].Attachment.URL)
+	ipsBundle, err := repo.GetIpsBundle(ctx, ipsUrl)
 	if err != nil {
 		slog.Error("Error fetching IPS bundle", "userId", userId, "url", ipsUrl, "error", err)
 		return nil, err
 	}
 
 	return ipsBundle, nil
+}
 
+// listDocumentReferences returns the DocumentReferences of the user in a repository, newest first.
+func listDocumentReferences(ctx context.Context, repo *client.IpsClient, userId string) ([]*client.EntryResource, error) {
+	bundle, err := repo.GetDocumentReference(ctx, userId)
+	if err != nil {
+		slog.Error("Error fetching document reference", "userId", userId, "error", err)
+		return nil, err
+	}
+
+	var documents []*client.EntryResource
+	for _, e := range bundle.Entry {
+		if e.Resource != nil {
+			documents = append(documents, e.Resource)
+		}
+	}
+	slog.Info("Found DocumentReferences for user", "userId", userId, "count", len(documents))
+	sort.SliceStable(documents, func(i, j int) bool {
+		return lastUpdatedOf(documents[i]) > lastUpdatedOf(documents[j])
+	})
+	return documents, nil
+}
+
+func lastUpdatedOf(document *client.EntryResource) string {
+	if document.Meta == nil {
+		return ""
+	}
+	return document.Meta.LastUpdated
 }
 
+// GetIpsFromAllNodes fetches the user's IPS from every configured node concurrently.
+// Each node gets its own deadline, and failures are reported per node instead of failing the whole request.
+// When merge is true, the successful bundles are combined with MergeIPS in node order, with
+// narrative in the given locale. A merge that fails is reported in MergeErrors with the node results.
+func (is *IpsService) GetIpsFromAllNodes(ctx context.Context, merge bool, locale string) (*AggregatedIps, error) {
+	userId, err := authMiddleware.GetUserDocIDFromContext(ctx)
+	if err != nil {
+		slog.Error("User identifier not found in context", "error", err)
+		return nil, &customErrors.HttpError{
+			StatusCode: 401,
+			Body:       []map[string]interface{}{{"error": "user_identifier_not_found", "message": "User identifier not found in request context"}},
+			Err:        err,
+		}
+	}
+
+	repos := is.Repositories
+	if len(repos) == 0 {
+		repos = map[string]*client.IpsClient{authMiddleware.DefaultNodeName: is.DefaultRepository}
+	}
+	nodes := make([]string, 0, len(repos))
+	for node := range repos {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	results := make([]NodeIpsResult, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = is.fetchNodeIps(ctx, node, repos[node], userId)
+		}()
+	}
+	wg.Wait()
+
+	aggregated := &AggregatedIps{Nodes: results}
+	var bundles []map[string]interface{}
+	for _, r := range results {
+		if r.Status != NodeStatusOK {
+			aggregated.Partial = aggregated.Partial || r.Status != NodeStatusNotFound
+			continue
+		}
+		bundles = append(bundles, r.IPS)
+	}
+
+	if merge && len(bundles) == 1 {
+		aggregated.Merged = bundles[0]
+	} else if merge && len(bundles) > 1 {
+		result, err := is.MergeIPS(ctx, bundles, MergeOptions{Locale: locale})
+		if err != nil {
+			slog.Error("Failed to merge IPS from nodes", "userId", userId, "error", err)
+			aggregated.Partial = true
+			aggregated.MergeErrors = []map[string]interface{}{{"error": "merge_failed", "message": "The IPS of the nodes could not be merged"}}
+			var httpErr *customErrors.HttpError
+			if errors.As(err, &httpErr) {
+				aggregated.MergeErrors = httpErr.Body
+			}
+			return aggregated, nil
+		}
+		aggregated.Merged = result.Bundle
+		aggregated.UnresolvedReferences = result.UnresolvedReferences
+	}
+	return aggregated, nil
+}
+
+// fetchNodeIps fetches the user's IPS from a node within NodeTimeout, cancelling the node requests past it.
+func (is *IpsService) fetchNodeIps(ctx context.Context, node string, repo *client.IpsClient, userId string) NodeIpsResult {
+	nodeCtx, cancel := context.WithTimeout(ctx, is.NodeTimeout)
+	defer cancel()
+
+	ips, err := fetchIps(nodeCtx, repo, userId, "")
+	switch {
+	case err == nil:
+		return NodeIpsResult{Node: node, Status: NodeStatusOK, StatusCode: http.StatusOK, IPS: ips}
+	case ctx.Err() != nil:
+		return NodeIpsResult{
+			Node:       node,
+			Status:     NodeStatusTimeout,
+			StatusCode: http.StatusGatewayTimeout,
+			Errors:     []map[string]interface{}{{"error": "request_cancelled", "message": "Request was cancelled"}},
+		}
+	case errors.Is(nodeCtx.Err(), context.DeadlineExceeded):
+		slog.Warn("Timed out fetching IPS from node", "node", node, "userId", userId, "timeout", is.NodeTimeout)
+		return NodeIpsResult{
+			Node:       node,
+			Status:     NodeStatusTimeout,
+			StatusCode: http.StatusGatewayTimeout,
+			Errors:     []map[string]interface{}{{"error": "node_timeout", "message": "Node did not respond in time"}},
+		}
+	}
+
+	slog.Warn("Failed to fetch IPS from node", "node", node, "userId", userId, "error", err)
+	result := NodeIpsResult{Node: node, Status: NodeStatusError, StatusCode: http.StatusBadGateway}
+	var httpErr *customErrors.HttpError
+	if errors.As(err, &httpErr) {
+		result.StatusCode = httpErr.StatusCode
+		result.Errors = httpErr.Body
+		if httpErr.StatusCode == http.StatusNotFound {
+			result.Status = NodeStatusNotFound
+		}
+	}
+	return result
+}
+
+// GetIpsICVP returns the ICVP of an immunization of one of the user's IPS bundles. Bundles of other
+// patients are reported as not found.
 func (is *IpsService) GetIpsICVP(ctx context.Context, idBundle string, immunizationId *string) (string, error) {
 	repo := is.getClient(ctx)
+	if err := checkBundleOwner(ctx, repo, idBundle); err != nil {
+		return "", err
+	}
 	result, err := repo.GetIpsICVP(idBundle, immunizationId)
 	if err != nil {
 		return "", err
@@ -92,77 +263,146 @@ func (is *IpsService) GetIpsICVP(ctx context.Context, idBundle string, immunizat
 	return result, nil
 }
 
-// Will return the IPS composition sections
-func getIPSComposition(entries []Entry) (*Composition, error) {
-	i := slices.IndexFunc(entries, func(e Entry) bool {
-		if e.Resource["resourceType"] != "Composition" {
-			return false
-		}
-		var composition Composition
-		if err := mapstructure.Decode(e.Resource, &composition); err != nil {
-			return false
+// checkBundleOwner refuses bundles that are not the attachment of one of the user's DocumentReferences.
+func checkBundleOwner(ctx context.Context, repo *client.IpsClient, idBundle string) error {
+	userId, err := authMiddleware.GetUserDocIDFromContext(ctx)
+	if err != nil {
+		return &customErrors.HttpError{
+			StatusCode: 401,
+			Body:       []map[string]interface{}{{"error": "user_identifier_not_found", "message": "User identifier not found in request context"}},
+			Err:        err,
 		}
-		return composition.Type.Coding[0]["code"] == "60591-5"
-	})
-	if i == -1 {
-		return nil, fmt.Errorf(`no composition found`)
 	}
-	comp := entries[i].Resource
-	var composition Composition
-	if err := mapstructure.Decode(comp, &composition); err != nil {
-		return nil, fmt.Errorf(`error decoding composition: %v`, err)
+	documents, err := listDocumentReferences(ctx, repo, userId)
+	if err != nil {
+		return err
 	}
-	composition.URL = entries[i].FullURL
-	// Remove empty sections
-	var result []Section
-	for _, s := range composition.Section {
-		if s.Code.Coding != nil {
-			result = append(result, s)
+	if !ownsBundle(documents, idBundle) {
+		slog.Warn("IPS bundle not found for user", "userId", userId, "bundleId", idBundle)
+		return &customErrors.HttpError{
+			StatusCode: 404,
+			Body:       []map[string]interface{}{{"error": "bundle_not_found", "message": "IPS bundle not found for the user"}},
+			Err:        fmt.Errorf("bundle %s is not an IPS of the user", idBundle),
 		}
 	}
-	composition.Section = result
+	return nil
+}
 
-	return &composition, nil
+// ownsBundle reports whether a bundle is the attachment of one of the DocumentReferences.
+func ownsBundle(documents []*client.EntryResource, idBundle string) bool {
+	for _, document := range documents {
+		for _, content := range document.Content {
+			if idBundle != "" && utils.BundleIDFromURL(content.Attachment.URL) == idBundle {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func getEntry(reference string, current []Entry, newIpsEntries []Entry) *Entry {
-	indInCurrent := slices.IndexFunc(current, func(e Entry) bool {
-		return e.FullURL == reference
-	})
-	if indInCurrent != -1 {
-		return &current[indInCurrent]
+// VerifyBundleSignature checks the signature of a bundle merged and signed by this service.
+func (is *IpsService) VerifyBundleSignature(ctx context.Context, bundle map[string]interface{}) (*SignatureVerification, error) {
+	if is.Signer == nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusNotImplemented,
+			Body:       []map[string]interface{}{{"error": "signing_not_configured", "message": "IPS signing is not configured in this service"}},
+			Err:        fmt.Errorf("no signing key configured"),
+		}
+	}
+	if rtype, _ := bundle["resourceType"].(string); rtype != "Bundle" {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusBadRequest,
+			Body:       []map[string]interface{}{{"error": "bad_request", "message": "Body must be a FHIR Bundle"}},
+			Err:        fmt.Errorf("resource type %q is not Bundle", rtype),
+		}
 	}
 
-	indInNew := slices.IndexFunc(newIpsEntries, func(e Entry) bool {
-		return e.FullURL == reference
-	})
-	if indInNew != -1 {
-		return &newIpsEntries[indInNew]
+	result := is.Signer.Verify(bundle)
+	if !result.Valid {
+		slog.Info("IPS signature verification failed", "kid", result.KeyID, "reason", result.Reason)
 	}
-	return nil
+	return &result, nil
 }
 
-func findAllKeysContainingString(m map[string]interface{}, substring string) []string {
-	var matchingKeys []string
-	slower := strings.ToLower(substring)
-	for key := range m {
-		if strings.Contains(strings.ToLower(key), slower) {
-			matchingKeys = append(matchingKeys, key)
+// getIPSComposition returns the IPS composition of a bundle, with its sections, and the fullUrl of its entry.
+// Sections without a code are left out.
+func getIPSComposition(entries []Entry) (*Composition, string, error) {
+	for _, e := range entries {
+		if e.Resource["resourceType"] != "Composition" {
+			continue
+		}
+		composition, err := DecodeResource[Composition](e.Resource)
+		if err != nil {
+			slog.Warn("Resource is not a valid Composition", "url", e.FullURL, "error", err)
+			continue
+		}
+		if !composition.Type.hasCode(ipsDocumentCode) {
+			continue
+		}
+
+		var sections []Section
+		for _, s := range composition.Section {
+			if s.Code.firstCode() != "" {
+				sections = append(sections, s)
+			}
 		}
+		composition.Section = sections
+		return composition, e.FullURL, nil
 	}
-	return matchingKeys
+	return nil, "", fmt.Errorf("no composition found")
 }
 
-func removeDuplicates(entries []Entry) []Entry {
-	encountered := map[string]bool{}
+// removeDuplicates keeps the first entry of every fullUrl. An entry of another resource with the fullUrl
+// of a kept one cannot be told apart by references, so it is left out and returned as a conflict.
+func removeDuplicates(entries []Entry) ([]Entry, []MergeConflict) {
+	kept := map[string]int{}
 	var result []Entry
+	var conflicts []MergeConflict
 	for _, e := range entries {
-		if !encountered[e.FullURL] {
-			encountered[e.FullURL] = true
+		i, ok := kept[e.FullURL]
+		if !ok {
+			kept[e.FullURL] = len(result)
 			result = append(result, e)
+			continue
+		}
+		if !sameResource(result[i].Resource, e.Resource) {
+			slog.Warn("Merged IPS has different resources with the same fullUrl", "url", e.FullURL)
+			conflicts = append(conflicts, MergeConflict{FullURL: e.FullURL, BundleID: originBundleID(e.Resource), KeptBundleID: originBundleID(result[i].Resource)})
 		}
 	}
-	return result
+	return result, conflicts
+}
+
+// sameResource reports whether two resources only differ by their origin extensions.
+func sameResource(a, b map[string]interface{}) bool {
+	return reflect.DeepEqual(a, b) || reflect.DeepEqual(withoutOrigins(a), withoutOrigins(b))
+}
+
+// withoutOrigins returns a shallow copy of a resource without its origin extensions.
+func withoutOrigins(resource map[string]interface{}) map[string]interface{} {
+	copied := maps.Clone(resource)
+	extensions, _ := resource["extension"].([]interface{})
+	var kept []interface{}
+	for _, ext := range extensions {
+		if extMap, ok := ext.(map[string]interface{}); ok && extMap["url"] == originExtensionURL {
+			continue
+		}
+		kept = append(kept, ext)
+	}
+	if len(kept) == 0 {
+		delete(copied, "extension")
+	} else {
+		copied["extension"] = kept
+	}
+	return copied
+}
+
+// originBundleID returns the bundle a resource was first found in, read from its origin extensions.
+func originBundleID(resource map[string]interface{}) string {
+	if origins := originsOf(resource); len(origins) > 0 {
+		return origins[0].BundleID
+	}
+	return ""
 }
 
 func findCountryInBundle(bundle Bundle) string {
@@ -172,43 +412,27 @@ func findCountryInBundle(bundle Bundle) string {
 			continue
 		}
 
-
-		rtype, ok := entry.Resource["resourceType"]
-		if !ok || rtype == nil || rtype != "Organization" {
+		if rtype, _ := entry.Resource["resourceType"].(string); rtype != "Organization" {
 			slog.Warn("Resource type is not an Organization", "url", entry.FullURL)
 			continue
 		}
 
-		addressEntry, ok := entry.Resource["address"]
-		if !ok || addressEntry == nil {
-			slog.Warn("Resource has no Address", "url", entry.FullURL)
+		organization, err := DecodeResource[Organization](entry.Resource)
+		if err != nil {
+			slog.Warn("Resource is not a valid Organization", "url", entry.FullURL, "error", err)
 			continue
 		}
 
-		addresses, ok := entry.Resource["address"].([]interface{})
-		if !ok || len(addresses) == 0 {
+		if len(organization.Address) == 0 {
 			slog.Warn("Resource has no address", "url", entry.FullURL)
 			continue
 		}
 
-		address, ok := addresses[0].(map[string]interface{})
-		if !ok || address == nil {
-			slog.Warn("Resource address is not a map", "url", entry.FullURL)
-			continue
-		}
-
-		country, ok := address["country"]
-		if !ok || country == nil {
+		if organization.Address[0].Country == "" {
 			slog.Warn("Resource address has no country", "url", entry.FullURL)
 			continue
 		}
-
-		countryCode, ok := country.(string)
-		if !ok {
-			slog.Warn("Resource country is not a string", "url", entry.FullURL)
-			continue
-		}
-		return countryCode
+		return organization.Address[0].Country
 	}
 	return ""
 }
@@ -221,7 +445,6 @@ func findOrganizationEntries(bundle Bundle) []Entry {
 			continue
 		}
 
-
 		rtype, ok := entry.Resource["resourceType"]
 		if !ok || rtype == nil || rtype != "Organization" {
 			continue
@@ -236,20 +459,6 @@ func addOriginExtension(resource map[string]interface{}, bundleID string, countr
 		return
 	}
 
-	originExtension := map[string]interface{}{
-		"url": "http://lacpass.org/fhir/StructureDefinition/resource-origin",
-		"extension": []map[string]interface{}{
-			{
-				"url":         "bundleId",
-				"valueString": bundleID,
-			},
-			{
-				"url":         "country",
-				"valueString": country,
-			},
-		},
-	}
-
 	extensions, ok := resource["extension"].([]interface{})
 	if !ok {
 		extensions = []interface{}{}
@@ -263,100 +472,208 @@ func addOriginExtension(resource map[string]interface{}, bundleID string, countr
 		}
 
 		url, ok := extMap["url"].(string)
-		if !ok || url != "http://lacpass.org/fhir/StructureDefinition/resource-origin" {
+		if !ok || url != originExtensionURL {
 			continue
 		}
 
 		return
 	}
 
-	extensions = append(extensions, originExtension)
+	extensions = append(extensions, originExtension(bundleID, country))
 	resource["extension"] = extensions
 }
 
-func (is *IpsService) MergeIPS(ctx context.Context, currentIpsBundle map[string]interface{}, newIpsBundle map[string]interface{}) (map[string]interface{}, error) {
-	var currIPS, newIPS Bundle
-	if err := mapstructure.Decode(currentIpsBundle, &currIPS); err != nil {
-		return nil, &customErrors.HttpError{
-			StatusCode: 400,
-			Body:       []map[string]interface{}{{"error": "bad_request", "message": "Malformed current IPS"}},
-			Err:        fmt.Errorf("malformed current IPS"),
-		}
+// bundleEntries returns the entries of each bundle, in bundle order.
+func bundleEntries(bundles []Bundle) [][]Entry {
+	sources := make([][]Entry, len(bundles))
+	for i, b := range bundles {
+		sources[i] = b.Entry
 	}
+	return sources
+}
 
-	if err := mapstructure.Decode(newIpsBundle, &newIPS); err != nil {
-		return nil, &customErrors.HttpError{
-			StatusCode: 400,
-			Body:       []map[string]interface{}{{"error": "bad_request", "message": "Malformed new IPS"}},
-			Err:        fmt.Errorf("malformed new IPS"),
+// mergeSources are the lookups of a merge, built once for all of its bundles.
+type mergeSources struct {
+	bundles []Bundle
+	// entries are the entries of each bundle, in bundle order, and all flattened.
+	entries [][]Entry
+	all     []Entry
+	// sections maps the code of each section of the merged composition to the bundle of every one of its
+	// entries, so that ids reused across bundles resolve to the resource of the right bundle.
+	sections map[string][]int
+}
+
+func newMergeSources(bundles []Bundle, base *Composition) *mergeSources {
+	ms := &mergeSources{bundles: bundles, entries: bundleEntries(bundles), sections: map[string][]int{}}
+	for _, entries := range ms.entries {
+		ms.all = append(ms.all, entries...)
+	}
+	for _, section := range base.Section {
+		if code := section.Code.firstCode(); ms.sections[code] == nil {
+			ms.sections[code] = make([]int, len(section.Entry))
 		}
 	}
+	return ms
+}
 
-	currentCountry := findCountryInBundle(currIPS)
-	newCountry := findCountryInBundle(newIPS)
+// resolveClinicalDuplicate looks for a section entry recording the same clinical fact as newEntry,
+// which comes from bundle k. Section entries may come from any bundle before it. When found,
+// the dedup strategy decides which reference stays in the section, and the kept resource inherits
+// the origin extensions of the discarded one. The dropped entry is returned.
+func (is *IpsService) resolveClinicalDuplicate(section *Section, newEntry Reference, ms *mergeSources, k int) *MergeDuplicate {
+	sources := ms.entries[:k+1]
+	newRef := newEntry.Reference
+	newResource, _ := resolveInSources(newRef, k, sources)
+	if newResource == nil {
+		return nil
+	}
+	origins := ms.sections[section.Code.firstCode()]
+
+	for i, oldEntry := range section.Entry {
+		oldRef := oldEntry.Reference
+		oldResource, source := resolveInSources(oldRef, origins[i], sources)
+		if oldResource == nil || oldResource == newResource || !isClinicalDuplicate(oldResource.Resource, newResource.Resource, ms.all) {
+			continue
+		}
 
-	for i := range currIPS.Entry {
-		addOriginExtension(currIPS.Entry[i].Resource, currIPS.ID, currentCountry)
+		slog.Info("Merged duplicated clinical resource", "current", oldRef, "new", newRef, "strategy", is.DedupStrategy)
+		if is.DedupStrategy.prefersNew(oldResource.Resource, newResource.Resource) {
+			mergeOriginExtensions(newResource.Resource, oldResource.Resource)
+			section.Entry[i] = newEntry
+			origins[i] = k
+			return &MergeDuplicate{Reference: oldRef, KeptReference: newRef, Reason: duplicateClinicalMatch, BundleID: ms.bundles[source].ID}
+		}
+		mergeOriginExtensions(oldResource.Resource, newResource.Resource)
+		return &MergeDuplicate{Reference: newRef, KeptReference: oldRef, Reason: duplicateClinicalMatch, BundleID: ms.bundles[k].ID}
 	}
+	return nil
+}
+
+// mergeComposition adds the sections and entries of the composition of bundle k to merged.
+func (is *IpsService) mergeComposition(merged *Composition, comp *Composition, ms *mergeSources, k int, report *MergeReport) {
+	bundleID := ms.bundles[k].ID
+	for _, section := range comp.Section {
+		code := section.Code.firstCode()
+		if code == "" {
+			continue
+		}
+		sectionIndex := slices.IndexFunc(merged.Section, func(s Section) bool {
+			return s.Code.firstCode() == code
+		})
+
+		if sectionIndex == -1 {
+			// Section is not present on the merged IPS yet
+			merged.Section = append(merged.Section, section)
+			ms.sections[code] = slices.Repeat([]int{k}, len(section.Entry))
+			report.CreatedSections = append(report.CreatedSections, MergedSection{Title: section.Title, Code: code, BundleID: bundleID})
+			continue
+		}
+
+		// Sections exists, add entries that are not in the merged IPS yet
+		for _, newEntry := range section.Entry {
+			ref := newEntry.Reference
+			exists := slices.ContainsFunc(merged.Section[sectionIndex].Entry, func(oldEntry Reference) bool {
+				return oldEntry.Reference == ref
+			})
+			if exists {
+				report.Duplicates = append(report.Duplicates, MergeDuplicate{Reference: ref, KeptReference: ref, Reason: duplicateSameReference, BundleID: bundleID})
+				continue
+			}
 
-	for i := range newIPS.Entry {
-		addOriginExtension(newIPS.Entry[i].Resource, newIPS.ID, newCountry)
+			// The same clinical fact may be recorded with different ids in each IPS
+			if duplicate := is.resolveClinicalDuplicate(&merged.Section[sectionIndex], newEntry, ms, k); duplicate != nil {
+				report.Duplicates = append(report.Duplicates, *duplicate)
+				continue
+			}
+			merged.Section[sectionIndex].Entry = append(merged.Section[sectionIndex].Entry, newEntry)
+			ms.sections[code] = append(ms.sections[code], k)
+		}
 	}
+}
 
-	curComp, err := getIPSComposition(currIPS.Entry)
-	if err != nil {
+// MergeIPS merges a list of IPS bundles into a new one. The first bundle is the base of the merge
+// and the others are merged into it one after the other, so conflicts always resolve the same way
+// for the same list: "current" in the dedup strategy means the bundle that comes first.
+// The result has a single Composition, the one of the first bundle extended with the sections and
+// entries of the others. References that cannot be resolved in any bundle are kept as they are and
+// listed in the result. The merged bundle carries a Provenance resource naming every source bundle,
+// and opts.Report adds a report of what was merged. When the service has a Signer, the merged bundle
+// is signed.
+func (is *IpsService) MergeIPS(ctx context.Context, ipsBundles []map[string]interface{}, opts MergeOptions) (*MergeResult, error) {
+	if len(ipsBundles) < 2 {
 		return nil, &customErrors.HttpError{
 			StatusCode: 400,
-			Body:       []map[string]interface{}{{"error": "bad_request", "message": "Current IPS does not have its composition"}},
-			Err:        err,
+			Body:       []map[string]interface{}{{"error": "bad_request", "message": "At least two IPS are required to merge"}},
+			Err:        fmt.Errorf("merge needs at least two IPS, got %d", len(ipsBundles)),
 		}
 	}
 
-	newComp, err := getIPSComposition(newIPS.Entry)
-	if err != nil {
-		return nil, &customErrors.HttpError{
-			StatusCode: 400,
-			Body:       []map[string]interface{}{{"error": "bad_request", "message": "Current IPS does not have its composition"}},
-			Err:        err,
+	if opts.Validate {
+		for i, ips := range ipsBundles {
+			if outcome := ValidateIPS(ips); outcome.HasErrors() {
+				return nil, &customErrors.HttpError{
+					StatusCode: http.StatusUnprocessableEntity,
+					Body:       []map[string]interface{}{{"error": "invalid_ips", "message": fmt.Sprintf("IPS at position %d is not valid", i), "outcome": outcome}},
+					Err:        fmt.Errorf("IPS at position %d is not valid", i),
+				}
+			}
 		}
 	}
 
-	// Merge composition for IPSs
-	mergedComp := curComp
-	for _, section := range newComp.Section {
-		code := section.Code.Coding[0]["code"]
-		if code == nil {
-			continue
+	bundles := make([]Bundle, len(ipsBundles))
+	for i, ips := range ipsBundles {
+		bundle, err := DecodeResource[Bundle](ips)
+		if err != nil {
+			return nil, &customErrors.HttpError{
+				StatusCode: 400,
+				Body:       []map[string]interface{}{{"error": "bad_request", "message": fmt.Sprintf("Malformed IPS at position %d", i)}},
+				Err:        fmt.Errorf("malformed IPS at position %d: %w", i, err),
+			}
 		}
-		sectionIndex := slices.IndexFunc(mergedComp.Section, func(s Section) bool {
-			return len(s.Code.Coding) > 0 && s.Code.Coding[0] != nil && s.Code.Coding[0]["code"] == code
-		})
+		bundles[i] = *bundle
+	}
 
-		if sectionIndex == -1 {
-			// New IPS section is not present on current IPS
-			mergedComp.Section = append(mergedComp.Section, section)
-		} else {
-			// Sections exists, add entries that do not exist in the current IPS
-			for _, newEntry := range section.Entry {
-				exists := false
-				for _, oldEntry := range mergedComp.Section[sectionIndex].Entry {
-					if newEntry["reference"] == oldEntry["reference"] {
-						exists = true
-						break
-					}
-				}
-				if !exists {
-					mergedComp.Section[sectionIndex].Entry = append(mergedComp.Section[sectionIndex].Entry, newEntry)
-				}
+	report := &MergeReport{
+		Sources:              []MergeSource{},
+		Resources:            []MergedResource{},
+		Duplicates:           []MergeDuplicate{},
+		CreatedSections:      []MergedSection{},
+		Conflicts:            []MergeConflict{},
+		UnresolvedReferences: []string{},
+	}
 
+	compositions := make([]*Composition, len(bundles))
+	compositionURLs := make([]string, len(bundles))
+	for i := range bundles {
+		country := findCountryInBundle(bundles[i])
+		for j := range bundles[i].Entry {
+			addOriginExtension(bundles[i].Entry[j].Resource, bundles[i].ID, country)
+		}
+		report.Sources = append(report.Sources, MergeSource{BundleID: bundles[i].ID, Country: country})
+
+		comp, compURL, err := getIPSComposition(bundles[i].Entry)
+		if err != nil {
+			return nil, &customErrors.HttpError{
+				StatusCode: 400,
+				Body:       []map[string]interface{}{{"error": "bad_request", "message": fmt.Sprintf("IPS at position %d does not have its composition", i)}},
+				Err:        err,
 			}
 		}
+		compositions[i] = comp
+		compositionURLs[i] = compURL
 	}
 
-	fullURL := mergedComp.URL
-	mergedComp.URL = ""
+	// Merge composition for IPSs
+	mergedComp := compositions[0]
+	sources := newMergeSources(bundles, mergedComp)
+	for k := 1; k < len(bundles); k++ {
+		is.mergeComposition(mergedComp, compositions[k], sources, k, report)
+	}
+	// The narrative of the base composition no longer matches its entries
+	generateNarrative(mergedComp, report.Sources, sources.entries, opts.Locale)
 
-	jsonData, err := json.Marshal(mergedComp)
+	fullURL := compositionURLs[0]
+	mergedResource, err := EncodeResource(mergedComp)
 	if err != nil {
 		return nil, &customErrors.HttpError{
 			StatusCode: 500,
@@ -365,87 +682,64 @@ func (is *IpsService) MergeIPS(ctx context.Context, currentIpsBundle map[string]
 		}
 	}
 
-	var mergedResource map[string]interface{}
-	if err := json.Unmarshal(jsonData, &mergedResource); err != nil {
-		return nil, &customErrors.HttpError{
-			StatusCode: 500,
-			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to convert composition JSON to a map"}},
-			Err:        err,
-		}
-	}
-
 	// Build the merge ips with the merge Composition
+	base := bundles[0]
 	mergedIPS := Bundle{
 		ID:           uuid.NewString(),
-		Identifier:   currIPS.Identifier,
-		Meta:         currIPS.Meta,
-		ResourceType: currIPS.ResourceType,
+		Identifier:   base.Identifier,
+		Meta:         base.Meta,
+		ResourceType: base.ResourceType,
 		Signature:    nil,
-		Timestamp:    time.Now().UTC().String(),
-		Type:         currIPS.Type,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		Type:         base.Type,
 		Entry:        []Entry{{FullURL: fullURL, Resource: mergedResource}},
 	}
-	for _, section := range mergedComp.Section {
-		for _, secEntry := range section.Entry {
-			newEntry := getEntry(secEntry["reference"].(string), currIPS.Entry, newIPS.Entry)
-
-			if newEntry == nil {
-				break
-			}
-			mergedIPS.Entry = append(mergedIPS.Entry, *newEntry)
-
-			if newEntry.Resource == nil {
-				break
-			}
-			// Check for any resource that contains more reference in its representation
-			// If we find any reference we added it to the IPS
-			rk := findAllKeysContainingString(newEntry.Resource, "reference")
-			for _, k := range rk {
-				v, ok := newEntry.Resource[k]
-				if !ok {
-					break
-				}
-				var ref Reference
-				if err := mapstructure.Decode(v, &ref); err != nil {
-					return nil, fmt.Errorf(`error decoding codeable reference: %v`, err)
-				}
-				if ref.Reference != "" {
-					newEntry = getEntry(ref.Reference, currIPS.Entry, newIPS.Entry)
-					mergedIPS.Entry = append(mergedIPS.Entry, *newEntry)
-				}
-
-			}
-		}
+	// Add every resource reachable from the merged composition, following nested references
+	closure, unresolved := resolveClosure(mergedResource, fullURL, sources.entries...)
+	mergedIPS.Entry = append(mergedIPS.Entry, closure...)
+	if len(unresolved) > 0 {
+		slog.Warn("Merged IPS has unresolved references", "count", len(unresolved), "references", unresolved)
 	}
 
-	// Add original Organization resources from both IPSs
-	currIPSOrganizations := findOrganizationEntries(currIPS)
-	newIPSOrganizations := findOrganizationEntries(newIPS)
-	for _, org := range newIPSOrganizations {
-		mergedIPS.Entry = append(mergedIPS.Entry, org)
+	// Add original Organization resources from every IPS
+	for _, b := range bundles {
+		mergedIPS.Entry = append(mergedIPS.Entry, findOrganizationEntries(b)...)
 	}
-	for _, org := range currIPSOrganizations {
-		mergedIPS.Entry = append(mergedIPS.Entry, org)
+	entries, conflicts := removeDuplicates(mergedIPS.Entry)
+	mergedIPS.Entry = entries
+	report.Conflicts = append(report.Conflicts, conflicts...)
+	mergedIPS.Entry = append(mergedIPS.Entry, buildProvenance(fullURL, report.Sources))
+
+	if opts.Report {
+		for _, e := range mergedIPS.Entry {
+			rtype, _ := e.Resource["resourceType"].(string)
+			report.Resources = append(report.Resources, MergedResource{FullURL: e.FullURL, ResourceType: rtype, Origins: originsOf(e.Resource)})
+		}
+		report.UnresolvedReferences = append(report.UnresolvedReferences, unresolved...)
 	}
-	mergedIPS.Entry = removeDuplicates(mergedIPS.Entry)
 
-	jsonData, err = json.Marshal(mergedIPS)
+	data, err := EncodeResource(mergedIPS)
 	if err != nil {
 		return nil, &customErrors.HttpError{
 			StatusCode: 500,
-			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to convert composition to JSON"}},
+			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to convert merged IPS to JSON"}},
 			Err:        err,
 		}
 	}
 
-	var data map[string]interface{}
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		return nil, &customErrors.HttpError{
-			StatusCode: 500,
-			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to convert composition JSON to a map"}},
-			Err:        err,
+	if is.Signer != nil {
+		if err := is.Signer.Sign(data); err != nil {
+			return nil, &customErrors.HttpError{
+				StatusCode: 500,
+				Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to sign merged IPS"}},
+				Err:        err,
+			}
 		}
 	}
 
-	return data, nil
+	result := &MergeResult{Bundle: data, UnresolvedReferences: unresolved}
+	if opts.Report {
+		result.Report = report
+	}
+	return result, nil
 }
@@ -2,15 +2,19 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	"ips-lacpass-backend/internal/ips/client"
 	"ips-lacpass-backend/pkg/middleware"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestNodeSelection(t *testing.T) {
 	defaultRepo := client.NewClient("http://default-fhir", "http://default-mediator")
 	node1Repo := client.NewClient("http://node1-fhir", "http://node1-mediator")
-	
+
 	service := NewService(&defaultRepo)
 	service.Repositories["node1"] = &node1Repo
 
@@ -38,3 +42,108 @@ func TestNodeSelection(t *testing.T) {
 		}
 	})
 }
+
+// fhirNode serves the DocumentReference search and the IPS bundle of a node. A nil bundle gives no
+// DocumentReference, and a delay holds every response back unless the request is cancelled first.
+func fhirNode(t *testing.T, bundle map[string]interface{}, delay time.Duration) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		switch r.URL.Path {
+		case "/fhir/DocumentReference":
+			search := client.Bundle{ResourceType: "Bundle", Type: "searchset"}
+			if bundle != nil {
+				document := &client.EntryResource{ResourceType: "DocumentReference", ID: "doc-1"}
+				document.Content = []client.DocumentContent{{}}
+				document.Content[0].Attachment.URL = server.URL + "/fhir/Bundle/ips-1"
+				search.Entry = []client.BundleEntry{{Resource: document}}
+			}
+			_ = json.NewEncoder(w).Encode(search)
+		case "/fhir/Bundle/ips-1":
+			_ = json.NewEncoder(w).Encode(bundle)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetIpsFromAllNodes(t *testing.T) {
+	ips := map[string]interface{}{"resourceType": "Bundle", "id": "ips-1", "type": "document"}
+	node1 := fhirNode(t, ips, 0)
+	node2 := fhirNode(t, ips, 2*time.Second)
+	node3 := fhirNode(t, nil, 0)
+
+	defaultRepo := client.NewClient(node1.URL, "")
+	node1Repo := client.NewClient(node1.URL, "")
+	node2Repo := client.NewClient(node2.URL, "")
+	node3Repo := client.NewClient(node3.URL, "")
+
+	service := NewService(&defaultRepo)
+	service.NodeTimeout = 200 * time.Millisecond
+	service.Repositories["node3"] = &node3Repo
+	service.Repositories["node2"] = &node2Repo
+	service.Repositories["node1"] = &node1Repo
+	ctx := context.WithValue(context.Background(), middleware.UserDocIdKey, "123")
+
+	t.Run("Missing user identifier", func(t *testing.T) {
+		if _, err := service.GetIpsFromAllNodes(context.Background(), false, ""); err == nil {
+			t.Fatalf("Expected error without user identifier")
+		}
+	})
+
+	t.Run("Reports every node in order", func(t *testing.T) {
+		started := time.Now()
+		aggregated, err := service.GetIpsFromAllNodes(ctx, true, "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if elapsed := time.Since(started); elapsed > time.Second {
+			t.Errorf("Expected the slow node to be cancelled at its deadline, took %s", elapsed)
+		}
+		if len(aggregated.Nodes) != 3 {
+			t.Fatalf("Expected 3 node results, got %d", len(aggregated.Nodes))
+		}
+		expected := []struct {
+			node   string
+			status NodeStatus
+		}{{"node1", NodeStatusOK}, {"node2", NodeStatusTimeout}, {"node3", NodeStatusNotFound}}
+		for i, e := range expected {
+			if aggregated.Nodes[i].Node != e.node || aggregated.Nodes[i].Status != e.status {
+				t.Errorf("Expected %s to be %s, got %+v", e.node, e.status, aggregated.Nodes[i])
+			}
+		}
+		if !aggregated.Partial {
+			t.Errorf("Expected partial result when a node times out")
+		}
+		if aggregated.Merged["id"] != "ips-1" {
+			t.Errorf("Expected the only IPS found as merged bundle, got %v", aggregated.Merged)
+		}
+	})
+
+	t.Run("Keeps node results when merging fails", func(t *testing.T) {
+		malformed := map[string]interface{}{"resourceType": "Bundle", "entry": "not a list"}
+		repo1 := client.NewClient(fhirNode(t, malformed, 0).URL, "")
+		repo2 := client.NewClient(fhirNode(t, malformed, 0).URL, "")
+		service := NewService(&repo1)
+		service.Repositories["node1"] = &repo1
+		service.Repositories["node2"] = &repo2
+
+		aggregated, err := service.GetIpsFromAllNodes(ctx, true, "")
+		if err != nil {
+			t.Fatalf("Expected the node results despite the merge error, got %v", err)
+		}
+		if len(aggregated.Nodes) != 2 || aggregated.Nodes[0].IPS == nil || aggregated.Nodes[1].IPS == nil {
+			t.Errorf("Expected the IPS of both nodes, got %+v", aggregated.Nodes)
+		}
+		if aggregated.Merged != nil || len(aggregated.MergeErrors) != 1 || !aggregated.Partial {
+			t.Errorf("Expected a partial result with the merge error, got %+v", aggregated)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package core
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+const (
+	signatureFormat = "application/jose"
+	// The document canonicalization leaves Bundle.id, Bundle.meta and Bundle.signature out of the signed content.
+	signatureTargetFormat = "application/fhir+json;canonicalization=http://hl7.org/fhir/canonicalization/json#document"
+	signatureTypeSystem   = "urn:iso-astm:E1762-95:2013"
+	signatureTypeCode     = "1.2.840.10065.1.12.1.5"
+)
+
+// BundleSigner signs merged IPS bundles with a detached JWS over their canonical JSON,
+// and verifies bundles it signed.
+type BundleSigner struct {
+	KeyID     string
+	algorithm jwa.SignatureAlgorithm
+	key       jwk.Key
+	publicKey jwk.Key
+}
+
+// SignatureVerification is the outcome of verifying a bundle signature.
+type SignatureVerification struct {
+	Valid     bool   `json:"valid"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	SignedAt  string `json:"signed_at,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// NewBundleSigner creates a signer from a private key in PEM or JWK format.
+// The algorithm follows the key: RS256 for RSA, ES256/ES384/ES512 for EC and EdDSA for Ed25519.
+func NewBundleSigner(keyData []byte, keyID string) (*BundleSigner, error) {
+	key, err := jwk.ParseKey(keyData, jwk.WithPEM(bytes.HasPrefix(bytes.TrimSpace(keyData), []byte("-----"))))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key: %w", err)
+	}
+	if private, err := jwk.IsPrivateKey(key); err != nil || !private {
+		return nil, fmt.Errorf("signing key must be an asymmetric private key")
+	}
+
+	algorithm, err := signingAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("error deriving public key: %w", err)
+	}
+	if keyID == "" {
+		keyID = key.KeyID()
+	}
+	if keyID == "" {
+		thumbprint, err := publicKey.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("error computing key thumbprint: %w", err)
+		}
+		keyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+
+	return &BundleSigner{KeyID: keyID, algorithm: algorithm, key: key, publicKey: publicKey}, nil
+}
+
+func signingAlgorithm(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case jwk.RSAPrivateKey:
+		return jwa.RS256, nil
+	case jwk.ECDSAPrivateKey:
+		switch k.Crv() {
+		case jwa.P256:
+			return jwa.ES256, nil
+		case jwa.P384:
+			return jwa.ES384, nil
+		case jwa.P521:
+			return jwa.ES512, nil
+		}
+		return "", fmt.Errorf("unsupported EC curve %s", k.Crv())
+	case jwk.OKPPrivateKey:
+		if k.Crv() == jwa.Ed25519 {
+			return jwa.EdDSA, nil
+		}
+		return "", fmt.Errorf("unsupported OKP curve %s", k.Crv())
+	}
+	return "", fmt.Errorf("unsupported signing key type %s", key.KeyType())
+}
+
+// canonicalBundle serializes a bundle following the FHIR JSON document canonicalization:
+// no whitespace, object keys in order, and Bundle.id, Bundle.meta and Bundle.signature removed.
+func canonicalBundle(bundle map[string]interface{}) ([]byte, error) {
+	content := make(map[string]interface{}, len(bundle))
+	for k, v := range bundle {
+		if k == "id" || k == "meta" || k == "signature" {
+			continue
+		}
+		content[k] = v
+	}
+
+	// encoding/json writes map keys in order, so only HTML escaping has to be disabled
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(content); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Sign adds a signature element to the bundle, holding a detached JWS of its canonical JSON.
+func (bs *BundleSigner) Sign(bundle map[string]interface{}) error {
+	payload, err := canonicalBundle(bundle)
+	if err != nil {
+		return fmt.Errorf("error canonicalizing bundle: %w", err)
+	}
+
+	headers := jws.NewHeaders()
+	if err := headers.Set(jws.KeyIDKey, bs.KeyID); err != nil {
+		return err
+	}
+	signed, err := jws.Sign(nil, jws.WithKey(bs.algorithm, bs.key, jws.WithProtectedHeaders(headers)), jws.WithDetachedPayload(payload))
+	if err != nil {
+		return fmt.Errorf("error signing bundle: %w", err)
+	}
+
+	bundle["signature"] = map[string]interface{}{
+		"type": []interface{}{
+			map[string]interface{}{
+				"system":  signatureTypeSystem,
+				"code":    signatureTypeCode,
+				"display": "Verification Signature",
+			},
+		},
+		"when":         time.Now().UTC().Format(time.RFC3339),
+		"who":          map[string]interface{}{"display": "IPS Lacpass backend"},
+		"targetFormat": signatureTargetFormat,
+		"sigFormat":    signatureFormat,
+		"data":         base64.StdEncoding.EncodeToString(signed),
+	}
+	return nil
+}
+
+// Verify checks that the bundle carries a valid signature made with the signer key.
+// A missing or invalid signature is reported in the result, not as an error.
+func (bs *BundleSigner) Verify(bundle map[string]interface{}) SignatureVerification {
+	signature, ok := bundle["signature"].(map[string]interface{})
+	if !ok {
+		return SignatureVerification{Reason: "Bundle is not signed"}
+	}
+	result := SignatureVerification{}
+	result.SignedAt, _ = signature["when"].(string)
+	if format, _ := signature["sigFormat"].(string); format != signatureFormat {
+		result.Reason = "Unsupported signature format"
+		return result
+	}
+
+	data, _ := signature["data"].(string)
+	signed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(signed) == 0 {
+		result.Reason = "Signature data is not valid base64"
+		return result
+	}
+
+	message, err := jws.Parse(signed)
+	if err != nil || len(message.Signatures()) != 1 {
+		result.Reason = "Signature data is not a JWS"
+		return result
+	}
+	headers := message.Signatures()[0].ProtectedHeaders()
+	result.KeyID = headers.KeyID()
+	result.Algorithm = headers.Algorithm().String()
+	if result.KeyID != bs.KeyID {
+		result.Reason = "Bundle was not signed with the key of this service"
+		return result
+	}
+
+	payload, err := canonicalBundle(bundle)
+	if err != nil {
+		result.Reason = "Bundle could not be canonicalized"
+		return result
+	}
+	if _, err := jws.Verify(signed, jws.WithKey(bs.algorithm, bs.publicKey), jws.WithDetachedPayload(payload)); err != nil {
+		result.Reason = "Signature does not match the bundle content"
+		return result
+	}
+	result.Valid = true
+	return result
+}
+
+// SignPayload signs payload as a compact JWS carrying the signer key id, for content other than bundles.
+func (bs *BundleSigner) SignPayload(payload []byte, contentType string) ([]byte, error) {
+	headers := jws.NewHeaders()
+	if err := headers.Set(jws.KeyIDKey, bs.KeyID); err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		if err := headers.Set(jws.ContentTypeKey, contentType); err != nil {
+			return nil, err
+		}
+	}
+	signed, err := jws.Sign(payload, jws.WithKey(bs.algorithm, bs.key, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		return nil, fmt.Errorf("error signing payload: %w", err)
+	}
+	return signed, nil
+}
+
+// PublicJWK returns the public key of the signer as a JWK, with its key id and algorithm.
+func (bs *BundleSigner) PublicJWK() (jwk.Key, error) {
+	key, err := bs.publicKey.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.KeyIDKey, bs.KeyID); err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.AlgorithmKey, bs.algorithm); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func testSigner(t *testing.T, keyID string) *BundleSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	signer, err := NewBundleSigner(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), keyID)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+func TestMergeIPSSignature(t *testing.T) {
+	service := NewService(nil)
+	service.Signer = testSigner(t, "test-key")
+
+	current := ipsBundle("current", ipsComposition("urn:uuid:comp-1", "urn:uuid:imm-1"), immunizationEntry("urn:uuid:imm-1"))
+	newIps := ipsBundle("new", ipsComposition("urn:uuid:comp-2"))
+	result, err := service.MergeIPS(context.Background(), []map[string]interface{}{current, newIps}, MergeOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	verification, err := service.VerifyBundleSignature(context.Background(), result.Bundle)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !verification.Valid || verification.KeyID != "test-key" || verification.Algorithm != "ES256" {
+		t.Errorf("Expected a valid ES256 signature, got %+v", verification)
+	}
+
+	// Bundle.id and Bundle.meta are not part of the signed content
+	result.Bundle["id"] = "renamed"
+	if v := service.Signer.Verify(result.Bundle); !v.Valid {
+		t.Errorf("Expected the signature to survive an id change, got %+v", v)
+	}
+
+	result.Bundle["timestamp"] = "2020-01-01T00:00:00Z"
+	if v := service.Signer.Verify(result.Bundle); v.Valid {
+		t.Errorf("Expected a tampered bundle to fail verification")
+	}
+
+	other := testSigner(t, "other-key")
+	if v := other.Verify(result.Bundle); v.Valid {
+		t.Errorf("Expected a bundle signed with another key to fail verification")
+	}
+}
+
+func TestVerifyBundleSignatureWithoutSigner(t *testing.T) {
+	service := NewService(nil)
+	if _, err := service.VerifyBundleSignature(context.Background(), map[string]interface{}{"resourceType": "Bundle"}); err == nil {
+		t.Errorf("Expected an error when signing is not configured")
+	}
+}
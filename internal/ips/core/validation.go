@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	IssueSeverityFatal       = "fatal"
+	IssueSeverityError       = "error"
+	IssueSeverityWarning     = "warning"
+	IssueSeverityInformation = "information"
+)
+
+const loincSystem = "http://loinc.org"
+
+// ipsDocumentCode is the LOINC code of the Composition type of an IPS document.
+const ipsDocumentCode = "60591-5"
+
+// requiredSections are the sections every IPS must have, keyed by LOINC code.
+var requiredSections = []struct {
+	code string
+	name string
+}{
+	{code: "10160-0", name: "Medication Summary"},
+	{code: "48765-2", name: "Allergies and Intolerances"},
+	{code: "11450-4", name: "Problem List"},
+}
+
+// ValidationIssue is an OperationOutcome issue.
+type ValidationIssue struct {
+	Severity    string   `json:"severity"`
+	Code        string   `json:"code"`
+	Diagnostics string   `json:"diagnostics"`
+	Expression  []string `json:"expression,omitempty"`
+}
+
+// OperationOutcome lists the issues found when validating an IPS.
+type OperationOutcome struct {
+	ResourceType string            `json:"resourceType"`
+	Issue        []ValidationIssue `json:"issue"`
+}
+
+// HasErrors reports whether any issue is an error or fatal.
+func (o OperationOutcome) HasErrors() bool {
+	return slices.ContainsFunc(o.Issue, func(i ValidationIssue) bool {
+		return i.Severity == IssueSeverityError || i.Severity == IssueSeverityFatal
+	})
+}
+
+func (o *OperationOutcome) add(severity string, code string, expression string, diagnostics string) {
+	o.Issue = append(o.Issue, ValidationIssue{Severity: severity, Code: code, Diagnostics: diagnostics, Expression: []string{expression}})
+}
+
+// ValidateIPS checks that a bundle is an IPS document: a document Bundle whose first entry is an IPS
+// Composition with the required sections, a Patient, and references that resolve inside the bundle.
+// It is a structural check of those rules, not a full validation against the IPS profiles.
+func ValidateIPS(bundle map[string]interface{}) OperationOutcome {
+	outcome := OperationOutcome{ResourceType: "OperationOutcome", Issue: []ValidationIssue{}}
+
+	if rtype, _ := bundle["resourceType"].(string); rtype != "Bundle" {
+		outcome.add(IssueSeverityFatal, "structure", "Bundle", "Resource is not a Bundle")
+		return outcome
+	}
+	if btype, _ := bundle["type"].(string); btype != "document" {
+		outcome.add(IssueSeverityError, "value", "Bundle.type", fmt.Sprintf("Bundle type must be document, found %q", btype))
+	}
+
+	rawEntries, _ := bundle["entry"].([]interface{})
+	if len(rawEntries) == 0 {
+		outcome.add(IssueSeverityError, "required", "Bundle.entry", "Bundle has no entries")
+		return outcome
+	}
+	entries := make([]Entry, 0, len(rawEntries))
+	for i, raw := range rawEntries {
+		entry, _ := raw.(map[string]interface{})
+		resource, _ := entry["resource"].(map[string]interface{})
+		fullURL, _ := entry["fullUrl"].(string)
+		if resource == nil {
+			outcome.add(IssueSeverityError, "required", fmt.Sprintf("Bundle.entry[%d].resource", i), "Entry has no resource")
+		}
+		if fullURL == "" {
+			outcome.add(IssueSeverityWarning, "required", fmt.Sprintf("Bundle.entry[%d].fullUrl", i), "Entry has no fullUrl")
+		}
+		entries = append(entries, Entry{FullURL: fullURL, Resource: resource})
+	}
+
+	validateComposition(&outcome, entries)
+
+	if !slices.ContainsFunc(entries, func(e Entry) bool { return e.Resource["resourceType"] == "Patient" }) {
+		outcome.add(IssueSeverityError, "required", "Bundle.entry", "Bundle has no Patient")
+	}
+
+	for i, e := range entries {
+		if e.Resource == nil {
+			continue
+		}
+		for _, ref := range collectReferences(e.Resource) {
+			if resolveReference(ref, entries) == nil {
+				outcome.add(IssueSeverityError, "not-found", fmt.Sprintf("Bundle.entry[%d].resource", i), fmt.Sprintf("Reference %s does not resolve in the bundle", ref))
+			}
+		}
+	}
+
+	if len(outcome.Issue) == 0 {
+		outcome.add(IssueSeverityInformation, "informational", "Bundle", "IPS is valid")
+	}
+	return outcome
+}
+
+func validateComposition(outcome *OperationOutcome, entries []Entry) {
+	composition := entries[0].Resource
+	if rtype, _ := composition["resourceType"].(string); rtype != "Composition" {
+		outcome.add(IssueSeverityError, "structure", "Bundle.entry[0].resource", "First entry of the Bundle must be a Composition")
+		return
+	}
+	if !hasCoding(composition["type"], loincSystem, ipsDocumentCode) {
+		outcome.add(IssueSeverityError, "value", "Bundle.entry[0].resource.type", "Composition type must be LOINC "+ipsDocumentCode)
+	}
+
+	subject, _ := composition["subject"].(map[string]interface{})
+	reference, _ := subject["reference"].(string)
+	if reference == "" {
+		outcome.add(IssueSeverityError, "required", "Bundle.entry[0].resource.subject", "Composition has no subject")
+	} else if patient := resolveReference(reference, entries); patient != nil && patient.Resource["resourceType"] != "Patient" {
+		outcome.add(IssueSeverityError, "value", "Bundle.entry[0].resource.subject", "Composition subject must be a Patient")
+	}
+
+	sections, _ := composition["section"].([]interface{})
+	for _, required := range requiredSections {
+		found := slices.ContainsFunc(sections, func(s interface{}) bool {
+			section, _ := s.(map[string]interface{})
+			return hasCoding(section["code"], loincSystem, required.code)
+		})
+		if !found {
+			outcome.add(IssueSeverityError, "required", "Bundle.entry[0].resource.section", fmt.Sprintf("Composition has no %s section (LOINC %s)", required.name, required.code))
+		}
+	}
+}
+
+// hasCoding reports whether a CodeableConcept has a coding with the given system and code.
+func hasCoding(concept interface{}, system string, code string) bool {
+	return slices.Contains(conceptCodings(concept), system+"|"+code)
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"errors"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"slices"
+	"testing"
+)
+
+func validIpsBundle() map[string]interface{} {
+	composition := ipsComposition("urn:uuid:comp", "urn:uuid:statement")
+	resource := composition["resource"].(map[string]interface{})
+	for _, code := range []string{"48765-2", "11450-4"} {
+		resource["section"] = append(resource["section"].([]interface{}), map[string]interface{}{
+			"code": map[string]interface{}{"coding": []interface{}{map[string]interface{}{"system": "http://loinc.org", "code": code}}},
+		})
+	}
+	return ipsBundle("bundle", composition,
+		map[string]interface{}{"fullUrl": "urn:uuid:patient", "resource": map[string]interface{}{"resourceType": "Patient", "id": "patient"}},
+		map[string]interface{}{"fullUrl": "urn:uuid:statement", "resource": map[string]interface{}{
+			"resourceType": "MedicationStatement",
+			"subject":      map[string]interface{}{"reference": "Patient/patient"},
+		}},
+	)
+}
+
+func issueExpressions(outcome OperationOutcome) []string {
+	var expressions []string
+	for _, issue := range outcome.Issue {
+		if issue.Severity == IssueSeverityError || issue.Severity == IssueSeverityFatal {
+			expressions = append(expressions, issue.Expression...)
+		}
+	}
+	return expressions
+}
+
+func TestValidateIPS(t *testing.T) {
+	tests := []struct {
+		name       string
+		mutate     func(b map[string]interface{})
+		expression string
+	}{
+		{name: "valid"},
+		{name: "not a bundle", mutate: func(b map[string]interface{}) { b["resourceType"] = "Patient" }, expression: "Bundle"},
+		{name: "not a document", mutate: func(b map[string]interface{}) { b["type"] = "collection" }, expression: "Bundle.type"},
+		{
+			name: "composition not first",
+			mutate: func(b map[string]interface{}) {
+				entries := b["entry"].([]interface{})
+				entries[0], entries[1] = entries[1], entries[0]
+			},
+			expression: "Bundle.entry[0].resource",
+		},
+		{
+			name: "wrong composition type",
+			mutate: func(b map[string]interface{}) {
+				composition := b["entry"].([]interface{})[0].(map[string]interface{})["resource"].(map[string]interface{})
+				composition["type"] = coding("http://loinc.org", "11503-0")
+			},
+			expression: "Bundle.entry[0].resource.type",
+		},
+		{
+			name: "missing section",
+			mutate: func(b map[string]interface{}) {
+				composition := b["entry"].([]interface{})[0].(map[string]interface{})["resource"].(map[string]interface{})
+				composition["section"] = composition["section"].([]interface{})[:2]
+			},
+			expression: "Bundle.entry[0].resource.section",
+		},
+		{
+			name: "missing patient",
+			mutate: func(b map[string]interface{}) {
+				entries := b["entry"].([]interface{})
+				b["entry"] = []interface{}{entries[0], entries[2]}
+			},
+			expression: "Bundle.entry",
+		},
+		{
+			name: "unresolved reference",
+			mutate: func(b map[string]interface{}) {
+				statement := b["entry"].([]interface{})[2].(map[string]interface{})["resource"].(map[string]interface{})
+				statement["medicationReference"] = map[string]interface{}{"reference": "urn:uuid:missing"}
+			},
+			expression: "Bundle.entry[2].resource",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := validIpsBundle()
+			if tt.mutate != nil {
+				tt.mutate(bundle)
+			}
+			outcome := ValidateIPS(bundle)
+			if tt.expression == "" {
+				if outcome.HasErrors() {
+					t.Errorf("Expected no errors, got %+v", outcome.Issue)
+				}
+				return
+			}
+			if !slices.Contains(issueExpressions(outcome), tt.expression) {
+				t.Errorf("Expected an error at %s, got %+v", tt.expression, outcome.Issue)
+			}
+		})
+	}
+}
+
+func TestMergeIPSValidation(t *testing.T) {
+	service := NewService(nil)
+	invalid := ipsBundle("invalid", ipsComposition("urn:uuid:comp-2"))
+
+	_, err := service.MergeIPS(context.Background(), []map[string]interface{}{validIpsBundle(), invalid}, MergeOptions{Validate: true})
+	var httpErr *customErrors.HttpError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 422 {
+		t.Fatalf("Expected a 422 error, got %v", err)
+	}
+
+	if _, err := service.MergeIPS(context.Background(), []map[string]interface{}{validIpsBundle(), invalid}, MergeOptions{}); err != nil {
+		t.Errorf("Expected the merge to run without validation, got %v", err)
+	}
+}
@@ -3,38 +3,102 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	auditCore "ips-lacpass-backend/internal/audit/core"
 	"ips-lacpass-backend/internal/ips/core"
 	walletCache "ips-lacpass-backend/internal/wallet/cache"
+	walletClient "ips-lacpass-backend/internal/wallet/client"
 	errors2 "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
 	"ips-lacpass-backend/pkg/utils"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type ICVPDataResponse struct {
 	Data    string                 `json:"data"`
 	Payload map[string]interface{} `json:"payload"`
+	// Certificate is the signing algorithm and key, issuer and validity window of the ICVP.
+	Certificate utils.HCertSummary `json:"certificate"`
 }
 
+// MergeIPSRequest lists the IPS bundles to merge. Bundles are merged in order, the first one being
+// the base of the merge. CurrentIPS and NewIPS are kept for two-way merges and are used when
+// Bundles is empty.
 type MergeIPSRequest struct {
-	CurrentIPS map[string]interface{} `json:"current_ips"`
-	NewIPS     map[string]interface{} `json:"new_ips"`
+	Bundles    []map[string]interface{} `json:"bundles,omitempty"`
+	CurrentIPS map[string]interface{}   `json:"current_ips,omitempty"`
+	NewIPS     map[string]interface{}   `json:"new_ips,omitempty"`
+}
+
+// bundles returns the IPS bundles of the request in merge order.
+func (r MergeIPSRequest) bundles() []map[string]interface{} {
+	if len(r.Bundles) > 0 {
+		return r.Bundles
+	}
+	var bundles []map[string]interface{}
+	for _, b := range []map[string]interface{}{r.CurrentIPS, r.NewIPS} {
+		if b != nil {
+			bundles = append(bundles, b)
+		}
+	}
+	return bundles
+}
+
+// UnresolvedReferenceCountHeader counts the references of a merged IPS that could not be resolved. The
+// references themselves are listed in the merge report.
+const UnresolvedReferenceCountHeader = "Unresolved-Reference-Count"
+
+// MergeIPSResponse is returned by Merge when a merge report is requested.
+type MergeIPSResponse struct {
+	Bundle map[string]interface{} `json:"bundle"`
+	Report *core.MergeReport      `json:"report"`
+}
+
+// narrativeLocale picks the locale of generated narrative from the locale query parameter,
+// then from the Accept-Language header. An unsupported locale parameter is an error.
+func narrativeLocale(r *http.Request) (string, error) {
+	if param := r.URL.Query().Get("locale"); param != "" {
+		locale, ok := core.NarrativeLocale(param)
+		if !ok {
+			return "", &errors2.HttpError{
+				StatusCode: http.StatusBadRequest,
+				Body:       []map[string]interface{}{{"error": "invalid_locale", "message": "Locale must be one of es, en or pt-br"}},
+				Err:        fmt.Errorf("unsupported locale %q", param),
+			}
+		}
+		return locale, nil
+	}
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ = strings.Cut(tag, ";")
+		if locale, ok := core.NarrativeLocale(tag); ok {
+			return locale, nil
+		}
+	}
+	return core.DefaultNarrativeLocale, nil
 }
 
 type Handler struct {
 	IpsService *core.IpsService
+	// QROptions renders the QR images returned by GetICVP and printed in PDF.
+	QROptions utils.QROptions
+	// Audit records ICVP and PDF generation. Nil disables it.
+	Audit *auditCore.AuditService
 }
 
 func NewHandler(s *core.IpsService) *Handler {
 	return &Handler{
 		IpsService: s,
+		QROptions:  utils.DefaultQROptions,
 	}
 }
 
-// Get Fetch IPS from national node
+// Get Fetch IPS from national node, the newest one unless a documentId is given
 func (ih *Handler) Get(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	ips, err := ih.IpsService.GetIps(ctx)
+	ips, err := ih.IpsService.GetIps(ctx, r.URL.Query().Get("documentId"))
 	if err != nil {
 		slog.Error("Failed to get IPS", "error", err)
 		var httpErr *errors2.HttpError
@@ -70,7 +134,181 @@ func (ih *Handler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Merge erge two FHIR R4 IPS bundles into a single one, removing reduncancy
+// GetAll Fetch the user's IPS from every configured national node, optionally merged into one bundle
+func (ih *Handler) GetAll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	merge := r.URL.Query().Get("merge") == "true"
+	locale, err := narrativeLocale(r)
+	var aggregated *core.AggregatedIps
+	if err == nil {
+		aggregated, err = ih.IpsService.GetIpsFromAllNodes(ctx, merge, locale)
+	}
+	if err != nil {
+		slog.Error("Failed to get IPS from all nodes", "error", err)
+		var httpErr *errors2.HttpError
+		if errors.As(err, &httpErr) {
+			res, err := json.Marshal(httpErr.Body)
+			if err != nil {
+				http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(httpErr.StatusCode)
+			_, err = w.Write(res)
+			if err != nil {
+				http.Error(w, "Failed to write response", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res, err := json.Marshal(aggregated)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Sections Fetch the sections of the user's IPS with their entries resolved and flattened for display
+func (ih *Handler) Sections(w http.ResponseWriter, r *http.Request) {
+	locale, err := narrativeLocale(r)
+	var response interface{}
+	if err == nil {
+		response, err = ih.IpsService.GetIpsSections(r.Context(), locale)
+	}
+	writeJSON(w, response, err)
+}
+
+// Immunizations Fetch the immunizations of the user's IPS flattened for display
+func (ih *Handler) Immunizations(w http.ResponseWriter, r *http.Request) {
+	ih.section(w, r, core.SectionImmunizations)
+}
+
+// Allergies Fetch the allergies and intolerances of the user's IPS flattened for display
+func (ih *Handler) Allergies(w http.ResponseWriter, r *http.Request) {
+	ih.section(w, r, core.SectionAllergies)
+}
+
+// Medications Fetch the medication summary of the user's IPS flattened for display
+func (ih *Handler) Medications(w http.ResponseWriter, r *http.Request) {
+	ih.section(w, r, core.SectionMedications)
+}
+
+func (ih *Handler) section(w http.ResponseWriter, r *http.Request, code string) {
+	locale, err := narrativeLocale(r)
+	var response interface{}
+	if err == nil {
+		response, err = ih.IpsService.GetIpsSection(r.Context(), code, locale)
+	}
+	writeJSON(w, response, err)
+}
+
+// Documents List the IPS documents of the user, newest first
+func (ih *Handler) Documents(w http.ResponseWriter, r *http.Request) {
+	documents, err := ih.IpsService.ListDocuments(r.Context())
+	writeJSON(w, documents, err)
+}
+
+// Diff Compare two IPS documents of the user section by section
+func (ih *Handler) Diff(w http.ResponseWriter, r *http.Request) {
+	locale, err := narrativeLocale(r)
+	var response interface{}
+	if err == nil {
+		response, err = ih.IpsService.DiffIps(r.Context(), r.URL.Query().Get("from"), r.URL.Query().Get("to"), locale)
+	}
+	writeJSON(w, response, err)
+}
+
+func writeJSON(w http.ResponseWriter, response interface{}, err error) {
+	if err != nil {
+		slog.Error("Failed to serve IPS request", "error", err)
+		var httpErr *errors2.HttpError
+		if errors.As(err, &httpErr) {
+			res, err := json.Marshal(httpErr.Body)
+			if err != nil {
+				http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(httpErr.StatusCode)
+			_, err = w.Write(res)
+			if err != nil {
+				http.Error(w, "Failed to write response", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PDF Render the user's IPS, or the IPS of every node merged, as a printable PDF
+func (ih *Handler) PDF(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	locale, err := narrativeLocale(r)
+	var pdf []byte
+	if err == nil {
+		pdf, err = ih.IpsService.GetIpsPDF(r.Context(), core.PDFOptions{
+			DocumentID: query.Get("documentId"),
+			Merge:      query.Get("merge") == "true",
+			Locale:     locale,
+			QRPayload:  query.Get("qr"),
+			QRLevel:    ih.QROptions.Level,
+		})
+		ih.Audit.Record(r.Context(), auditCore.Event{Action: auditCore.ActionIpsPdfGenerate, SourceIP: r.RemoteAddr}, err)
+	}
+	if err != nil {
+		slog.Error("Failed to render IPS PDF", "error", err)
+		var httpErr *errors2.HttpError
+		if errors.As(err, &httpErr) {
+			res, err := json.Marshal(httpErr.Body)
+			if err != nil {
+				http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(httpErr.StatusCode)
+			_, err = w.Write(res)
+			if err != nil {
+				http.Error(w, "Failed to write response", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, "Failed to render IPS PDF", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="ips.pdf"`)
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(pdf)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Merge merges FHIR R4 IPS bundles into a single one, removing redundancy
 func (ih *Handler) Merge(w http.ResponseWriter, r *http.Request) {
 	var body MergeIPSRequest
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
@@ -80,13 +318,41 @@ func (ih *Handler) Merge(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
-	mi, err := ih.IpsService.MergeIPS(ctx, body.CurrentIPS, body.NewIPS)
+	withReport := r.URL.Query().Get("report") == "true"
+	validate := r.URL.Query().Get("validate") == "true"
+	locale, err := narrativeLocale(r)
+	var mi *core.MergeResult
+	if err == nil {
+		mi, err = ih.IpsService.MergeIPS(ctx, body.bundles(), core.MergeOptions{Report: withReport, Locale: locale, Validate: validate})
+	}
 	if err != nil {
-		// TODO Do correct error handling
-		http.Error(w, "Failed to merge IPS", http.StatusInternalServerError)
+		var httpErr *errors2.HttpError
+		if errors.As(err, &httpErr) {
+			res, err := json.Marshal(httpErr.Body)
+			if err != nil {
+				http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(httpErr.StatusCode)
+			_, err = w.Write(res)
+			if err != nil {
+				http.Error(w, "Failed to write response", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, "Failed to merge IPS", http.StatusInternalServerError)
+		}
+		return
 	}
 
-	res, err := json.Marshal(mi)
+	if len(mi.UnresolvedReferences) > 0 {
+		w.Header().Set(UnresolvedReferenceCountHeader, strconv.Itoa(len(mi.UnresolvedReferences)))
+	}
+	var response interface{} = mi.Bundle
+	if withReport {
+		response = MergeIPSResponse{Bundle: mi.Bundle, Report: mi.Report}
+	}
+	res, err := json.Marshal(response)
 	if err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
@@ -98,8 +364,81 @@ func (ih *Handler) Merge(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Validate Check that a bundle is an IPS document, returning an OperationOutcome with the issues found
+func (ih *Handler) Validate(w http.ResponseWriter, r *http.Request) {
+	var bundle map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+		res, _ := json.Marshal([]map[string]interface{}{{"error": "bad_request", "message": "Body must be a JSON FHIR Bundle"}})
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(res)
+		return
+	}
+
+	res, err := json.Marshal(core.ValidateIPS(bundle))
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// VerifySignature Check the signature of an IPS bundle merged by this backend
+func (ih *Handler) VerifySignature(w http.ResponseWriter, r *http.Request) {
+	var bundle map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+		res, _ := json.Marshal([]map[string]interface{}{{"error": "bad_request", "message": "Body must be a JSON FHIR Bundle"}})
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(res)
+		return
+	}
+
+	verification, err := ih.IpsService.VerifyBundleSignature(r.Context(), bundle)
+	if err != nil {
+		var httpErr *errors2.HttpError
+		if errors.As(err, &httpErr) {
+			res, err := json.Marshal(httpErr.Body)
+			if err != nil {
+				http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(httpErr.StatusCode)
+			_, err = w.Write(res)
+			if err != nil {
+				http.Error(w, "Failed to write response", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, "Failed to verify IPS signature", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res, err := json.Marshal(verification)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
+
 // GetICVP Generate ICVP vaccination certificate using the id of an IPS and optionally the id of an immunization.
+// The QR image is returned instead when asked with ?format= or Accept.
 func (ih *Handler) GetICVP(w http.ResponseWriter, r *http.Request) {
+	format, err := utils.QRImageFormat(r)
+	if err != nil {
+		utils.WriteUnsupportedQRFormat(w, err)
+		return
+	}
 	bundleId := r.URL.Query().Get("bundleId")
 	if bundleId == "" {
 		http.Error(w, "bundleId query parameter is required", http.StatusBadRequest)
@@ -114,6 +453,11 @@ func (ih *Handler) GetICVP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	icvp, err := ih.IpsService.GetIpsICVP(ctx, bundleId, immunizationIdPtr)
+	ih.Audit.Record(ctx, auditCore.Event{
+		Action:   auditCore.ActionIcvpGenerate,
+		SourceIP: r.RemoteAddr,
+		Detail:   map[string]string{"bundle_id": bundleId, "immunization_id": immunizationId},
+	}, err)
 	if err != nil {
 		var httpErr *errors2.HttpError
 		if errors.As(err, &httpErr) {
@@ -134,14 +478,20 @@ func (ih *Handler) GetICVP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	decodedPayload, err := utils.DecodeHCert(icvp)
+	decoded, err := utils.DecodeHCert(icvp)
 	if err != nil {
 		http.Error(w, "Failed to decode hcert", http.StatusInternalServerError)
 		return
 	}
-	walletCache.Set(decodedPayload, icvp)
+	if userID, err := authMiddleware.GetUserUUIDFromContext(r.Context()); err == nil {
+		walletCache.Set(userID, walletClient.ICVP, icvp)
+	}
+	if format != "" {
+		utils.WriteQRImage(w, icvp, format, ih.QROptions)
+		return
+	}
 
-	response := ICVPDataResponse{Data: icvp, Payload: decodedPayload}
+	response := ICVPDataResponse{Data: icvp, Payload: decoded.Claims, Certificate: decoded.Summary()}
 	res, err := json.Marshal(response)
 	if err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -1,11 +1,8 @@
 package client
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"ips-lacpass-backend/pkg/errors"
-	"ips-lacpass-backend/pkg/utils"
 	"net/http"
 )
 
@@ -6,6 +6,7 @@ import (
 	"ips-lacpass-backend/internal/medication/client"
 	customErrors "ips-lacpass-backend/pkg/errors"
 	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"ips-lacpass-backend/pkg/utils"
 	"log/slog"
 	"sort"
 )
@@ -69,7 +70,12 @@ func (s *Service) GetMedication(ctx context.Context) (map[string]interface{}, er
 	return bundle, nil
 }
 
-func (s *Service) GetMEOW(idBundle string, medicationStatementId *string) (string, error) {
+// GetMEOW returns the MEOW of one of the user's medication bundles. Bundles of other patients are
+// reported as not found.
+func (s *Service) GetMEOW(ctx context.Context, idBundle string, medicationStatementId *string) (string, error) {
+	if err := s.checkBundleOwner(ctx, idBundle); err != nil {
+		return "", err
+	}
 	result, err := s.Client.GetMEOW(idBundle, medicationStatementId)
 	if err != nil {
 		fmt.Printf("[medications/meow error] client failed bundleId=%s hasMedicationStatementId=%t error=%v\n", idBundle, medicationStatementId != nil, err)
@@ -78,3 +84,35 @@ func (s *Service) GetMEOW(idBundle string, medicationStatementId *string) (strin
 
 	return result, nil
 }
+
+// checkBundleOwner refuses bundles that are not the attachment of one of the user's DocumentReferences.
+func (s *Service) checkBundleOwner(ctx context.Context, idBundle string) error {
+	userId, err := authMiddleware.GetUserDocIDFromContext(ctx)
+	if err != nil {
+		return &customErrors.HttpError{
+			StatusCode: 401,
+			Body:       []map[string]interface{}{{"error": "user_identifier_not_found", "message": "User identifier not found in request context"}},
+			Err:        err,
+		}
+	}
+	docRef, err := s.Client.GetDocumentReference(userId)
+	if err != nil {
+		return err
+	}
+	for _, entry := range docRef.Entry {
+		if entry.Resource == nil {
+			continue
+		}
+		for _, content := range entry.Resource.Content {
+			if idBundle != "" && utils.BundleIDFromURL(content.Attachment.URL) == idBundle {
+				return nil
+			}
+		}
+	}
+	slog.Warn("Medication bundle not found for user", "userId", userId, "bundleId", idBundle)
+	return &customErrors.HttpError{
+		StatusCode: 404,
+		Body:       []map[string]interface{}{{"error": "bundle_not_found", "message": "Medication bundle not found for the user"}},
+		Err:        fmt.Errorf("bundle %s is not a medication bundle of the user", idBundle),
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"ips-lacpass-backend/internal/medication/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"testing"
+)
+
+// fakeClient serves the medication bundles of one patient.
+type fakeClient struct {
+	patient string
+	bundles []string
+}
+
+func (f *fakeClient) GetDocumentReference(identifier string) (*client.Bundle, error) {
+	bundle := &client.Bundle{ResourceType: "Bundle"}
+	if identifier != f.patient {
+		return bundle, nil
+	}
+	for _, id := range f.bundles {
+		bundle.Entry = append(bundle.Entry, client.BundleEntry{Resource: &client.EntryResource{
+			ResourceType: "DocumentReference",
+			Content:      []client.DocumentContent{{Attachment: client.Attachment{URL: "http://fhir.example.com/fhir/Bundle/" + id}}},
+		}})
+	}
+	return bundle, nil
+}
+
+func (f *fakeClient) GetBundle(url string) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+func (f *fakeClient) GetMEOW(idBundle string, medicationStatementId *string) (string, error) {
+	return "HC1:" + idBundle, nil
+}
+
+func patientContext(patient string) context.Context {
+	return context.WithValue(context.Background(), authMiddleware.UserDocIdKey, patient)
+}
+
+func TestGetMEOWOwnBundleOnly(t *testing.T) {
+	s := NewService(&fakeClient{patient: "patient-1", bundles: []string{"meds-1"}})
+
+	meow, err := s.GetMEOW(patientContext("patient-1"), "meds-1", nil)
+	if err != nil || meow != "HC1:meds-1" {
+		t.Fatalf("Expected the MEOW of the user's bundle, got %q (%v)", meow, err)
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		bundle string
+		status int
+	}{
+		{"bundle of another patient", patientContext("patient-2"), "meds-1", 404},
+		{"unknown bundle", patientContext("patient-1"), "meds-2", 404},
+		{"no user", context.Background(), "meds-1", 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.GetMEOW(tt.ctx, tt.bundle, nil)
+			var httpErr *customErrors.HttpError
+			if !errors.As(err, &httpErr) || httpErr.StatusCode != tt.status {
+				t.Errorf("Expected %d, got %v", tt.status, err)
+			}
+		})
+	}
+}
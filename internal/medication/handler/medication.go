@@ -5,28 +5,38 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	auditCore "ips-lacpass-backend/internal/audit/core"
 	walletCache "ips-lacpass-backend/internal/wallet/cache"
+	walletClient "ips-lacpass-backend/internal/wallet/client"
 	errors2 "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
 	"ips-lacpass-backend/pkg/utils"
 	"net/http"
 )
 
 type ServiceAdapter interface {
 	GetMedication(ctx context.Context) (map[string]interface{}, error)
-	GetMEOW(idBundle string, medicationStatementId *string) (string, error)
+	GetMEOW(ctx context.Context, idBundle string, medicationStatementId *string) (string, error)
 }
 type Handler struct {
 	Service ServiceAdapter
+	// QROptions renders the QR image returned when the request asks for png or svg.
+	QROptions utils.QROptions
+	// Audit records MEOW generation. Nil disables it.
+	Audit *auditCore.AuditService
 }
 
 type MEOWDataResponse struct {
 	Data    string                 `json:"data"`
 	Payload map[string]interface{} `json:"payload"`
+	// Certificate is the signing algorithm and key, issuer and validity window of the MEOW.
+	Certificate utils.HCertSummary `json:"certificate"`
 }
 
 func NewHandler(service ServiceAdapter) *Handler {
 	return &Handler{
-		Service: service,
+		Service:   service,
+		QROptions: utils.DefaultQROptions,
 	}
 }
 
@@ -68,6 +78,11 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetMeow(w http.ResponseWriter, r *http.Request) {
+	format, err := utils.QRImageFormat(r)
+	if err != nil {
+		utils.WriteUnsupportedQRFormat(w, err)
+		return
+	}
 	bundleId := r.URL.Query().Get("bundleId")
 	if bundleId == "" {
 		fmt.Printf("[medications/meow error] missing bundleId path=%s remoteAddr=%s\n", r.URL.Path, r.RemoteAddr)
@@ -81,7 +96,12 @@ func (h *Handler) GetMeow(w http.ResponseWriter, r *http.Request) {
 		medicationStatementIdPtr = &medicationStatementId
 	}
 
-	ips, err := h.Service.GetMEOW(bundleId, medicationStatementIdPtr)
+	ips, err := h.Service.GetMEOW(r.Context(), bundleId, medicationStatementIdPtr)
+	h.Audit.Record(r.Context(), auditCore.Event{
+		Action:   auditCore.ActionMeowGenerate,
+		SourceIP: r.RemoteAddr,
+		Detail:   map[string]string{"bundle_id": bundleId, "medication_statement_id": medicationStatementId},
+	}, err)
 	if err != nil {
 		fmt.Printf("[medications/meow error] service failed bundleId=%s hasMedicationStatementId=%t error=%v\n", bundleId, medicationStatementIdPtr != nil, err)
 		var httpErr *errors2.HttpError
@@ -106,15 +126,21 @@ func (h *Handler) GetMeow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	decodedPayload, err := utils.DecodeHCert(ips)
+	decoded, err := utils.DecodeHCert(ips)
 	if err != nil {
 		fmt.Printf("[medications/meow error] failed to decode hcert bundleId=%s error=%v\n", bundleId, err)
 		http.Error(w, "Failed to decode hcert", http.StatusInternalServerError)
 		return
 	}
-	walletCache.Set(decodedPayload, ips)
+	if userID, err := authMiddleware.GetUserUUIDFromContext(r.Context()); err == nil {
+		walletCache.Set(userID, walletClient.MEOW, ips)
+	}
+	if format != "" {
+		utils.WriteQRImage(w, ips, format, h.QROptions)
+		return
+	}
 
-	response := MEOWDataResponse{Data: ips, Payload: decodedPayload}
+	response := MEOWDataResponse{Data: ips, Payload: decoded.Claims, Certificate: decoded.Summary()}
 	res, err := json.Marshal(response)
 	if err != nil {
 		fmt.Printf("[medications/meow error] failed to encode success response bundleId=%s error=%v\n", bundleId, err)
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"ips-lacpass-backend/pkg/utils"
+	"net/http"
+	"time"
+)
+
+// RevocationClient downloads the revocation list published by a revocation endpoint.
+type RevocationClient struct {
+	Client *http.Client
+	URL    string
+}
+
+func NewClient(url string) RevocationClient {
+	return RevocationClient{
+		Client: &http.Client{Timeout: 30 * time.Second},
+		URL:    url,
+	}
+}
+
+// FetchList downloads the list, a JSON object with the revocation entries under "entries".
+// The response is returned raw so the core validates the entries.
+func (c *RevocationClient) FetchList(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch revocation list: %w", err)
+	}
+	defer utils.CloseBody(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocation list: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("revocation list is not JSON")
+	}
+	return body, nil
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"ips-lacpass-backend/pkg/utils"
+)
+
+// COSE algorithms whose signature is r followed by s, of which only r is hashed.
+var ecdsaAlgorithms = map[int64]bool{-7: true, -35: true, -36: true}
+
+// CertificateHashes are the revocation hashes of a certificate, by hash type, with the hex kid that signed it.
+type CertificateHashes struct {
+	KeyID  string
+	Hashes map[HashType]string
+}
+
+func truncatedHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// HashCertificate computes the revocation hashes of an HC1 payload. The UCI hashes are only computed
+// when the certificate carries a UCI.
+func HashCertificate(hcert string) (*CertificateHashes, error) {
+	signature, err := utils.DecodeHCertSignature(hcert)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding certificate signature: %w", err)
+	}
+	decoded, err := utils.DecodeHCert(hcert)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding certificate: %w", err)
+	}
+
+	signed := signature.Signature
+	if ecdsaAlgorithms[signature.Algorithm] {
+		signed = signed[:len(signed)/2]
+	}
+	hashes := &CertificateHashes{
+		KeyID:  hex.EncodeToString(signature.KeyID),
+		Hashes: map[HashType]string{HashTypeSignature: truncatedHash(signed)},
+	}
+	if uci := certificateUCI(decoded.Payload); uci != "" {
+		hashes.Hashes[HashTypeUCI] = truncatedHash([]byte(uci))
+		hashes.Hashes[HashTypeCountryCodeUCI] = truncatedHash([]byte(decoded.Issuer + uci))
+	}
+	return hashes, nil
+}
+
+// certificateUCI finds the ci field of the certificate inside the hcert claim (-260): at the top of
+// the certificate or in its first vaccination, test or recovery entry, as EU DCC and ICVP place it.
+func certificateUCI(hcert map[string]interface{}) string {
+	for _, certificate := range hcert {
+		c, ok := certificate.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if ci, ok := c["ci"].(string); ok && ci != "" {
+			return ci
+		}
+		for _, group := range []string{"v", "t", "r"} {
+			entry := c[group]
+			if entries, ok := entry.([]interface{}); ok && len(entries) > 0 {
+				entry = entries[0]
+			}
+			if e, ok := entry.(map[string]interface{}); ok {
+				if ci, ok := e["ci"].(string); ok && ci != "" {
+					return ci
+				}
+			}
+		}
+	}
+	return ""
+}
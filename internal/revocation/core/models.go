@@ -0,0 +1,57 @@
+package core
+
+import "time"
+
+// HashType is what a revocation hash is computed from, as in the EU DCC revocation lists.
+type HashType string
+
+const (
+	// HashTypeSignature hashes the COSE signature, only its r half for ECDSA.
+	HashTypeSignature HashType = "SIGNATURE"
+	// HashTypeUCI hashes the unique certificate identifier.
+	HashTypeUCI HashType = "UCI"
+	// HashTypeCountryCodeUCI hashes the issuing country code followed by the UCI.
+	HashTypeCountryCodeUCI HashType = "COUNTRYCODEUCI"
+)
+
+var AllowedHashTypes = map[string]HashType{
+	string(HashTypeSignature):      HashTypeSignature,
+	string(HashTypeUCI):            HashTypeUCI,
+	string(HashTypeCountryCodeUCI): HashTypeCountryCodeUCI,
+}
+
+type Source string
+
+const (
+	// SourceFile entries come from the revocation list file.
+	SourceFile Source = "file"
+	// SourceSync entries come from the revocation endpoint and are replaced on every sync.
+	SourceSync Source = "sync"
+	// SourceAdmin entries were revoked through the admin API.
+	SourceAdmin Source = "admin"
+)
+
+// Entry revokes the certificates whose hash of HashType is Hash. Hash is the hex of the first 128 bits
+// of the SHA-256 digest. When KeyID, the hex COSE kid, is set, only certificates signed with that key match.
+type Entry struct {
+	Hash      string    `json:"hash"`
+	HashType  HashType  `json:"hash_type"`
+	KeyID     string    `json:"kid,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Source    Source    `json:"source,omitempty"`
+}
+
+// List is the format of the revocation list file and of the revocation endpoint.
+type List struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Status reports whether a certificate is revoked and, if so, by which entry.
+type Status struct {
+	Revoked   bool       `json:"revoked"`
+	HashType  HashType   `json:"hash_type,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ips-lacpass-backend/internal/revocation/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type RevocationService struct {
+	Store *Store
+	// Client syncs the entries of a revocation endpoint. Nil disables the sync.
+	Client *client.RevocationClient
+}
+
+func NewService(store *Store) RevocationService {
+	return RevocationService{Store: store}
+}
+
+// Check reports whether an HC1 payload is revoked. A nil service never reports revocations, and a
+// payload whose hashes cannot be computed is reported as not revoked, its decoding failing elsewhere.
+func (rs *RevocationService) Check(hcert string) *Status {
+	if rs == nil {
+		return &Status{}
+	}
+	hashes, err := HashCertificate(hcert)
+	if err != nil {
+		slog.Debug("Revocation hashes not available", "error", err)
+		return &Status{}
+	}
+	entry, ok := rs.Store.Match(hashes)
+	if !ok {
+		return &Status{}
+	}
+	revokedAt := entry.RevokedAt
+	return &Status{Revoked: true, HashType: entry.HashType, Hash: entry.Hash, Reason: entry.Reason, RevokedAt: &revokedAt}
+}
+
+// Revoke adds admin entries for every hash of an HC1 payload: its signature and, when it has one, its UCI.
+func (rs *RevocationService) Revoke(ctx context.Context, hcert string, reason string) ([]Entry, error) {
+	hashes, err := HashCertificate(hcert)
+	if err != nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusBadRequest,
+			Body:       []map[string]interface{}{{"error": "invalid_hcert", "message": "Certificate could not be decoded"}},
+			Err:        err,
+		}
+	}
+
+	revokedAt := time.Now().UTC()
+	entries := []Entry{}
+	for _, hashType := range []HashType{HashTypeSignature, HashTypeUCI, HashTypeCountryCodeUCI} {
+		hash, ok := hashes.Hashes[hashType]
+		if !ok {
+			continue
+		}
+		entry := Entry{Hash: hash, HashType: hashType, KeyID: hashes.KeyID, Reason: reason, RevokedAt: revokedAt, Source: SourceAdmin}
+		if err := rs.Store.Add(entry); err != nil {
+			return nil, &customErrors.HttpError{
+				StatusCode: http.StatusInternalServerError,
+				Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to store the revocation"}},
+				Err:        err,
+			}
+		}
+		entries = append(entries, entry)
+	}
+	slog.Info("Certificate revoked", "kid", hashes.KeyID, "hashes", len(entries), "reason", reason)
+	return entries, nil
+}
+
+// Unrevoke lifts a file or admin revocation.
+func (rs *RevocationService) Unrevoke(ctx context.Context, hashType string, hash string) error {
+	typ, ok := AllowedHashTypes[strings.ToUpper(hashType)]
+	if !ok {
+		return &customErrors.HttpError{
+			StatusCode: http.StatusBadRequest,
+			Body:       []map[string]interface{}{{"error": "invalid_hash_type", "message": "Hash type must be one of SIGNATURE, UCI or COUNTRYCODEUCI"}},
+			Err:        fmt.Errorf("unknown hash type %q", hashType),
+		}
+	}
+	removed, err := rs.Store.Remove(typ, strings.ToLower(hash))
+	if err != nil {
+		return &customErrors.HttpError{
+			StatusCode: http.StatusInternalServerError,
+			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to store the revocation list"}},
+			Err:        err,
+		}
+	}
+	if !removed {
+		return &customErrors.HttpError{
+			StatusCode: http.StatusNotFound,
+			Body:       []map[string]interface{}{{"error": "not_found", "message": "No local revocation with this hash"}},
+			Err:        fmt.Errorf("revocation %s %s not found", typ, hash),
+		}
+	}
+	return nil
+}
+
+// Entries lists the revocations, newest first.
+func (rs *RevocationService) Entries(ctx context.Context) []Entry {
+	return rs.Store.Entries()
+}
+
+// Sync replaces the synced entries with the list of the revocation endpoint.
+func (rs *RevocationService) Sync(ctx context.Context) error {
+	if rs.Client == nil {
+		return errors.New("no revocation endpoint configured")
+	}
+	data, err := rs.Client.FetchList(ctx)
+	if err != nil {
+		return err
+	}
+	var list List
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("error parsing revocation list: %w", err)
+	}
+	entries := make([]Entry, 0, len(list.Entries))
+	for _, e := range list.Entries {
+		if _, ok := AllowedHashTypes[string(e.HashType)]; !ok || e.Hash == "" {
+			slog.Warn("Skipping invalid revocation entry", "hashType", e.HashType)
+			continue
+		}
+		e.Hash = strings.ToLower(e.Hash)
+		entries = append(entries, e)
+	}
+	rs.Store.ReplaceSynced(entries)
+	slog.Info("Revocation list synced", "url", rs.Client.URL, "entries", len(entries))
+	return nil
+}
+
+// StartSync syncs right away and then every interval until ctx is done. A failed sync keeps the previous entries.
+func (rs *RevocationService) StartSync(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := rs.Sync(ctx); err != nil {
+				slog.Error("Failed to sync revocation list, keeping the previous entries", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ips-lacpass-backend/internal/revocation/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// sampleHCert is the ICVP of test/hcert_test.go, signed with ES256 by kid 2350405fc1404cbb and without a UCI.
+const sampleHCert = "HC1:6BFOXN%TSMAHN-HJM80DOO8W%TG34UE726*2OC9Y.TW1ANU9SCE7JM:UC*ELIQ5B264IM:/42JO2 7V35U:7+V4YC5/HQ6EOHCRBK81EPFJM5C9YCBJ%GBVCL+9-0G2PBUDBACARDAEI97KE*LHXQM.FDBIK4LD JM3.K/HLNOI3.KH+G7IKSH9NOIEJK5+K6IASD9YHI1KKK3MYII3IKEIAM0G6JK%86%X49/SQN4:U45ALD-4$XKHBTQ1LTA3$73HRJFRJ9STE-4/-KFU4-EF:57MUBMTF*MCXJL  RGBFH*RK%4U7U*+RDQJHY23QPX4MQ2S1$U4ST236MDNW*PGNETTU4DK/$TJ7PS4JLDV%0K1GDMDP $A*EK/JP:T3%.4OYB"
+
+func TestHashCertificate(t *testing.T) {
+	hashes, err := HashCertificate(sampleHCert)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if hashes.KeyID != "2350405fc1404cbb" {
+		t.Errorf("Expected kid 2350405fc1404cbb, got %s", hashes.KeyID)
+	}
+	if len(hashes.Hashes[HashTypeSignature]) != 32 {
+		t.Errorf("Expected a 128 bits hex signature hash, got %q", hashes.Hashes[HashTypeSignature])
+	}
+	if _, ok := hashes.Hashes[HashTypeUCI]; ok {
+		t.Errorf("Expected no UCI hash for a certificate without ci, got %v", hashes.Hashes)
+	}
+
+	if _, err := HashCertificate("HC1:not-base45"); err == nil {
+		t.Error("Expected an error for an undecodable certificate")
+	}
+}
+
+func TestRevokeAndUnrevoke(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service := NewService(store)
+
+	if status := service.Check(sampleHCert); status.Revoked {
+		t.Fatalf("Expected the certificate not to be revoked yet, got %+v", status)
+	}
+	entries, err := service.Revoke(context.Background(), sampleHCert, "key compromise")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].HashType != HashTypeSignature || entries[0].Source != SourceAdmin {
+		t.Fatalf("Expected a single admin signature entry, got %+v", entries)
+	}
+	status := service.Check(sampleHCert)
+	if !status.Revoked || status.Reason != "key compromise" || status.RevokedAt == nil {
+		t.Errorf("Expected the certificate to be revoked, got %+v", status)
+	}
+
+	// the revocation survives a restart
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	reloadedService := NewService(reloaded)
+	if !reloadedService.Check(sampleHCert).Revoked {
+		t.Error("Expected the revocation to be loaded from the file")
+	}
+
+	err = reloadedService.Unrevoke(context.Background(), "signature", entries[0].Hash)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reloadedService.Check(sampleHCert).Revoked {
+		t.Error("Expected the certificate not to be revoked after unrevoke")
+	}
+	err = reloadedService.Unrevoke(context.Background(), "SIGNATURE", entries[0].Hash)
+	var httpErr *customErrors.HttpError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing revocation, got %v", err)
+	}
+	err = reloadedService.Unrevoke(context.Background(), "SERIAL", entries[0].Hash)
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown hash type, got %v", err)
+	}
+
+	var nilService *RevocationService
+	if nilService.Check(sampleHCert).Revoked {
+		t.Error("Expected a nil service to never report revocations")
+	}
+}
+
+func TestMatchKeyID(t *testing.T) {
+	hashes, err := HashCertificate(sampleHCert)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store, _ := NewStore("")
+	service := NewService(store)
+	_ = store.Add(Entry{Hash: hashes.Hashes[HashTypeSignature], HashType: HashTypeSignature, KeyID: "00ff"})
+	if service.Check(sampleHCert).Revoked {
+		t.Error("Expected an entry of another kid not to match")
+	}
+	_ = store.Add(Entry{Hash: hashes.Hashes[HashTypeSignature], HashType: HashTypeSignature})
+	if !service.Check(sampleHCert).Revoked {
+		t.Error("Expected an entry without kid to match")
+	}
+}
+
+func TestSync(t *testing.T) {
+	hashes, err := HashCertificate(sampleHCert)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	list := fmt.Sprintf(`{"entries":[{"hash":"%s","hash_type":"SIGNATURE","revoked_at":"2025-01-02T03:04:05Z"},{"hash":"aa","hash_type":"SERIAL"}]}`, hashes.Hashes[HashTypeSignature])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(list))
+	}))
+	defer server.Close()
+
+	store, _ := NewStore("")
+	service := NewService(store)
+	c := client.NewClient(server.URL)
+	service.Client = &c
+	if err := service.Sync(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	entries := service.Entries(context.Background())
+	if len(entries) != 1 || entries[0].Source != SourceSync {
+		t.Fatalf("Expected the valid synced entry only, got %+v", entries)
+	}
+	if !service.Check(sampleHCert).Revoked {
+		t.Error("Expected the synced entry to revoke the certificate")
+	}
+	if _, err := service.Store.Remove(HashTypeSignature, entries[0].Hash); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !service.Check(sampleHCert).Revoked {
+		t.Error("Expected synced entries not to be removable locally")
+	}
+
+	list = `{"entries":[]}`
+	if err := service.Sync(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if service.Check(sampleHCert).Revoked {
+		t.Error("Expected a new sync to replace the synced entries")
+	}
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store keeps the revocation entries. Entries of the file and of the admin API are persisted to the
+// file when there is one, synced entries only live in memory and are replaced on every sync.
+type Store struct {
+	mu     sync.RWMutex
+	path   string
+	local  map[string]Entry
+	synced map[string]Entry
+}
+
+func entryKey(hashType HashType, hash string) string {
+	return string(hashType) + "|" + hash
+}
+
+// NewStore loads the revocation list file at path. An empty path gives an in-memory store.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, local: make(map[string]Entry), synced: make(map[string]Entry)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading revocation list %s: %w", path, err)
+	}
+	var list List
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing revocation list %s: %w", path, err)
+	}
+	for _, e := range list.Entries {
+		if _, ok := AllowedHashTypes[string(e.HashType)]; !ok || e.Hash == "" {
+			return nil, fmt.Errorf("revocation list %s has an entry without a valid hash and hash_type", path)
+		}
+		if e.Source == "" {
+			e.Source = SourceFile
+		}
+		s.local[entryKey(e.HashType, e.Hash)] = e
+	}
+	return s, nil
+}
+
+// Add revokes an entry, replacing the one with the same hash.
+func (s *Store) Add(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := entryKey(entry.HashType, entry.Hash)
+	previous, existed := s.local[key]
+	s.local[key] = entry
+	if err := s.persist(); err != nil {
+		if existed {
+			s.local[key] = previous
+		} else {
+			delete(s.local, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Remove lifts a file or admin revocation. Synced entries can only be lifted by their endpoint.
+func (s *Store) Remove(hashType HashType, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := entryKey(hashType, hash)
+	previous, ok := s.local[key]
+	if !ok {
+		return false, nil
+	}
+	delete(s.local, key)
+	if err := s.persist(); err != nil {
+		s.local[key] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// ReplaceSynced replaces the entries of the revocation endpoint.
+func (s *Store) ReplaceSynced(entries []Entry) {
+	synced := make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		e.Source = SourceSync
+		synced[entryKey(e.HashType, e.Hash)] = e
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = synced
+}
+
+// Match returns the entry revoking a certificate, if any.
+func (s *Store) Match(hashes *CertificateHashes) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, hashType := range []HashType{HashTypeSignature, HashTypeUCI, HashTypeCountryCodeUCI} {
+		hash, ok := hashes.Hashes[hashType]
+		if !ok {
+			continue
+		}
+		key := entryKey(hashType, hash)
+		for _, entries := range []map[string]Entry{s.local, s.synced} {
+			if e, ok := entries[key]; ok && (e.KeyID == "" || e.KeyID == hashes.KeyID) {
+				return e, true
+			}
+		}
+	}
+	return Entry{}, false
+}
+
+// Entries returns every entry, newest first.
+func (s *Store) Entries() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]Entry, 0, len(s.local)+len(s.synced))
+	for _, e := range s.local {
+		entries = append(entries, e)
+	}
+	for key, e := range s.synced {
+		if _, ok := s.local[key]; !ok {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].RevokedAt.Equal(entries[j].RevokedAt) {
+			return entries[i].Hash < entries[j].Hash
+		}
+		return entries[i].RevokedAt.After(entries[j].RevokedAt)
+	})
+	return entries
+}
+
+// persist writes the file and admin entries to a temporary file renamed over the list file. Callers hold mu.
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+	list := List{Entries: make([]Entry, 0, len(s.local))}
+	for _, e := range s.local {
+		list.Entries = append(list.Entries, e)
+	}
+	sort.Slice(list.Entries, func(i, j int) bool { return list.Entries[i].RevokedAt.Before(list.Entries[j].RevokedAt) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding revocation list: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing revocation list: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing revocation list: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing revocation list: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("error writing revocation list: %w", err)
+	}
+	return nil
+}
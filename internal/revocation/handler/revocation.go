@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	auditCore "ips-lacpass-backend/internal/audit/core"
+	"ips-lacpass-backend/internal/revocation/core"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	Service *core.RevocationService
+	// Audit records revocations and their removal. Nil disables it.
+	Audit *auditCore.AuditService
+}
+
+func NewHandler(s *core.RevocationService) *Handler {
+	return &Handler{
+		Service: s,
+	}
+}
+
+// RevokeRequest carries the HC1 payload of the certificate to revoke.
+type RevokeRequest struct {
+	Data   string `json:"data"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var httpErr *customErrors.HttpError
+	if errors.As(err, &httpErr) {
+		res, err := json.Marshal(httpErr.Body)
+		if err != nil {
+			http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(httpErr.StatusCode)
+		_, err = w.Write(res)
+		if err != nil {
+			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// List the revoked certificate hashes, newest first
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Service.Entries(r.Context()))
+}
+
+// Revoke a certificate by its HC1 payload, adding its signature and UCI hashes to the revocation list
+func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+	var body RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, &customErrors.HttpError{
+			StatusCode: http.StatusBadRequest,
+			Body:       []map[string]interface{}{{"error": "invalid_body", "message": "Request body must be a JSON object"}},
+			Err:        err,
+		})
+		return
+	}
+	if !strings.HasPrefix(body.Data, "HC1:") {
+		writeError(w, &customErrors.HttpError{
+			StatusCode: http.StatusBadRequest,
+			Body:       []map[string]interface{}{{"error": "invalid_data", "message": "data must be an HC1 payload"}},
+			Err:        errors.New("payload is not an HC1 payload"),
+		})
+		return
+	}
+
+	entries, err := h.Service.Revoke(r.Context(), body.Data, body.Reason)
+	event := auditCore.Event{Action: auditCore.ActionHcertRevoke, SourceIP: r.RemoteAddr}
+	if err == nil && len(entries) > 0 {
+		event.Detail = map[string]string{"hash": entries[0].Hash, "kid": entries[0].KeyID}
+	}
+	h.Audit.Record(r.Context(), event, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, entries)
+}
+
+// Unrevoke removes a file or admin entry of the revocation list
+func (h *Handler) Unrevoke(w http.ResponseWriter, r *http.Request) {
+	hashType := chi.URLParam(r, "hashType")
+	hash := chi.URLParam(r, "hash")
+	err := h.Service.Unrevoke(r.Context(), hashType, hash)
+	h.Audit.Record(r.Context(), auditCore.Event{
+		Action:   auditCore.ActionHcertUnrevoke,
+		SourceIP: r.RemoteAddr,
+		Detail:   map[string]string{"hash_type": hashType, "hash": hash},
+	}, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
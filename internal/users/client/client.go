@@ -8,6 +8,7 @@ import (
 	"io"
 	"ips-lacpass-backend/pkg/errors"
 	"ips-lacpass-backend/pkg/utils"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -163,6 +164,9 @@ func (kc *UserClient) CreateUser(ctx context.Context, user map[string]interface{
 			},
 		},
 	}
+	if node, _ := user["Node"].(string); node != "" {
+		r.Attributes["node"] = []string{node}
+	}
 
 	body, err := json.Marshal(r)
 	if err != nil {
@@ -215,7 +219,7 @@ func (kc *UserClient) CreateUser(ctx context.Context, user map[string]interface{
 		newCtx := context.Background()
 		if err := kc.SendValidationEmail(newCtx, userID); err != nil {
 			// Log the error but don't return it since this is running asynchronously
-			fmt.Errorf("error sending validation email: %v", err)
+			slog.Error("Failed to send validation email", "userId", userID, "error", err)
 		}
 	}()
 	// Return the first user since we're querying by username/email
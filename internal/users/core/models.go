@@ -21,6 +21,7 @@ type User struct {
 	Locale       string
 	DocumentType DocumentType
 	Identifier   string
+	Node         string
 }
 
 type UserRequest struct {
@@ -32,6 +33,7 @@ type UserRequest struct {
 	Locale          string       `json:"locale" binding:"required"`
 	DocumentType    DocumentType `json:"document_type" binding:"required"`
 	Identifier      string       `json:"identifier" binding:"required"`
+	Node            string       `json:"node"`
 }
 
 type UserUpdateRequest struct {
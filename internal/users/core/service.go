@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"ips-lacpass-backend/internal/users/client"
 	customErrors "ips-lacpass-backend/pkg/errors"
 	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"slices"
 )
 
 type ServiceInterface interface {
@@ -16,6 +18,9 @@ type ServiceInterface interface {
 
 type UserService struct {
 	Client client.ClientInterface
+	// Nodes are the configured node ids a user can be bound to at signup. Signup is unauthenticated, so the
+	// home node is only taken from the body when it is one of them, never from the Node-Name header.
+	Nodes []string
 }
 
 func NewService(r client.ClientInterface) UserService {
@@ -38,6 +43,17 @@ func structToMap(obj interface{}) (map[string]interface{}, error) {
 }
 
 func (us *UserService) CreateUser(ctx context.Context, ur UserRequest) (*User, error) {
+	node := ur.Node
+	if len(us.Nodes) == 0 {
+		node = ""
+	} else if node != "" && !slices.Contains(us.Nodes, node) {
+		return nil, &customErrors.HttpError{
+			StatusCode: 400,
+			Body:       []map[string]interface{}{{"error": "invalid_node", "message": "Unknown node"}},
+			Err:        fmt.Errorf("unknown node %q", node),
+		}
+	}
+
 	user := &User{
 		Username:     ur.Identifier,
 		Email:        ur.Email,
@@ -46,6 +62,7 @@ func (us *UserService) CreateUser(ctx context.Context, ur UserRequest) (*User, e
 		Locale:       ur.Locale,
 		DocumentType: ur.DocumentType,
 		Identifier:   ur.Identifier,
+		Node:         node,
 	}
 
 	// TODO fix this workaround for cyclic dependency issue
@@ -103,6 +120,10 @@ func (us *UserService) UpdateUser(ctx context.Context, ur UserUpdateRequest) (*U
 			Body:       []map[string]interface{}{{"error": "auth_service_error", "message": "Failed to connect to authentication service"}}, Err: err,
 		}
 	}
+	var node string
+	if nodes := resp.Attributes["node"]; len(nodes) > 0 {
+		node = nodes[0]
+	}
 	return &User{
 		Username:     resp.ID,
 		Email:        resp.Email,
@@ -111,5 +132,6 @@ func (us *UserService) UpdateUser(ctx context.Context, ur UserUpdateRequest) (*U
 		Locale:       resp.Attributes["locale"][0],
 		DocumentType: AllowedDocumenTypes[resp.Attributes["document_type"][0]],
 		Identifier:   resp.ID,
+		Node:         node,
 	}, nil
 }
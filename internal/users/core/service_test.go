@@ -0,0 +1,65 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"ips-lacpass-backend/internal/users/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"testing"
+)
+
+type fakeClient struct {
+	created map[string]interface{}
+}
+
+func (c *fakeClient) SendValidationEmail(ctx context.Context, userID string) error {
+	return nil
+}
+
+func (c *fakeClient) CreateUser(ctx context.Context, user map[string]interface{}, password string) (*client.UserID, error) {
+	c.created = user
+	return &client.UserID{ID: "user"}, nil
+}
+
+func (c *fakeClient) UpdateUser(ctx context.Context, userUUID string, ur map[string]interface{}) (*client.UserRegistration, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestCreateUserNode(t *testing.T) {
+	headerCtx := context.WithValue(context.Background(), authMiddleware.NodeNameKey, "lacpass")
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		nodes []string
+		node  string
+		want  string
+	}{
+		{"configured node", context.Background(), []string{"lacpass", "other"}, "other", "other"},
+		{"header is ignored", headerCtx, []string{"lacpass"}, "", ""},
+		{"single node configuration", context.Background(), nil, "lacpass", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeClient{}
+			s := NewService(c)
+			s.Nodes = tt.nodes
+			user, err := s.CreateUser(tt.ctx, UserRequest{Identifier: "12345", Node: tt.node})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if user.Node != tt.want || c.created["Node"] != tt.want {
+				t.Errorf("Expected home node %q, got %q", tt.want, user.Node)
+			}
+		})
+	}
+
+	s := NewService(&fakeClient{})
+	s.Nodes = []string{"lacpass"}
+	_, err := s.CreateUser(headerCtx, UserRequest{Identifier: "12345", Node: "unknown"})
+	var httpErr *customErrors.HttpError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 400 {
+		t.Errorf("Expected unknown nodes to be refused, got %v", err)
+	}
+}
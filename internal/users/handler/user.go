@@ -48,6 +48,7 @@ type UserResponse struct {
 	Locale       string `json:"locale"`
 	DocumentType string `json:"document_type"`
 	Identifier   string `json:"identifier"`
+	Node         string `json:"node,omitempty"`
 }
 
 type userCreationRequest struct {
@@ -59,6 +60,7 @@ type userCreationRequest struct {
 	Locale          string `json:"locale" validate:"required,oneof=es en pt-br"`
 	DocumentType    string `json:"document_type" validate:"required,oneof=passport identifier"`
 	Identifier      string `json:"identifier" validate:"required"`
+	Node            string `json:"node,omitempty"`
 }
 
 type userUpdateRequest struct {
@@ -133,6 +135,7 @@ func (u *Handler) Create(w http.ResponseWriter, r *http.Request) {
 		Locale:          body.Locale,
 		DocumentType:    core.AllowedDocumenTypes[body.DocumentType],
 		Identifier:      body.Identifier,
+		Node:            body.Node,
 	})
 
 	if err != nil {
@@ -164,6 +167,7 @@ func (u *Handler) Create(w http.ResponseWriter, r *http.Request) {
 			Locale:       user.Locale,
 			DocumentType: string(user.DocumentType),
 			Identifier:   user.Identifier,
+			Node:         user.Node,
 		})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -236,6 +240,7 @@ func (u *Handler) Update(w http.ResponseWriter, r *http.Request) {
 			Locale:       user.Locale,
 			DocumentType: string(user.DocumentType),
 			Identifier:   user.Identifier,
+			Node:         user.Node,
 		})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
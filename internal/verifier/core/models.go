@@ -0,0 +1,68 @@
+package core
+
+import "time"
+
+// PackageContentType is the cty header of the signed verifier package.
+const PackageContentType = "application/vnd.lacpass.verifier-package+json"
+
+// TrustedKey is a document signer key of the HCERT trust list, as a public JWK.
+type TrustedKey struct {
+	KeyID     string                 `json:"kid"`
+	Country   string                 `json:"country,omitempty"`
+	NotBefore string                 `json:"not_before,omitempty"`
+	NotAfter  string                 `json:"not_after,omitempty"`
+	PublicKey map[string]interface{} `json:"public_key"`
+}
+
+// ValueSetEntry is the display of a code of an ICVP claim.
+type ValueSetEntry struct {
+	Display string `json:"display"`
+	System  string `json:"system,omitempty"`
+}
+
+// ValueSets maps the codes of ICVP vaccination claims, such as vp, by claim name.
+type ValueSets map[string]map[string]ValueSetEntry
+
+// Revocations lists the certificates a verifier must refuse.
+type Revocations struct {
+	// PayloadHashes are the SHA-256 hex digests of revoked HC1 payloads, the whole string with its prefix.
+	PayloadHashes []string `json:"payload_hashes"`
+	// Certificates are the EU DCC style hashes of revoked certificates.
+	Certificates []RevokedCertificate `json:"certificates"`
+}
+
+// RevokedCertificate revokes the certificates whose hash of HashType, SIGNATURE, UCI or COUNTRYCODEUCI,
+// is Hash: the hex of the first 128 bits of its SHA-256 digest. When KeyID is set only certificates of that kid match.
+type RevokedCertificate struct {
+	Hash     string `json:"hash"`
+	HashType string `json:"hash_type"`
+	KeyID    string `json:"kid,omitempty"`
+}
+
+// ValidationRules are the checks the backend applies to an HC1 payload, for verifiers to repeat offline.
+type ValidationRules struct {
+	PayloadPrefixes []string `json:"payload_prefixes"`
+	// RequireTrustedKey refuses payloads whose COSE kid is not in the trust list.
+	RequireTrustedKey bool `json:"require_trusted_key"`
+	// CheckExpiration refuses payloads whose CWT exp claim (4) is in the past.
+	CheckExpiration bool `json:"check_expiration"`
+	// ClaimPath is the path to the ICVP in the CWT claims, hcert (-260) then ICVP (-6).
+	ClaimPath []string `json:"claim_path"`
+	// RequiredFields are the ICVP fields that must be present, dotted for nested ones.
+	RequiredFields []string `json:"required_fields"`
+	// CodedFields are the vaccination fields whose codes must appear in the value set of the same name.
+	CodedFields []string `json:"coded_fields"`
+}
+
+// Package is the content verifiers need to check ICVPs offline. Version changes whenever the trust
+// list, value sets, revocations or rules change, and the package must not be used after ExpiresAt.
+type Package struct {
+	Version     string          `json:"version"`
+	Issuer      string          `json:"issuer"`
+	IssuedAt    time.Time       `json:"issued_at"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	TrustList   []TrustedKey    `json:"trust_list"`
+	ValueSets   ValueSets       `json:"value_sets"`
+	Revocations Revocations     `json:"revocations"`
+	Rules       ValidationRules `json:"rules"`
+}
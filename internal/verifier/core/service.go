@@ -0,0 +1,322 @@
+package core
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	ipsCore "ips-lacpass-backend/internal/ips/core"
+	revocationCore "ips-lacpass-backend/internal/revocation/core"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// DefaultPackageTTL is how long verifiers may use a package before downloading a new one.
+const DefaultPackageTTL = 24 * time.Hour
+
+// RevocationSource provides the hashes of revoked HC1 payloads.
+type RevocationSource interface {
+	RevokedPayloadHashes() []string
+}
+
+// DefaultRules are the checks applied by the backend to ICVP payloads.
+var DefaultRules = ValidationRules{
+	PayloadPrefixes:   []string{"HC1:"},
+	RequireTrustedKey: true,
+	CheckExpiration:   true,
+	ClaimPath:         []string{"-260", "-6"},
+	RequiredFields:    []string{"n", "dob", "v.vp", "v.dt"},
+}
+
+type VerifierService struct {
+	// Signer signs the packages. Without it packages cannot be exported.
+	Signer *ipsCore.BundleSigner
+	// TrustListFile and ValueSetsFile are read on every export, so updates are picked up without restart.
+	TrustListFile string
+	ValueSetsFile string
+	Revocations   []RevocationSource
+	// Certificates is the HCERT revocation list. Nil exports no certificate revocations.
+	Certificates *revocationCore.RevocationService
+	TTL          time.Duration
+	Issuer       string
+	Rules        ValidationRules
+}
+
+func NewService(signer *ipsCore.BundleSigner) VerifierService {
+	return VerifierService{
+		Signer: signer,
+		TTL:    DefaultPackageTTL,
+		Issuer: "IPS Lacpass backend",
+		Rules:  DefaultRules,
+	}
+}
+
+// trustListEntry is a key of the trust list file, whose public_key is a JWK object or a PEM public key or certificate.
+type trustListEntry struct {
+	KeyID     string          `json:"kid"`
+	Country   string          `json:"country"`
+	NotBefore string          `json:"not_before"`
+	NotAfter  string          `json:"not_after"`
+	PublicKey json.RawMessage `json:"public_key"`
+}
+
+// LoadTrustList reads a trust list file. Keys are exported as public JWKs, and keys whose not_after
+// has passed are left out.
+func LoadTrustList(path string, now time.Time) ([]TrustedKey, error) {
+	keys := []TrustedKey{}
+	if path == "" {
+		return keys, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trust list %s: %w", path, err)
+	}
+	var entries []trustListEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing trust list %s: %w", path, err)
+	}
+
+	for i, entry := range entries {
+		if entry.KeyID == "" {
+			return nil, fmt.Errorf("trust list entry %d has no kid", i)
+		}
+		if entry.NotAfter != "" {
+			notAfter, err := time.Parse(time.RFC3339, entry.NotAfter)
+			if err != nil {
+				return nil, fmt.Errorf("trust list key %s has an invalid not_after: %w", entry.KeyID, err)
+			}
+			if now.After(notAfter) {
+				slog.Info("Leaving expired key out of the trust list", "kid", entry.KeyID, "notAfter", entry.NotAfter)
+				continue
+			}
+		}
+
+		raw := []byte(entry.PublicKey)
+		var pemData string
+		if json.Unmarshal(entry.PublicKey, &pemData) == nil {
+			raw = []byte(pemData)
+		}
+		key, err := jwk.ParseKey(raw, jwk.WithPEM(pemData != ""))
+		if err != nil {
+			return nil, fmt.Errorf("trust list key %s is not a valid key: %w", entry.KeyID, err)
+		}
+		public, err := jwk.PublicKeyOf(key)
+		if err != nil {
+			return nil, fmt.Errorf("trust list key %s has no public key: %w", entry.KeyID, err)
+		}
+		if err := public.Set(jwk.KeyIDKey, entry.KeyID); err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(public)
+		if err != nil {
+			return nil, err
+		}
+		trusted := TrustedKey{KeyID: entry.KeyID, Country: entry.Country, NotBefore: entry.NotBefore, NotAfter: entry.NotAfter}
+		if err := json.Unmarshal(encoded, &trusted.PublicKey); err != nil {
+			return nil, err
+		}
+		keys = append(keys, trusted)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KeyID < keys[j].KeyID })
+	return keys, nil
+}
+
+// LoadValueSets reads a value sets file, mapping claim names to their codes.
+func LoadValueSets(path string) (ValueSets, error) {
+	valueSets := ValueSets{}
+	if path == "" {
+		return valueSets, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading value sets %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &valueSets); err != nil {
+		return nil, fmt.Errorf("error parsing value sets %s: %w", path, err)
+	}
+	return valueSets, nil
+}
+
+// BuildPackage assembles the current verifier package.
+func (vs *VerifierService) BuildPackage(now time.Time) (*Package, error) {
+	trustList, err := LoadTrustList(vs.TrustListFile, now)
+	if err != nil {
+		return nil, err
+	}
+	valueSets, err := LoadValueSets(vs.ValueSetsFile)
+	if err != nil {
+		return nil, err
+	}
+	revocations := Revocations{PayloadHashes: []string{}, Certificates: []RevokedCertificate{}}
+	for _, source := range vs.Revocations {
+		revocations.PayloadHashes = append(revocations.PayloadHashes, source.RevokedPayloadHashes()...)
+	}
+	sort.Strings(revocations.PayloadHashes)
+	revocations.PayloadHashes = slices.Compact(revocations.PayloadHashes)
+	if vs.Certificates != nil {
+		for _, entry := range vs.Certificates.Store.Entries() {
+			revocations.Certificates = append(revocations.Certificates, RevokedCertificate{Hash: entry.Hash, HashType: string(entry.HashType), KeyID: entry.KeyID})
+		}
+	}
+	sort.Slice(revocations.Certificates, func(i, j int) bool {
+		if revocations.Certificates[i].HashType != revocations.Certificates[j].HashType {
+			return revocations.Certificates[i].HashType < revocations.Certificates[j].HashType
+		}
+		return revocations.Certificates[i].Hash < revocations.Certificates[j].Hash
+	})
+
+	rules := vs.Rules
+	rules.CodedFields = []string{}
+	for field := range valueSets {
+		rules.CodedFields = append(rules.CodedFields, "v."+field)
+	}
+	sort.Strings(rules.CodedFields)
+
+	issuedAt := vs.issuedAt(now)
+	pkg := &Package{
+		Issuer:      vs.Issuer,
+		IssuedAt:    issuedAt,
+		ExpiresAt:   issuedAt.Add(vs.TTL),
+		TrustList:   trustList,
+		ValueSets:   valueSets,
+		Revocations: revocations,
+		Rules:       rules,
+	}
+	pkg.Version, err = packageVersion(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// issuedAt returns the issue time of a package built at now, the start of the current half of the TTL.
+// Packages built in the same half share their expiry and so their version, and a package whose version
+// is still current has at least half of its TTL left.
+func (vs *VerifierService) issuedAt(now time.Time) time.Time {
+	return now.UTC().Truncate(max(vs.TTL/2, time.Second))
+}
+
+// packageVersion hashes the content and the expiry of a package, so that a package whose version is
+// current has not expired.
+func packageVersion(pkg *Package) (string, error) {
+	content, err := json.Marshal(struct {
+		ExpiresAt   time.Time       `json:"expires_at"`
+		TrustList   []TrustedKey    `json:"trust_list"`
+		ValueSets   ValueSets       `json:"value_sets"`
+		Revocations Revocations     `json:"revocations"`
+		Rules       ValidationRules `json:"rules"`
+	}{pkg.ExpiresAt, pkg.TrustList, pkg.ValueSets, pkg.Revocations, pkg.Rules})
+	if err != nil {
+		return "", fmt.Errorf("error encoding verifier package: %w", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(content))[:16], nil
+}
+
+// ExportPackage builds the verifier package and signs it as a compact JWS.
+func (vs *VerifierService) ExportPackage(ctx context.Context) ([]byte, *Package, error) {
+	if vs.Signer == nil {
+		return nil, nil, signingNotConfigured()
+	}
+	pkg, err := vs.BuildPackage(time.Now())
+	if err != nil {
+		slog.Error("Failed to build verifier package", "error", err)
+		return nil, nil, &customErrors.HttpError{
+			StatusCode: http.StatusInternalServerError,
+			Body:       []map[string]interface{}{{"error": "invalid_verifier_data", "message": "Trust list or value sets could not be loaded"}},
+			Err:        err,
+		}
+	}
+	payload, err := json.Marshal(pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+	signed, err := vs.Signer.SignPayload(payload, PackageContentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signed, pkg, nil
+}
+
+// PublicKeys returns the JWK set verifiers use to check the package signature.
+func (vs *VerifierService) PublicKeys(ctx context.Context) (jwk.Set, error) {
+	if vs.Signer == nil {
+		return nil, signingNotConfigured()
+	}
+	key, err := vs.Signer.PublicJWK()
+	if err != nil {
+		return nil, err
+	}
+	set := jwk.NewSet()
+	if err := set.AddKey(key); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// HCertKeys returns the trust list keys of an HCERT kid, written in the trust list in hex or base64 as EU DCC
+// trust lists do. The file is read on every call, and a trust list that cannot be read trusts no key.
+func (vs *VerifierService) HCertKeys(kid []byte) []crypto.PublicKey {
+	now := time.Now()
+	trustList, err := LoadTrustList(vs.TrustListFile, now)
+	if err != nil {
+		slog.Error("Error loading trust list, no HCERT key is trusted", "file", vs.TrustListFile, "error", err)
+		return nil
+	}
+	hexKid := hex.EncodeToString(kid)
+	base64Kids := []string{base64.StdEncoding.EncodeToString(kid), base64.RawURLEncoding.EncodeToString(kid)}
+
+	keys := []crypto.PublicKey{}
+	for _, trusted := range trustList {
+		if !strings.EqualFold(trusted.KeyID, hexKid) && !slices.Contains(base64Kids, trusted.KeyID) {
+			continue
+		}
+		if notBefore, err := time.Parse(time.RFC3339, trusted.NotBefore); err == nil && now.Before(notBefore) {
+			continue
+		}
+		encoded, err := json.Marshal(trusted.PublicKey)
+		if err != nil {
+			continue
+		}
+		key, err := jwk.ParseKey(encoded)
+		if err != nil {
+			continue
+		}
+		var raw interface{}
+		if err := key.Raw(&raw); err != nil {
+			continue
+		}
+		keys = append(keys, raw)
+	}
+	return keys
+}
+
+func signingNotConfigured() error {
+	return &customErrors.HttpError{
+		StatusCode: http.StatusServiceUnavailable,
+		Body:       []map[string]interface{}{{"error": "signing_not_configured", "message": "Verifier packages cannot be exported without a signing key"}},
+		Err:        errors.New("no signing key configured"),
+	}
+}
+
+// MatchesVersion reports whether an If-None-Match header names the package version.
+func MatchesVersion(ifNoneMatch string, version string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == `"`+version+`"` || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
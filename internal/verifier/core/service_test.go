@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	ipsCore "ips-lacpass-backend/internal/ips/core"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+type revokedHashes []string
+
+func (r revokedHashes) RevokedPayloadHashes() []string { return r }
+
+func newTestSigner(t *testing.T) *ipsCore.BundleSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	signer, err := ipsCore.NewBundleSigner(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), "verifier-test")
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+func writeTrustList(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	entries := []map[string]interface{}{
+		{"kid": "dsc-cl", "country": "CL", "public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))},
+		{"kid": "dsc-old", "country": "CL", "not_after": "2020-01-01T00:00:00Z", "public_key": map[string]string{"kty": "EC", "crv": "P-256", "x": "AA", "y": "AA"}},
+	}
+	data, _ := json.Marshal(entries)
+	path := filepath.Join(dir, "trust-list.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write trust list: %v", err)
+	}
+	return path
+}
+
+func TestExportPackage(t *testing.T) {
+	dir := t.TempDir()
+	valueSets := filepath.Join(dir, "value-sets.json")
+	if err := os.WriteFile(valueSets, []byte(`{"vp":{"YellowFeverProductd2c75a15ed309658b3968519ddb31690":{"display":"Yellow fever vaccine"}}}`), 0o600); err != nil {
+		t.Fatalf("Failed to write value sets: %v", err)
+	}
+	s := NewService(newTestSigner(t))
+	s.TrustListFile = writeTrustList(t, dir)
+	s.ValueSetsFile = valueSets
+	s.Revocations = []RevocationSource{revokedHashes{"bb", "aa"}, revokedHashes{"aa"}}
+
+	signed, pkg, err := s.ExportPackage(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pkg.TrustList) != 1 || pkg.TrustList[0].KeyID != "dsc-cl" || pkg.TrustList[0].PublicKey["kty"] != "EC" || pkg.TrustList[0].PublicKey["d"] != nil {
+		t.Errorf("Expected only the current key as a public JWK, got %+v", pkg.TrustList)
+	}
+	if len(pkg.Revocations.PayloadHashes) != 2 || pkg.Revocations.PayloadHashes[0] != "aa" {
+		t.Errorf("Expected the revocations sorted without duplicates, got %v", pkg.Revocations.PayloadHashes)
+	}
+	if len(pkg.Rules.CodedFields) != 1 || pkg.Rules.CodedFields[0] != "v.vp" {
+		t.Errorf("Expected vp to be a coded field, got %v", pkg.Rules.CodedFields)
+	}
+	if !pkg.ExpiresAt.Equal(pkg.IssuedAt.Add(DefaultPackageTTL)) {
+		t.Errorf("Expected the package to expire after %s, got %s to %s", DefaultPackageTTL, pkg.IssuedAt, pkg.ExpiresAt)
+	}
+
+	keys, err := s.PublicKeys(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	payload, err := jws.Verify(signed, jws.WithKeySet(keys, jws.WithRequireKid(true)))
+	if err != nil {
+		t.Fatalf("Expected the package signature to verify with the published keys: %v", err)
+	}
+	var exported Package
+	if err := json.Unmarshal(payload, &exported); err != nil || exported.Version != pkg.Version {
+		t.Errorf("Expected the signed payload to be the package, got %s (%v)", payload, err)
+	}
+
+	issued := time.Now().UTC().Truncate(DefaultPackageTTL / 2)
+	first, _ := s.BuildPackage(issued)
+	later, err := s.BuildPackage(issued.Add(time.Hour))
+	if err != nil || later.Version != first.Version || !later.ExpiresAt.Equal(first.ExpiresAt) {
+		t.Errorf("Expected the version to stay the same while the content does not change, got %v (%v)", later, err)
+	}
+	renewed, _ := s.BuildPackage(issued.Add(DefaultPackageTTL / 2))
+	if renewed.Version == first.Version || !renewed.ExpiresAt.After(first.ExpiresAt) {
+		t.Errorf("Expected a new version with a later expiry after half of the TTL, got %v", renewed)
+	}
+	if first.ExpiresAt.Sub(issued.Add(time.Hour)) < DefaultPackageTTL/2 {
+		t.Errorf("Expected a current package to have at least half of its TTL left, expires at %s", first.ExpiresAt)
+	}
+	s.Revocations = append(s.Revocations, revokedHashes{"cc"})
+	if changed, _ := s.BuildPackage(time.Now()); changed.Version == pkg.Version {
+		t.Error("Expected a new version after a revocation")
+	}
+	if !MatchesVersion(`W/"other", "`+pkg.Version+`"`, pkg.Version) || MatchesVersion(`"other"`, pkg.Version) {
+		t.Error("Expected If-None-Match to match only the package version")
+	}
+}
+
+func TestExportPackageWithoutSigner(t *testing.T) {
+	s := NewService(nil)
+	var httpErr *customErrors.HttpError
+	if _, _, err := s.ExportPackage(context.Background()); !errors.As(err, &httpErr) || httpErr.StatusCode != 503 {
+		t.Errorf("Expected 503 without signing key, got %v", err)
+	}
+}
+
+func TestHCertKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	public := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	entries := []map[string]interface{}{
+		{"kid": "2350405fc1404cbb", "public_key": public},
+		{"kid": "I1BAX8FATLs=", "public_key": public},
+		{"kid": "2350405FC1404CBB", "not_before": "2999-01-01T00:00:00Z", "public_key": public},
+	}
+	data, _ := json.Marshal(entries)
+	path := filepath.Join(t.TempDir(), "trust-list.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write trust list: %v", err)
+	}
+	s := NewService(nil)
+	s.TrustListFile = path
+
+	keys := s.HCertKeys([]byte{0x23, 0x50, 0x40, 0x5f, 0xc1, 0x40, 0x4c, 0xbb})
+	if len(keys) != 2 {
+		t.Fatalf("Expected the keys of the hex and base64 kids that are already valid, got %d", len(keys))
+	}
+	if ecKey, ok := keys[0].(*ecdsa.PublicKey); !ok || !ecKey.Equal(&key.PublicKey) {
+		t.Errorf("Expected the trusted public key, got %T", keys[0])
+	}
+	if keys := s.HCertKeys([]byte{0x01}); len(keys) != 0 {
+		t.Errorf("Expected no key for an unknown kid, got %d", len(keys))
+	}
+	s.TrustListFile = filepath.Join(t.TempDir(), "missing.json")
+	if keys := s.HCertKeys([]byte{0x23}); keys != nil {
+		t.Errorf("Expected no key when the trust list cannot be read, got %d", len(keys))
+	}
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"ips-lacpass-backend/internal/verifier/core"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"net/http"
+)
+
+// PackageVersionHeader carries the version of the exported verifier package.
+const PackageVersionHeader = "X-Verifier-Package-Version"
+
+type Handler struct {
+	Service *core.VerifierService
+}
+
+func NewHandler(s *core.VerifierService) *Handler {
+	return &Handler{
+		Service: s,
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var httpErr *customErrors.HttpError
+	if errors.As(err, &httpErr) {
+		res, err := json.Marshal(httpErr.Body)
+		if err != nil {
+			http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(httpErr.StatusCode)
+		_, err = w.Write(res)
+		if err != nil {
+			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Package Export the signed verifier package as a compact JWS. A request whose If-None-Match names
+// the current version gets 304, so verifier apps only download a package when it changed.
+func (h *Handler) Package(w http.ResponseWriter, r *http.Request) {
+	signed, pkg, err := h.Service.ExportPackage(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+pkg.Version+`"`)
+	w.Header().Set(PackageVersionHeader, pkg.Version)
+	w.Header().Set("Expires", pkg.ExpiresAt.Format(http.TimeFormat))
+	if core.MatchesVersion(r.Header.Get("If-None-Match"), pkg.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jose")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(signed)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Keys List the public keys that sign verifier packages, as a JWK set
+func (h *Handler) Keys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.Service.PublicKeys(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := json.Marshal(keys)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	stdErrors "errors"
 	"fmt"
 	"io"
 	"ips-lacpass-backend/pkg/errors"
@@ -13,6 +14,9 @@ import (
 
 const qrPreviewLength = 12
 
+// ErrRevokeNotSupported is returned by RevokeQr when the VHL service cannot revoke links.
+var ErrRevokeNotSupported = stdErrors.New("VHL service does not support revocation")
+
 type VhlClient struct {
 	Client           *http.Client
 	BaseURL          string
@@ -43,6 +47,13 @@ func (c *VhlClient) CreateQr(ctx context.Context, body CreateQrRequest) (*QrData
 	}
 }
 
+// RevokeQr asks the VHL service to stop resolving a link it issued. Until the VHL service exposes revocation it
+// returns ErrRevokeNotSupported and VHLs are only revoked by this backend, as documented for the revoke endpoint.
+func (c *VhlClient) RevokeQr(ctx context.Context, qrData string) error {
+	// TODO: To be implemented by the participant when the VHL service exposes revocation
+	return ErrRevokeNotSupported
+}
+
 func (c *VhlClient) Validate(ctx context.Context, qrData string) (*QRValidationResponse, error) {
 	// TODO: To be implemented by the participant
 	return nil, &errors.HttpError{
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"ips-lacpass-backend/pkg/utils"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Defaults of the batch validation: how many payloads are validated at once and how many a batch may carry.
+const (
+	DefaultBatchWorkers  = 8
+	DefaultBatchMaxItems = 200
+)
+
+type BatchMode string
+
+const (
+	// BatchModeRemote decodes every payload with the ICVP validator service and checks their signature here.
+	BatchModeRemote BatchMode = "remote"
+	// BatchModeLocal decodes the payloads here and checks their signature with the trusted keys of the service.
+	BatchModeLocal BatchMode = "local"
+)
+
+type BatchItemStatus string
+
+const (
+	BatchItemValid   BatchItemStatus = "valid"
+	BatchItemInvalid BatchItemStatus = "invalid"
+	BatchItemError   BatchItemStatus = "error"
+	// BatchItemUnverified is a payload decoded whose signature could not be checked, no key being trusted.
+	BatchItemUnverified BatchItemStatus = "unverified"
+)
+
+// BatchValidationResult is the outcome of one payload of a batch. Invalid payloads are rejected by the
+// validation, errors are failures to validate them, such as the validator service being down.
+type BatchValidationResult struct {
+	Index      int                      `json:"index"`
+	Status     BatchItemStatus          `json:"status"`
+	StatusCode int                      `json:"status_code"`
+	Result     interface{}              `json:"result,omitempty"`
+	Errors     []map[string]interface{} `json:"errors,omitempty"`
+}
+
+// LocalValidation is the result of a payload decoded in local mode.
+type LocalValidation struct {
+	Payload     map[string]interface{} `json:"payload"`
+	Certificate utils.HCertSummary     `json:"certificate"`
+}
+
+// ValidateBatch validates many HC1 payloads with at most BatchWorkers at once, returning one result
+// per payload in the same order. A failing payload never fails the batch.
+func (vs *VhlService) ValidateBatch(ctx context.Context, payloads []string, mode BatchMode) ([]BatchValidationResult, error) {
+	maxItems := vs.BatchMaxItems
+	if maxItems <= 0 {
+		maxItems = DefaultBatchMaxItems
+	}
+	if len(payloads) == 0 || len(payloads) > maxItems {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusBadRequest,
+			Body:       []map[string]interface{}{{"error": "invalid_batch_size", "message": fmt.Sprintf("A batch must carry between 1 and %d payloads", maxItems)}},
+			Err:        fmt.Errorf("batch of %d payloads", len(payloads)),
+		}
+	}
+	if mode == "" {
+		mode = BatchModeRemote
+	}
+	if mode != BatchModeRemote && mode != BatchModeLocal {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusBadRequest,
+			Body:       []map[string]interface{}{{"error": "invalid_mode", "message": "Mode must be either remote or local"}},
+			Err:        fmt.Errorf("unknown batch mode %q", mode),
+		}
+	}
+
+	workers := vs.BatchWorkers
+	if workers <= 0 {
+		workers = DefaultBatchWorkers
+	}
+	workers = min(workers, len(payloads))
+
+	results := make([]BatchValidationResult, len(payloads))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = vs.validateBatchItem(ctx, i, payloads[i], mode)
+			}
+		}()
+	}
+	for i := range payloads {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results, nil
+}
+
+func (vs *VhlService) validateBatchItem(ctx context.Context, index int, payload string, mode BatchMode) BatchValidationResult {
+	if err := ctx.Err(); err != nil {
+		return batchItemError(index, &customErrors.HttpError{
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       []map[string]interface{}{{"error": "cancelled", "message": "The batch was cancelled before this payload was validated"}},
+			Err:        err,
+		})
+	}
+	if !strings.HasPrefix(payload, "HC1:") {
+		return batchItemError(index, &customErrors.HttpError{
+			StatusCode: http.StatusBadRequest,
+			Body:       []map[string]interface{}{{"error": "invalid_data", "message": "Payload must start with HC1:"}},
+			Err:        errors.New("payload is not an HC1 payload"),
+		})
+	}
+	if err := vs.checkNotRevoked(payload); err != nil {
+		return batchItemError(index, err)
+	}
+
+	if mode == BatchModeLocal {
+		return vs.validateBatchItemLocally(index, payload)
+	}
+	validation, err := vs.GetICVPValidation(ctx, payload)
+	if err != nil {
+		return batchItemError(index, err)
+	}
+	// The ICVP validator only decodes the payload, so its signature is checked here before the payload is valid.
+	if vs.Keys == nil {
+		return BatchValidationResult{Index: index, Status: BatchItemUnverified, StatusCode: http.StatusOK, Result: validation}
+	}
+	if _, err := utils.VerifyHCert(payload, vs.Keys); err != nil {
+		return batchItemError(index, hcertVerificationError(err))
+	}
+	return BatchValidationResult{Index: index, Status: BatchItemValid, StatusCode: http.StatusOK, Result: validation}
+}
+
+// validateBatchItemLocally decodes a payload and checks its signature with the trusted keys. Without trusted
+// keys the payload is only decoded and reported as unverified, never as valid.
+func (vs *VhlService) validateBatchItemLocally(index int, payload string) BatchValidationResult {
+	if vs.Keys == nil {
+		decoded, err := utils.DecodeHCert(payload)
+		if err != nil {
+			return batchItemError(index, invalidHCert(err))
+		}
+		return BatchValidationResult{Index: index, Status: BatchItemUnverified, StatusCode: http.StatusOK, Result: LocalValidation{Payload: decoded.Claims, Certificate: decoded.Summary()}}
+	}
+
+	decoded, err := utils.VerifyHCert(payload, vs.Keys)
+	if err != nil {
+		return batchItemError(index, hcertVerificationError(err))
+	}
+	return BatchValidationResult{Index: index, Status: BatchItemValid, StatusCode: http.StatusOK, Result: LocalValidation{Payload: decoded.Claims, Certificate: decoded.Summary()}}
+}
+
+// hcertVerificationError is the error of a payload whose signature does not verify with the trusted keys.
+func hcertVerificationError(err error) error {
+	switch {
+	case errors.Is(err, utils.ErrHCertUntrustedKey):
+		return &customErrors.HttpError{
+			StatusCode: http.StatusUnprocessableEntity,
+			Body:       []map[string]interface{}{{"error": "untrusted_credential", "message": "The certificate is not signed by a trusted key", "stage": utils.HCertStageSignature}},
+			Err:        err,
+		}
+	case errors.Is(err, utils.ErrHCertInvalidSignature):
+		return &customErrors.HttpError{
+			StatusCode: http.StatusUnprocessableEntity,
+			Body:       []map[string]interface{}{{"error": "invalid_signature", "message": "The certificate signature does not verify", "stage": utils.HCertStageSignature}},
+			Err:        err,
+		}
+	default:
+		return invalidHCert(err)
+	}
+}
+
+// invalidHCert is the error of a payload that cannot be decoded, with the failing decoding stage.
+func invalidHCert(err error) error {
+	body := map[string]interface{}{"error": "invalid_hcert", "message": err.Error()}
+	var hcertErr *utils.HCertError
+	if errors.As(err, &hcertErr) {
+		body["stage"] = hcertErr.Stage
+	}
+	return &customErrors.HttpError{
+		StatusCode: http.StatusUnprocessableEntity,
+		Body:       []map[string]interface{}{body},
+		Err:        err,
+	}
+}
+
+// batchItemError turns the error of a payload into its result: 4xx make it invalid, anything else is an error.
+func batchItemError(index int, err error) BatchValidationResult {
+	result := BatchValidationResult{Index: index, Status: BatchItemError, StatusCode: http.StatusBadGateway}
+	var httpErr *customErrors.HttpError
+	if errors.As(err, &httpErr) {
+		result.StatusCode = httpErr.StatusCode
+		result.Errors = httpErr.Body
+	} else {
+		result.Errors = []map[string]interface{}{{"error": "internal_error", "message": err.Error()}}
+	}
+	if result.StatusCode >= 400 && result.StatusCode < 500 {
+		result.Status = BatchItemInvalid
+	} else {
+		slog.Warn("Failed to validate batch payload", "index", index, "error", err)
+	}
+	return result
+}
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	revocationCore "ips-lacpass-backend/internal/revocation/core"
+	"ips-lacpass-backend/internal/vhl/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"ips-lacpass-backend/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/veraison/go-cose"
+)
+
+// signedHCert encodes an HC1 issued by XCL, signed by the key with the kid 2350.
+func signedHCert(t *testing.T, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	signer, err := cose.NewSigner(cose.AlgorithmES256, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	msg := cose.NewSign1Message()
+	msg.Headers.Protected.SetAlgorithm(cose.AlgorithmES256)
+	msg.Headers.Protected[cose.HeaderLabelKeyID] = []byte{0x23, 0x50}
+	msg.Payload, _ = cbor.Marshal(map[int]interface{}{1: "XCL", -260: map[int]string{-6: "ICVP"}})
+	if err := msg.Sign(rand.Reader, nil, signer); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := msg.MarshalCBOR()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return "HC1:" + utils.EncodeBase45(compressed.Bytes())
+}
+
+// revokedService returns a service whose revocation list holds the given certificates.
+func revokedService(t *testing.T, vhlClient *client.VhlClient, hcerts ...string) VhlService {
+	t.Helper()
+	store, _ := revocationCore.NewStore("")
+	revocations := revocationCore.NewService(store)
+	for _, hcert := range hcerts {
+		if _, err := revocations.Revoke(context.Background(), hcert, "test"); err != nil {
+			t.Fatalf("Unexpected error revoking: %v", err)
+		}
+	}
+	service := NewService(vhlClient)
+	service.Revocations = &revocations
+	return service
+}
+
+func TestValidateBatch(t *testing.T) {
+	var running, peak atomic.Int32
+	validator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			previous := peak.Load()
+			if current <= previous || peak.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		var body client.ICVPQrValidationRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.QRData == "HC1:BROKEN" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte("invalid signature"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"cose":{"kid_b64":"a2lk"}}`))
+	}))
+	defer validator.Close()
+
+	vhlClient := client.NewClient("", validator.URL)
+	service := NewService(&vhlClient)
+	service.BatchWorkers = 2
+	service.recordIssuance(userContext("user-1"), "HC1:REVOKED", "", false)
+	issued := service.Issuances.ListByUser("user-1")[0]
+	if _, err := service.RevokeIssued(userContext("user-1"), issued.ID, ""); err != nil {
+		t.Fatalf("Unexpected error revoking: %v", err)
+	}
+
+	payloads := []string{"HC1:A", "HC1:BROKEN", "shlink:/abc", "HC1:B", "HC1:REVOKED", "HC1:C"}
+	results, err := service.ValidateBatch(context.Background(), payloads, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []struct {
+		status BatchItemStatus
+		code   int
+	}{
+		{BatchItemUnverified, 200}, {BatchItemInvalid, 422}, {BatchItemInvalid, 400}, {BatchItemUnverified, 200}, {BatchItemInvalid, 410}, {BatchItemUnverified, 200},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Expected %d results, got %d", len(want), len(results))
+	}
+	for i, w := range want {
+		if results[i].Index != i || results[i].Status != w.status || results[i].StatusCode != w.code {
+			t.Errorf("Expected result %d to be %s with %d, got %+v", i, w.status, w.code, results[i])
+		}
+	}
+	if peak.Load() > 2 {
+		t.Errorf("Expected at most 2 concurrent validations, got %d", peak.Load())
+	}
+}
+
+func TestValidateBatchLocal(t *testing.T) {
+	vhlClient := client.NewClient("", "http://127.0.0.1:0")
+	service := NewService(&vhlClient)
+	results, err := service.ValidateBatch(context.Background(), []string{"HC1:NOT-BASE45~"}, BatchModeLocal)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results[0].Status != BatchItemInvalid || results[0].Errors[0]["error"] != "invalid_hcert" {
+		t.Errorf("Expected the payload to be decoded locally and rejected, got %+v", results[0])
+	}
+}
+
+func TestValidateBatchSize(t *testing.T) {
+	vhlClient := client.NewClient("", "")
+	service := NewService(&vhlClient)
+	service.BatchMaxItems = 2
+
+	var httpErr *customErrors.HttpError
+	for _, payloads := range [][]string{nil, {"HC1:A", "HC1:B", "HC1:C"}} {
+		if _, err := service.ValidateBatch(context.Background(), payloads, BatchModeLocal); !errors.As(err, &httpErr) || httpErr.StatusCode != 400 {
+			t.Errorf("Expected 400 for a batch of %d payloads, got %v", len(payloads), err)
+		}
+	}
+	if _, err := service.ValidateBatch(context.Background(), []string{"HC1:A"}, "offline"); !errors.As(err, &httpErr) || httpErr.StatusCode != 400 {
+		t.Errorf("Expected 400 for an unknown mode, got %v", err)
+	}
+}
+
+func TestRevokedCertificateNotIssuedHere(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	revoked := signedHCert(t, key)
+	vhlClient := client.NewClient("http://127.0.0.1:0", "http://127.0.0.1:0")
+	service := revokedService(t, &vhlClient, revoked)
+
+	var httpErr *customErrors.HttpError
+	if _, err := service.GetQrIps(userContext("user-1"), revoked, "", false); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusGone || httpErr.Body[0]["error"] != "hcert_revoked" {
+		t.Errorf("Expected 410 fetching a revoked certificate not issued here, got %v", err)
+	}
+	results, err := service.ValidateBatch(context.Background(), []string{revoked}, BatchModeLocal)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results[0].Status != BatchItemInvalid || results[0].StatusCode != http.StatusGone {
+		t.Errorf("Expected a revoked certificate to be invalid, got %+v", results[0])
+	}
+}
+
+func TestValidateBatchLocalSignature(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signed, forged := signedHCert(t, key), signedHCert(t, other)
+	vhlClient := client.NewClient("", "http://127.0.0.1:0")
+	service := NewService(&vhlClient)
+
+	results, err := service.ValidateBatch(context.Background(), []string{signed}, BatchModeLocal)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results[0].Status != BatchItemUnverified {
+		t.Errorf("Expected a payload to be unverified without trusted keys, got %+v", results[0])
+	}
+
+	service.Keys = func(kid []byte) []crypto.PublicKey { return []crypto.PublicKey{key.Public()} }
+	results, err = service.ValidateBatch(context.Background(), []string{signed, forged}, BatchModeLocal)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results[0].Status != BatchItemValid {
+		t.Errorf("Expected the signed payload to be valid, got %+v", results[0])
+	}
+	if results[1].Status != BatchItemInvalid || results[1].Errors[0]["error"] != "invalid_signature" {
+		t.Errorf("Expected the forged payload to be invalid, got %+v", results[1])
+	}
+}
+
+func TestValidateBatchRemoteRevoked(t *testing.T) {
+	validator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"cose":{"kid_b64":"a2lk"}}`))
+	}))
+	defer validator.Close()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	revoked := signedHCert(t, key)
+	vhlClient := client.NewClient("", validator.URL)
+	service := revokedService(t, &vhlClient, revoked)
+	service.Keys = func(kid []byte) []crypto.PublicKey { return []crypto.PublicKey{key.Public()} }
+
+	results, err := service.ValidateBatch(context.Background(), []string{revoked, signedHCert(t, key), signedHCert(t, other)}, BatchModeRemote)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results[0].Status != BatchItemInvalid || results[0].StatusCode != http.StatusGone {
+		t.Errorf("Expected the revoked payload to be invalid, got %+v", results[0])
+	}
+	if results[1].Status != BatchItemValid {
+		t.Errorf("Expected the other payload to be valid, got %+v", results[1])
+	}
+	if results[2].Status != BatchItemInvalid || results[2].Errors[0]["error"] != "invalid_signature" {
+		t.Errorf("Expected a forged payload decoded by the validator to be invalid, got %+v", results[2])
+	}
+}
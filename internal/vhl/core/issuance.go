@@ -0,0 +1,205 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+type VhlStatus string
+
+const (
+	VhlStatusActive  VhlStatus = "active"
+	VhlStatusExpired VhlStatus = "expired"
+	VhlStatusRevoked VhlStatus = "revoked"
+)
+
+// IssuedVhl is a VHL created through POST /qr, kept so its owner can list and revoke it.
+type IssuedVhl struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Node        string     `json:"node"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresOn   string     `json:"expires_on,omitempty"`
+	HasPassCode bool       `json:"has_pass_code"`
+	Status      VhlStatus  `json:"status"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	// UpstreamRevoked reports whether the VHL service also revoked the link.
+	UpstreamRevoked bool `json:"upstream_revoked"`
+	// PayloadHash is the SHA-256 of the HC1 payload, used to refuse revoked links on fetch.
+	PayloadHash string `json:"payload_hash"`
+}
+
+// expired reports whether ExpiresOn, an RFC 3339 date-time or a date, is in the past.
+func (v IssuedVhl) expired(now time.Time) bool {
+	if v.ExpiresOn == "" {
+		return false
+	}
+	if t, err := time.Parse(time.RFC3339, v.ExpiresOn); err == nil {
+		return now.After(t)
+	}
+	if t, err := time.Parse(time.DateOnly, v.ExpiresOn); err == nil {
+		return !now.Before(t.AddDate(0, 0, 1))
+	}
+	return false
+}
+
+// withStatus returns the VHL with its status at the given time.
+func (v IssuedVhl) withStatus(now time.Time) IssuedVhl {
+	switch {
+	case v.RevokedAt != nil:
+		v.Status = VhlStatusRevoked
+	case v.expired(now):
+		v.Status = VhlStatusExpired
+	default:
+		v.Status = VhlStatusActive
+	}
+	return v
+}
+
+func payloadHash(payload string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(payload)))
+}
+
+// IssuanceStore keeps the issued VHLs. With a file path they are persisted as JSON and loaded
+// back on start, otherwise they only live in memory.
+type IssuanceStore struct {
+	mu   sync.RWMutex
+	path string
+	vhls map[string]IssuedVhl
+}
+
+// NewIssuanceStore loads the store from path. An empty path gives an in-memory store.
+func NewIssuanceStore(path string) (*IssuanceStore, error) {
+	s := &IssuanceStore{path: path, vhls: make(map[string]IssuedVhl)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading VHL store %s: %w", path, err)
+	}
+	var vhls []IssuedVhl
+	if err := json.Unmarshal(data, &vhls); err != nil {
+		return nil, fmt.Errorf("error parsing VHL store %s: %w", path, err)
+	}
+	for _, v := range vhls {
+		s.vhls[v.ID] = v
+	}
+	return s, nil
+}
+
+// Save adds or replaces a VHL.
+func (s *IssuanceStore) Save(vhl IssuedVhl) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.vhls[vhl.ID]
+	s.vhls[vhl.ID] = vhl
+	if err := s.persist(); err != nil {
+		if existed {
+			s.vhls[vhl.ID] = previous
+		} else {
+			delete(s.vhls, vhl.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Get returns a VHL by id.
+func (s *IssuanceStore) Get(id string) (IssuedVhl, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.vhls[id]
+	return v, ok
+}
+
+// FindByPayload returns the VHL issued with an HC1 payload.
+func (s *IssuanceStore) FindByPayload(payload string) (IssuedVhl, bool) {
+	hash := payloadHash(payload)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.vhls {
+		if v.PayloadHash == hash {
+			return v, true
+		}
+	}
+	return IssuedVhl{}, false
+}
+
+// ListByUser returns the VHLs of a user, newest first.
+func (s *IssuanceStore) ListByUser(userID string) []IssuedVhl {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	vhls := []IssuedVhl{}
+	for _, v := range s.vhls {
+		if v.UserID == userID {
+			vhls = append(vhls, v)
+		}
+	}
+	sort.Slice(vhls, func(i, j int) bool {
+		if vhls[i].CreatedAt.Equal(vhls[j].CreatedAt) {
+			return vhls[i].ID < vhls[j].ID
+		}
+		return vhls[i].CreatedAt.After(vhls[j].CreatedAt)
+	})
+	return vhls
+}
+
+// RevokedPayloadHashes returns the payload hashes of the revoked VHLs, sorted.
+func (s *IssuanceStore) RevokedPayloadHashes() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hashes := []string{}
+	for _, v := range s.vhls {
+		if v.RevokedAt != nil {
+			hashes = append(hashes, v.PayloadHash)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// persist writes the whole store to a temporary file renamed over the previous one,
+// so a crash never leaves a truncated store.
+func (s *IssuanceStore) persist() error {
+	if s.path == "" {
+		return nil
+	}
+	vhls := make([]IssuedVhl, 0, len(s.vhls))
+	for _, v := range s.vhls {
+		vhls = append(vhls, v)
+	}
+	slices.SortFunc(vhls, func(a, b IssuedVhl) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	data, err := json.MarshalIndent(vhls, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding VHL store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing VHL store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing VHL store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing VHL store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("error writing VHL store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"ips-lacpass-backend/internal/vhl/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func userContext(userID string) context.Context {
+	return context.WithValue(context.Background(), authMiddleware.UserUUIDKey, userID)
+}
+
+func TestIssuedVhlStatus(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	revokedAt := now.Add(-time.Hour)
+	tests := []struct {
+		name string
+		vhl  IssuedVhl
+		want VhlStatus
+	}{
+		{name: "No expiration", vhl: IssuedVhl{}, want: VhlStatusActive},
+		{name: "Expires later today", vhl: IssuedVhl{ExpiresOn: "2026-03-10"}, want: VhlStatusActive},
+		{name: "Expired date", vhl: IssuedVhl{ExpiresOn: "2026-03-09"}, want: VhlStatusExpired},
+		{name: "Expired date-time", vhl: IssuedVhl{ExpiresOn: "2026-03-10T11:00:00Z"}, want: VhlStatusExpired},
+		{name: "Revoked before expiring", vhl: IssuedVhl{ExpiresOn: "2026-03-09", RevokedAt: &revokedAt}, want: VhlStatusRevoked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.vhl.withStatus(now).Status; got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIssuanceStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vhl.json")
+	store, err := NewIssuanceStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	older := IssuedVhl{ID: "a", UserID: "user-1", CreatedAt: time.Now().Add(-time.Minute), PayloadHash: payloadHash("HC1:A")}
+	newer := IssuedVhl{ID: "b", UserID: "user-1", CreatedAt: time.Now(), PayloadHash: payloadHash("HC1:B")}
+	other := IssuedVhl{ID: "c", UserID: "user-2", CreatedAt: time.Now()}
+	for _, v := range []IssuedVhl{older, newer, other} {
+		if err := store.Save(v); err != nil {
+			t.Fatalf("Unexpected error saving %s: %v", v.ID, err)
+		}
+	}
+
+	reloaded, err := NewIssuanceStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error reloading: %v", err)
+	}
+	vhls := reloaded.ListByUser("user-1")
+	if len(vhls) != 2 || vhls[0].ID != "b" || vhls[1].ID != "a" {
+		t.Errorf("Expected the two VHLs of user-1 newest first, got %+v", vhls)
+	}
+	if v, ok := reloaded.FindByPayload("HC1:A"); !ok || v.ID != "a" {
+		t.Errorf("Expected to find VHL a by payload, got %+v", v)
+	}
+}
+
+func TestRevokeIssued(t *testing.T) {
+	vhlClient := client.NewClient("", "")
+	service := NewService(&vhlClient)
+	service.recordIssuance(userContext("user-1"), "HC1:PAYLOAD", "", true)
+	vhls, err := service.ListIssued(userContext("user-1"))
+	if err != nil || len(vhls) != 1 || vhls[0].Status != VhlStatusActive || !vhls[0].HasPassCode || vhls[0].Node != authMiddleware.DefaultNodeName {
+		t.Fatalf("Expected one active VHL, got %+v (%v)", vhls, err)
+	}
+	id := vhls[0].ID
+
+	var httpErr *customErrors.HttpError
+	if _, err := service.RevokeIssued(userContext("user-2"), id, ""); !errors.As(err, &httpErr) || httpErr.StatusCode != 404 {
+		t.Errorf("Expected 404 revoking the VHL of another user, got %v", err)
+	}
+
+	revoked, err := service.RevokeIssued(userContext("user-1"), id, "HC1:PAYLOAD")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if revoked.Status != VhlStatusRevoked || revoked.RevokedAt == nil || revoked.UpstreamRevoked {
+		t.Errorf("Expected a VHL revoked only locally, got %+v", revoked)
+	}
+
+	_, err = service.GetQrIps(userContext("user-3"), "HC1:PAYLOAD", "", false)
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 410 {
+		t.Errorf("Expected 410 fetching a revoked VHL, got %v", err)
+	}
+}
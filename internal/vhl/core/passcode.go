@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// PassCodePolicy sets the strength a VHL passcode must have. The passcode itself stays optional.
+type PassCodePolicy struct {
+	MinLength int
+	// MinClasses is how many of lowercase letters, uppercase letters, digits and symbols it must mix.
+	MinClasses int
+}
+
+// DefaultPassCodePolicy asks for six characters mixing at least two classes, such as letters and digits.
+var DefaultPassCodePolicy = PassCodePolicy{MinLength: 6, MinClasses: 2}
+
+// passCodeClasses counts the character classes used by a passcode.
+func passCodeClasses(passCode string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range passCode {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, used := range []bool{lower, upper, digit, symbol} {
+		if used {
+			classes++
+		}
+	}
+	return classes
+}
+
+// Check returns a 400 listing the rules a non-empty passcode breaks.
+func (p PassCodePolicy) Check(passCode string) error {
+	if passCode == "" {
+		return nil
+	}
+	var problems []string
+	if length := len([]rune(passCode)); length < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if passCodeClasses(passCode) < p.MinClasses {
+		problems = append(problems, fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	message := "Pass code " + strings.Join(problems, " and ")
+	return &customErrors.HttpError{
+		StatusCode: http.StatusBadRequest,
+		Body: []map[string]interface{}{{
+			"error":       "weak_pass_code",
+			"message":     message,
+			"min_length":  p.MinLength,
+			"min_classes": p.MinClasses,
+		}},
+		Err: fmt.Errorf("weak pass code: %s", message),
+	}
+}
@@ -5,20 +5,61 @@ import (
 	"errors"
 	"fmt"
 	ipsClient "ips-lacpass-backend/internal/ips/client"
+	ipsCore "ips-lacpass-backend/internal/ips/core"
+	revocationCore "ips-lacpass-backend/internal/revocation/core"
 	"ips-lacpass-backend/internal/vhl/client"
 	customErrors "ips-lacpass-backend/pkg/errors"
 	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"ips-lacpass-backend/pkg/utils"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type VhlService struct {
 	DefaultClient *client.VhlClient
 	Clients       map[string]*client.VhlClient
+	// Issuances keeps the VHLs created by users. NewService starts with an in-memory store.
+	Issuances *IssuanceStore
+	// PassCodePolicy is checked on the passcode of new VHLs.
+	PassCodePolicy PassCodePolicy
+	// Throttle locks out VHLs and callers after repeated wrong passcodes on fetch.
+	Throttle *FetchThrottle
+	// BatchWorkers bounds how many payloads of a batch are validated at once.
+	BatchWorkers int
+	// BatchMaxItems is the largest batch accepted by ValidateBatch.
+	BatchMaxItems int
+	// Revocations flags revoked HC1 certificates. Nil disables the check.
+	Revocations *revocationCore.RevocationService
+	// Keys resolves the trusted keys checking the signature of payloads validated locally. Without it local
+	// validation only decodes them.
+	Keys utils.HCertKeyResolver
+}
+
+// ICVPValidation is the response of the ICVP validator with the revocation status of the certificate.
+type ICVPValidation struct {
+	*client.ICVPQRValidationResponse
+	Revocation *revocationCore.Status `json:"revocation"`
 }
 
+// Default lockout of VHL fetches: five wrong passcodes within 15 minutes lock out for 15 minutes.
+const (
+	DefaultFetchMaxAttempts = 5
+	DefaultFetchLockout     = 15 * time.Minute
+)
+
 func NewService(r *client.VhlClient) VhlService {
+	issuances, _ := NewIssuanceStore("")
 	return VhlService{
-		DefaultClient: r,
-		Clients:       make(map[string]*client.VhlClient),
+		DefaultClient:  r,
+		Clients:        make(map[string]*client.VhlClient),
+		Issuances:      issuances,
+		PassCodePolicy: DefaultPassCodePolicy,
+		Throttle:       NewFetchThrottle(DefaultFetchMaxAttempts, DefaultFetchLockout),
+		BatchWorkers:   DefaultBatchWorkers,
+		BatchMaxItems:  DefaultBatchMaxItems,
 	}
 }
 
@@ -41,21 +82,187 @@ func (vs *VhlService) CreateQrCode(ctx context.Context, expiresOn *string, conte
 		}
 	}
 
+	request := client.CreateQrRequest{JsonContent: *content}
+	if expiresOn != nil {
+		request.ExpiresOn = *expiresOn
+	}
+	if passCode != nil {
+		request.PassCode = *passCode
+	}
+	if err := vs.PassCodePolicy.Check(request.PassCode); err != nil {
+		return nil, err
+	}
+
 	c := vs.getClient(ctx)
-	qrData, err := c.CreateQr(ctx, client.CreateQrRequest{
-		JsonContent: *content,
-		ExpiresOn:   *expiresOn,
-		PassCode:    *passCode,
-	})
+	qrData, err := c.CreateQr(ctx, request)
 	if err != nil {
 		return nil, err
 	}
+	if qrData == nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: 502,
+			Body:       []map[string]interface{}{{"error": "invalid_vhl_response", "message": "VHL service did not return QR data"}},
+			Err:        errors.New("VHL service returned no QR data"),
+		}
+	}
+	vs.recordIssuance(ctx, qrData.Value, request.ExpiresOn, request.PassCode != "")
 	return qrData, nil
 }
 
-func (vs *VhlService) GetQrIps(ctx context.Context, qrData string, passCode string) (map[string]any, error) {
+// recordIssuance stores a created VHL for its owner. The link was already issued, so a
+// failure to store it is logged and does not fail the request.
+func (vs *VhlService) recordIssuance(ctx context.Context, payload string, expiresOn string, hasPassCode bool) {
+	userID, err := authMiddleware.GetUserUUIDFromContext(ctx)
+	if err != nil {
+		slog.Warn("VHL created without user in context, it will not be listed", "error", err)
+		return
+	}
+	node := authMiddleware.GetNodeNameFromContext(ctx)
+	if _, ok := vs.Clients[node]; !ok {
+		node = authMiddleware.DefaultNodeName
+	}
+
+	issued := IssuedVhl{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Node:        node,
+		CreatedAt:   time.Now().UTC(),
+		ExpiresOn:   expiresOn,
+		HasPassCode: hasPassCode,
+		Status:      VhlStatusActive,
+		PayloadHash: payloadHash(payload),
+	}
+	if err := vs.Issuances.Save(issued); err != nil {
+		slog.Error("Failed to store issued VHL", "userId", userID, "error", err)
+	}
+}
+
+// ListIssued returns the VHLs created by the user, newest first.
+func (vs *VhlService) ListIssued(ctx context.Context) ([]IssuedVhl, error) {
+	userID, err := authMiddleware.GetUserUUIDFromContext(ctx)
+	if err != nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusUnauthorized,
+			Body:       []map[string]interface{}{{"error": "user_identifier_not_found", "message": "User identifier not found in request context"}},
+			Err:        err,
+		}
+	}
+
+	now := time.Now()
+	vhls := vs.Issuances.ListByUser(userID)
+	for i := range vhls {
+		vhls[i] = vhls[i].withStatus(now)
+	}
+	return vhls, nil
+}
+
+// RevokeIssued revokes a VHL of the user. It is then refused by GetQrIps, and the revocation
+// is pushed to the VHL service of its node when the service supports it. Revoking twice is a no-op.
+func (vs *VhlService) RevokeIssued(ctx context.Context, id string, payload string) (*IssuedVhl, error) {
+	userID, err := authMiddleware.GetUserUUIDFromContext(ctx)
+	if err != nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusUnauthorized,
+			Body:       []map[string]interface{}{{"error": "user_identifier_not_found", "message": "User identifier not found in request context"}},
+			Err:        err,
+		}
+	}
+	issued, ok := vs.Issuances.Get(id)
+	if !ok || issued.UserID != userID {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusNotFound,
+			Body:       []map[string]interface{}{{"error": "not_found", "message": "VHL not found"}},
+			Err:        fmt.Errorf("VHL %s not found for the user", id),
+		}
+	}
+	if issued.RevokedAt != nil {
+		issued = issued.withStatus(time.Now())
+		return &issued, nil
+	}
+
+	revokedAt := time.Now().UTC()
+	issued.RevokedAt = &revokedAt
+	if payload != "" && payloadHash(payload) == issued.PayloadHash {
+		c := vs.DefaultClient
+		if nodeClient, ok := vs.Clients[issued.Node]; ok {
+			c = nodeClient
+		}
+		err := c.RevokeQr(ctx, payload)
+		switch {
+		case err == nil:
+			issued.UpstreamRevoked = true
+		case errors.Is(err, client.ErrRevokeNotSupported):
+			slog.Info("VHL service does not support revocation, VHL revoked locally", "id", id, "node", issued.Node)
+		default:
+			slog.Error("Failed to revoke VHL in the VHL service, VHL revoked locally", "id", id, "node", issued.Node, "error", err)
+		}
+	}
+
+	if err := vs.Issuances.Save(issued); err != nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusInternalServerError,
+			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to store the revocation"}},
+			Err:        err,
+		}
+	}
+	issued = issued.withStatus(time.Now())
+	return &issued, nil
+}
+
+// checkNotRevoked refuses VHLs issued here that were revoked or have expired, and revoked certificates
+// whether or not they were issued here.
+func (vs *VhlService) checkNotRevoked(qrData string) error {
+	issued, ok := vs.Issuances.FindByPayload(qrData)
+	if !ok {
+		return vs.checkCertificateNotRevoked(qrData)
+	}
+	switch issued.withStatus(time.Now()).Status {
+	case VhlStatusRevoked:
+		return &customErrors.HttpError{
+			StatusCode: http.StatusGone,
+			Body:       []map[string]interface{}{{"error": "vhl_revoked", "message": "This VHL was revoked by its owner"}},
+			Err:        fmt.Errorf("VHL %s is revoked", issued.ID),
+		}
+	case VhlStatusExpired:
+		return &customErrors.HttpError{
+			StatusCode: http.StatusGone,
+			Body:       []map[string]interface{}{{"error": "vhl_expired", "message": "This VHL has expired"}},
+			Err:        fmt.Errorf("VHL %s is expired", issued.ID),
+		}
+	}
+	return vs.checkCertificateNotRevoked(qrData)
+}
+
+// checkCertificateNotRevoked refuses HC1 certificates of the revocation list.
+func (vs *VhlService) checkCertificateNotRevoked(qrData string) error {
+	status := vs.Revocations.Check(qrData)
+	if !status.Revoked {
+		return nil
+	}
+	return &customErrors.HttpError{
+		StatusCode: http.StatusGone,
+		Body:       []map[string]interface{}{{"error": "hcert_revoked", "message": "This certificate was revoked", "hash_type": status.HashType}},
+		Err:        fmt.Errorf("certificate revoked by its %s hash %s", status.HashType, status.Hash),
+	}
+}
+
+// GetQrIps fetches the IPS bundle of a VHL. With validate, a bundle that is not a valid IPS, as checked by
+// POST /ips/validate, is refused with the issues found.
+func (vs *VhlService) GetQrIps(ctx context.Context, qrData string, passCode string, validate bool) (map[string]any, error) {
 	c := vs.getClient(ctx)
 	fmt.Printf("[DEBUG] GetQrIps called with qrData length: %d\n", len(qrData))
+	if err := vs.checkNotRevoked(qrData); err != nil {
+		return nil, err
+	}
+	throttleKeys := []string{vhlThrottleKey(qrData)}
+	if userID, err := authMiddleware.GetUserUUIDFromContext(ctx); err == nil {
+		throttleKeys = append(throttleKeys, callerThrottleKey(userID))
+	}
+	if err := vs.Throttle.Check(throttleKeys...); err != nil {
+		slog.Warn("VHL fetch locked out after failed pass code attempts")
+		return nil, err
+	}
+	defer vs.Throttle.Release(throttleKeys...)
 	validation, err := c.Validate(ctx, qrData)
 	if err != nil {
 		fmt.Printf("[ERROR] vs.Client.Validate failed: %v\n", err)
@@ -84,8 +291,16 @@ func (vs *VhlService) GetQrIps(ctx context.Context, qrData string, passCode stri
 	ipsFetchUrl, err := c.GetIpsUrl(ctx, validation.ShLinkContent.Url, passCode)
 	if err != nil {
 		fmt.Printf("[ERROR] vs.Client.GetIpsUrl failed: %v\n", err) // <--- Check this!
+		if isPassCodeFailure(err) {
+			remaining := vs.Throttle.Fail(throttleKeys...)
+			if remaining == 0 {
+				return nil, lockedOutError(vs.Throttle.Lockout)
+			}
+			return nil, withRemainingAttempts(err, remaining)
+		}
 		return nil, err
 	}
+	vs.Throttle.Reset(throttleKeys[0])
 
 	if len(ipsFetchUrl.Files) == 0 || len(ipsFetchUrl.Files[0].Location) == 0 {
 		fmt.Println("[WARN] Manifest server returned invalid bundle url")
@@ -97,19 +312,38 @@ func (vs *VhlService) GetQrIps(ctx context.Context, qrData string, passCode stri
 	}
 	ipsClt := ipsClient.NewClient("", "")
 	fmt.Printf("[DEBUG] Fetching IPS Bundle from: %s\n", ipsFetchUrl.Files[0].Location)
-	ipsBundle, err := ipsClt.GetIpsBundle(ipsFetchUrl.Files[0].Location)
+	ipsBundle, err := ipsClt.GetIpsBundle(ctx, ipsFetchUrl.Files[0].Location)
 	if err != nil {
 		fmt.Printf("[ERROR] ipsClt.GetIpsBundle failed: %v\n", err)
 		return nil, err
 	}
+	if validate {
+		if err := checkIps(ipsBundle); err != nil {
+			return nil, err
+		}
+	}
 	return ipsBundle, nil
 }
 
-func (vs *VhlService) GetICVPValidation(ctx context.Context, qrData string) (*client.ICVPQRValidationResponse, error) {
+// checkIps refuses a fetched bundle that is not a valid IPS, with the issues found.
+func checkIps(bundle map[string]any) error {
+	outcome := ipsCore.ValidateIPS(bundle)
+	if !outcome.HasErrors() {
+		return nil
+	}
+	return &customErrors.HttpError{
+		StatusCode: http.StatusUnprocessableEntity,
+		Body:       []map[string]interface{}{{"error": "invalid_ips", "message": "The IPS of the VHL is not valid", "outcome": outcome}},
+		Err:        errors.New("IPS of the VHL is not valid"),
+	}
+}
+
+// GetICVPValidation validates an ICVP with the validator service and reports whether the certificate is revoked.
+func (vs *VhlService) GetICVPValidation(ctx context.Context, qrData string) (*ICVPValidation, error) {
 	c := vs.getClient(ctx)
 	validationData, err := c.ICVPValidate(ctx, qrData)
 	if err != nil {
 		return nil, err
 	}
-	return validationData, nil
+	return &ICVPValidation{ICVPQRValidationResponse: validationData, Revocation: vs.Revocations.Check(qrData)}, nil
 }
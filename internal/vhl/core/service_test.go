@@ -0,0 +1,21 @@
+package core
+
+import (
+	"errors"
+	ipsCore "ips-lacpass-backend/internal/ips/core"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"net/http"
+	"testing"
+)
+
+func TestCheckIps(t *testing.T) {
+	err := checkIps(map[string]any{"resourceType": "Bundle", "type": "collection"})
+	var httpErr *customErrors.HttpError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnprocessableEntity || httpErr.Body[0]["error"] != "invalid_ips" {
+		t.Fatalf("Expected 422 invalid_ips, got %v", err)
+	}
+	outcome, ok := httpErr.Body[0]["outcome"].(ipsCore.OperationOutcome)
+	if !ok || !outcome.HasErrors() {
+		t.Errorf("Expected the issues found in the error body, got %+v", httpErr.Body[0]["outcome"])
+	}
+}
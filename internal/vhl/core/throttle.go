@@ -0,0 +1,204 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FetchThrottle counts failed passcode attempts on VHL fetches. A key, a VHL or a caller, is locked
+// out for Lockout once it reaches MaxAttempts failures within Lockout of its first failure. Attempts
+// in flight are reserved by Check, so that concurrent fetches cannot exceed MaxAttempts.
+type FetchThrottle struct {
+	MaxAttempts int
+	Lockout     time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]*fetchAttempts
+	now      func() time.Time
+}
+
+type fetchAttempts struct {
+	failures    int
+	pending     int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// NewFetchThrottle creates a throttle locking keys out after maxAttempts failures.
+func NewFetchThrottle(maxAttempts int, lockout time.Duration) *FetchThrottle {
+	return &FetchThrottle{
+		MaxAttempts: maxAttempts,
+		Lockout:     lockout,
+		attempts:    make(map[string]*fetchAttempts),
+		now:         time.Now,
+	}
+}
+
+func vhlThrottleKey(qrData string) string {
+	return "vhl:" + payloadHash(qrData)
+}
+
+func callerThrottleKey(caller string) string {
+	return "caller:" + caller
+}
+
+// current returns the attempts of a key, dropping them once their window is over. Callers hold mu.
+func (t *FetchThrottle) current(key string, now time.Time) *fetchAttempts {
+	a, ok := t.attempts[key]
+	if !ok {
+		return nil
+	}
+	if now.Before(a.lockedUntil) || (a.lockedUntil.IsZero() && now.Sub(a.first) < t.Lockout) {
+		return a
+	}
+	if a.pending > 0 {
+		// keep the reservations of the attempts in flight
+		*a = fetchAttempts{pending: a.pending, first: now}
+		return a
+	}
+	delete(t.attempts, key)
+	return nil
+}
+
+// sweep drops the attempts of every key whose window is over. Callers hold mu.
+func (t *FetchThrottle) sweep(now time.Time) {
+	for key := range t.attempts {
+		t.current(key, now)
+	}
+}
+
+// Check returns a 429 when one of the keys is locked out, or when the attempts in flight would use up its
+// remaining attempts. Otherwise it reserves an attempt on every key, which the caller gives back with Release
+// once the attempt is over, after recording it with Fail when the passcode was refused.
+func (t *FetchThrottle) Check(keys ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now)
+	var lockedUntil time.Time
+	exhausted := false
+	for _, key := range keys {
+		a := t.current(key, now)
+		if a == nil {
+			continue
+		}
+		if a.lockedUntil.After(lockedUntil) {
+			lockedUntil = a.lockedUntil
+		}
+		if a.failures+a.pending >= t.MaxAttempts {
+			exhausted = true
+		}
+	}
+	if !lockedUntil.IsZero() {
+		return lockedOutError(lockedUntil.Sub(now))
+	}
+	if exhausted {
+		return lockedOutError(time.Second)
+	}
+
+	for _, key := range keys {
+		a := t.current(key, now)
+		if a == nil {
+			a = &fetchAttempts{first: now}
+			t.attempts[key] = a
+		}
+		a.pending++
+	}
+	return nil
+}
+
+// Release gives back the attempts reserved by Check, forgetting the keys left without failures.
+func (t *FetchThrottle) Release(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		a, ok := t.attempts[key]
+		if !ok {
+			continue
+		}
+		if a.pending > 0 {
+			a.pending--
+		}
+		if a.pending == 0 && a.failures == 0 && a.lockedUntil.IsZero() {
+			delete(t.attempts, key)
+		}
+	}
+}
+
+// Fail records a failed attempt on every key and returns the attempts left before the first of them is locked out.
+func (t *FetchThrottle) Fail(keys ...string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now)
+
+	remaining := t.MaxAttempts
+	for _, key := range keys {
+		a := t.current(key, now)
+		if a == nil {
+			a = &fetchAttempts{first: now}
+			t.attempts[key] = a
+		} else if a.failures == 0 {
+			a.first = now
+		}
+		a.failures++
+		if a.failures >= t.MaxAttempts && a.lockedUntil.IsZero() {
+			a.lockedUntil = now.Add(t.Lockout)
+		}
+		remaining = min(remaining, max(t.MaxAttempts-a.failures, 0))
+	}
+	return remaining
+}
+
+// Reset forgets the failed attempts of a key.
+func (t *FetchThrottle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, key)
+}
+
+func lockedOutError(retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	return &customErrors.HttpError{
+		StatusCode: http.StatusTooManyRequests,
+		Body: []map[string]interface{}{{
+			"error":               "too_many_attempts",
+			"message":             "Too many failed pass code attempts, try again later",
+			"remaining_attempts":  0,
+			"retry_after_seconds": seconds,
+		}},
+		Err: fmt.Errorf("VHL fetch locked out for %d seconds", seconds),
+	}
+}
+
+// isPassCodeFailure reports whether the manifest server refused the passcode.
+func isPassCodeFailure(err error) bool {
+	var httpErr *customErrors.HttpError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	return httpErr.StatusCode == http.StatusUnauthorized || httpErr.StatusCode == http.StatusForbidden
+}
+
+// withRemainingAttempts copies a passcode failure adding the attempts left to its body.
+func withRemainingAttempts(err error, remaining int) error {
+	var httpErr *customErrors.HttpError
+	if !errors.As(err, &httpErr) {
+		return err
+	}
+	body := make([]map[string]interface{}, 0, len(httpErr.Body))
+	for _, item := range httpErr.Body {
+		copied := make(map[string]interface{}, len(item)+1)
+		for k, v := range item {
+			copied[k] = v
+		}
+		copied["remaining_attempts"] = remaining
+		body = append(body, copied)
+	}
+	return &customErrors.HttpError{StatusCode: httpErr.StatusCode, Body: body, Err: httpErr.Err}
+}
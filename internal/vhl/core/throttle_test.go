@@ -0,0 +1,178 @@
+package core
+
+import (
+	"errors"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPassCodePolicy(t *testing.T) {
+	policy := PassCodePolicy{MinLength: 6, MinClasses: 2}
+	tests := []struct {
+		name     string
+		passCode string
+		valid    bool
+	}{
+		{name: "No pass code", passCode: "", valid: true},
+		{name: "Letters and digits", passCode: "abc123", valid: true},
+		{name: "Accented letters and symbols", passCode: "contraseña!", valid: true},
+		{name: "Too short", passCode: "ab12", valid: false},
+		{name: "Digits only", passCode: "123456", valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.passCode)
+			if tt.valid && err != nil {
+				t.Errorf("Expected %q to be accepted, got %v", tt.passCode, err)
+			}
+			var httpErr *customErrors.HttpError
+			if !tt.valid && (!errors.As(err, &httpErr) || httpErr.StatusCode != 400 || httpErr.Body[0]["error"] != "weak_pass_code") {
+				t.Errorf("Expected weak_pass_code for %q, got %v", tt.passCode, err)
+			}
+		})
+	}
+}
+
+func TestFetchThrottleLocksOut(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	throttle := NewFetchThrottle(3, 10*time.Minute)
+	throttle.now = func() time.Time { return now }
+	vhl, caller := vhlThrottleKey("HC1:PAYLOAD"), callerThrottleKey("user-1")
+
+	if remaining := throttle.Fail(vhl, caller); remaining != 2 {
+		t.Errorf("Expected 2 attempts left, got %d", remaining)
+	}
+	// the caller keeps failing on another VHL, so it runs out first
+	if remaining := throttle.Fail(vhlThrottleKey("HC1:OTHER"), caller); remaining != 1 {
+		t.Errorf("Expected 1 attempt left, got %d", remaining)
+	}
+	if err := throttle.Check(vhl, caller); err != nil {
+		t.Fatalf("Expected no lockout yet, got %v", err)
+	}
+	throttle.Release(vhl, caller)
+	if remaining := throttle.Fail(vhl, caller); remaining != 0 {
+		t.Errorf("Expected no attempts left, got %d", remaining)
+	}
+
+	var httpErr *customErrors.HttpError
+	if err := throttle.Check(vhl, caller); !errors.As(err, &httpErr) || httpErr.StatusCode != 429 || httpErr.Body[0]["retry_after_seconds"] != 600 {
+		t.Errorf("Expected the caller locked out for 600 seconds, got %v", err)
+	}
+	if err := throttle.Check(vhl, callerThrottleKey("user-2")); err != nil {
+		t.Errorf("Expected the VHL, with two failures, to stay open to other callers, got %v", err)
+	}
+	throttle.Release(vhl, callerThrottleKey("user-2"))
+
+	now = now.Add(10 * time.Minute)
+	if err := throttle.Check(vhl, caller); err != nil {
+		t.Errorf("Expected the lockout to be over, got %v", err)
+	}
+	throttle.Release(vhl, caller)
+	if remaining := throttle.Fail(vhl); remaining != 2 {
+		t.Errorf("Expected the failures to be forgotten after the lockout, got %d attempts left", remaining)
+	}
+}
+
+func TestFetchThrottleConcurrentAttempts(t *testing.T) {
+	throttle := NewFetchThrottle(3, 10*time.Minute)
+	vhl, caller := vhlThrottleKey("HC1:PAYLOAD"), callerThrottleKey("user-1")
+
+	var allowed atomic.Int32
+	var started, wg sync.WaitGroup
+	started.Add(1)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Wait()
+			if err := throttle.Check(vhl, caller); err != nil {
+				return
+			}
+			defer throttle.Release(vhl, caller)
+			allowed.Add(1)
+			throttle.Fail(vhl, caller)
+		}()
+	}
+	started.Done()
+	wg.Wait()
+
+	if n := allowed.Load(); n > 3 {
+		t.Errorf("Expected at most 3 concurrent pass code attempts, got %d", n)
+	}
+	var httpErr *customErrors.HttpError
+	if err := throttle.Check(vhl, caller); !errors.As(err, &httpErr) || httpErr.StatusCode != 429 {
+		t.Errorf("Expected the VHL locked out, got %v", err)
+	}
+}
+
+func TestFetchThrottleReservesAttempts(t *testing.T) {
+	throttle := NewFetchThrottle(2, 10*time.Minute)
+	vhl := vhlThrottleKey("HC1:PAYLOAD")
+
+	if err := throttle.Check(vhl); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := throttle.Check(vhl); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var httpErr *customErrors.HttpError
+	if err := throttle.Check(vhl); !errors.As(err, &httpErr) || httpErr.StatusCode != 429 {
+		t.Errorf("Expected a third attempt in flight to be refused, got %v", err)
+	}
+	throttle.Release(vhl)
+	if err := throttle.Check(vhl); err != nil {
+		t.Errorf("Expected a released attempt to be available again, got %v", err)
+	}
+}
+
+func TestFetchThrottleForgetsKeys(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	throttle := NewFetchThrottle(3, 10*time.Minute)
+	throttle.now = func() time.Time { return now }
+
+	// successful fetches leave nothing behind
+	for _, caller := range []string{"user-1", "user-2"} {
+		keys := []string{vhlThrottleKey("HC1:" + caller), callerThrottleKey(caller)}
+		if err := throttle.Check(keys...); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		throttle.Release(keys...)
+	}
+	if len(throttle.attempts) != 0 {
+		t.Errorf("Expected no keys after successful fetches, got %d", len(throttle.attempts))
+	}
+
+	// failures are swept by the next fetch once their window is over
+	failed := callerThrottleKey("user-3")
+	throttle.Fail(failed)
+	now = now.Add(11 * time.Minute)
+	other := callerThrottleKey("user-4")
+	if err := throttle.Check(other); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := throttle.attempts[failed]; ok {
+		t.Error("Expected the expired failures to be swept")
+	}
+	throttle.Release(other)
+	if len(throttle.attempts) != 0 {
+		t.Errorf("Expected no keys left, got %d", len(throttle.attempts))
+	}
+}
+
+func TestWithRemainingAttempts(t *testing.T) {
+	err := &customErrors.HttpError{StatusCode: 401, Body: []map[string]interface{}{{"error": "invalid_pass_code"}}}
+	if !isPassCodeFailure(err) {
+		t.Fatal("Expected a 401 to be a pass code failure")
+	}
+
+	var httpErr *customErrors.HttpError
+	if !errors.As(withRemainingAttempts(err, 2), &httpErr) || httpErr.StatusCode != 401 || httpErr.Body[0]["remaining_attempts"] != 2 {
+		t.Errorf("Expected the remaining attempts in the error body, got %+v", httpErr)
+	}
+	if _, ok := err.Body[0]["remaining_attempts"]; ok {
+		t.Error("Expected the original error body to be left untouched")
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// validate checks the request bodies of this handler. Errors are reported with the JSON field names.
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	_ = v.RegisterValidation("future_rfc3339", func(fl validator.FieldLevel) bool {
+		t, err := time.Parse(time.RFC3339, fl.Field().String())
+		return err == nil && t.After(time.Now())
+	})
+	_ = v.RegisterValidation("hcert_data", func(fl validator.FieldLevel) bool {
+		data := fl.Field().String()
+		return strings.HasPrefix(data, "HC1:") || strings.HasPrefix(data, "shlink:/")
+	})
+	return v
+}
+
+// validationErrors describes each invalid field of a request body.
+func validationErrors(err error) []map[string]string {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return []map[string]string{{"error": "invalid_request", "error_description": err.Error()}}
+	}
+
+	var body []map[string]string
+	for _, verr := range verrs {
+		name := verr.Field()
+		switch verr.Tag() {
+		case "required":
+			body = append(body, map[string]string{
+				"error":             fmt.Sprintf("missing_%s", name),
+				"error_description": fmt.Sprintf("Missing required field: %s", name),
+			})
+		case "future_rfc3339":
+			body = append(body, map[string]string{
+				"error":             fmt.Sprintf("invalid_%s", name),
+				"error_description": fmt.Sprintf("Invalid %s. Must be an RFC 3339 date-time in the future", name),
+			})
+		case "hcert_data":
+			body = append(body, map[string]string{
+				"error":             fmt.Sprintf("invalid_%s", name),
+				"error_description": fmt.Sprintf("Invalid %s. Must start with HC1: or shlink:/", name),
+			})
+		case "oneof":
+			body = append(body, map[string]string{
+				"error":             fmt.Sprintf("invalid_%s", name),
+				"error_description": fmt.Sprintf("Invalid %s. Must be either %s", name, strings.ReplaceAll(verr.Param(), " ", " or ")),
+			})
+		default:
+			body = append(body, map[string]string{
+				"error":             fmt.Sprintf("invalid_%s", name),
+				"error_description": fmt.Sprintf("Invalid %s", name),
+			})
+		}
+	}
+	return body
+}
+
+// decodeAndValidate reads a JSON request body into v and validates it, answering 400 with the
+// invalid fields when it fails.
+func decodeAndValidate(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	var body []map[string]string
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		body = []map[string]string{{"error": "invalid_body", "error_description": "Request body must be a JSON object"}}
+	} else if err := validate.Struct(v); err != nil {
+		body = validationErrors(err)
+	} else {
+		return true
+	}
+
+	res, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(res)
+	return false
+}
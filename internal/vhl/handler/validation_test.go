@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeAndValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		target interface{}
+		errors []string
+	}{
+		{name: "Valid VHL request", body: `{"content":"{}","expires_on":"2999-01-01T00:00:00Z"}`, target: &VhlRequest{}},
+		{name: "Missing content and past expiration", body: `{"expires_on":"2000-01-01T00:00:00Z"}`, target: &VhlRequest{}, errors: []string{"invalid_expires_on", "missing_content"}},
+		{name: "Expiration without time", body: `{"content":"{}","expires_on":"2999-01-01"}`, target: &VhlRequest{}, errors: []string{"invalid_expires_on"}},
+		{name: "SHL fetch", body: `{"data":"shlink:/eyJ1cmwiOiIifQ"}`, target: &VhlGetRequest{}},
+		{name: "Fetch without data", body: `{"pass_code":"abc123"}`, target: &VhlGetRequest{}, errors: []string{"missing_data"}},
+		{name: "Validate non HCERT data", body: `{"data":"https://example.org"}`, target: &ICVPValidateRequest{}, errors: []string{"invalid_data"}},
+		{name: "Body is not JSON", body: `data=HC1:`, target: &ICVPValidateRequest{}, errors: []string{"invalid_body"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/qr", strings.NewReader(tt.body))
+			ok := decodeAndValidate(w, r, tt.target)
+			if len(tt.errors) == 0 {
+				if !ok {
+					t.Fatalf("Expected a valid body, got %d %s", w.Code, w.Body.String())
+				}
+				return
+			}
+
+			if ok || w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected 400, got %d", w.Code)
+			}
+			var body []map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Unexpected error body %s: %v", w.Body.String(), err)
+			}
+			var got []string
+			for _, e := range body {
+				got = append(got, e["error"])
+			}
+			if strings.Join(got, ",") != strings.Join(tt.errors, ",") {
+				t.Errorf("Expected errors %v, got %v", tt.errors, got)
+			}
+		})
+	}
+}
@@ -4,57 +4,101 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	auditCore "ips-lacpass-backend/internal/audit/core"
 	"ips-lacpass-backend/internal/vhl/core"
 	walletCache "ips-lacpass-backend/internal/wallet/cache"
+	walletClient "ips-lacpass-backend/internal/wallet/client"
 	customErrors "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
 	"ips-lacpass-backend/pkg/utils"
 	"log"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type Handler struct {
 	Service *core.VhlService
+	// QROptions renders the QR image returned when the request asks for png or svg.
+	QROptions utils.QROptions
+	// Audit records VHL creation, fetch, validation and revocation. Nil disables it.
+	Audit *auditCore.AuditService
 }
 
 func NewHandler(s *core.VhlService) *Handler {
 	return &Handler{
-		Service: s,
+		Service:   s,
+		QROptions: utils.DefaultQROptions,
 	}
 }
 
 type VhlRequest struct {
-	ExpiresOn string `json:"expires_on,omitempty"`
-	Content   string `json:"content,required"`
+	ExpiresOn string `json:"expires_on,omitempty" validate:"omitempty,future_rfc3339"`
+	Content   string `json:"content" validate:"required"`
 	PassCode  string `json:"pass_code,omitempty"`
 }
 
 type VhlGetRequest struct {
-	Data     string `json:"data,required"`
+	Data     string `json:"data" validate:"required,hcert_data"`
 	PassCode string `json:"pass_code,omitempty"`
 }
 
 type ICVPValidateRequest struct {
-	Data string `json:"data,require"`
+	Data string `json:"data" validate:"required,hcert_data"`
+}
+
+// VhlBatchValidateRequest carries the HC1 payloads scanned by a verifier. Mode is remote, the
+// default, to validate them with the ICVP validator service, or local to only decode them.
+type VhlBatchValidateRequest struct {
+	Data []string `json:"data" validate:"required"`
+	Mode string   `json:"mode,omitempty" validate:"omitempty,oneof=remote local"`
+}
+
+// VhlRevokeRequest optionally carries the HC1 payload of the VHL, needed to push the
+// revocation to the VHL service since only its hash is stored.
+type VhlRevokeRequest struct {
+	Data string `json:"data,omitempty"`
 }
 
 type VhlResponse struct {
 	Data    string                 `json:"data"`
 	Payload map[string]interface{} `json:"payload"`
+	// Certificate is the signing algorithm and key, issuer and validity window of the VHL, when it could be decoded.
+	Certificate *utils.HCertSummary `json:"certificate,omitempty"`
+}
+
+// auditEvent describes a request on a VHL payload, with the owner and id of the VHL when it was issued here.
+func (vh *Handler) auditEvent(r *http.Request, action auditCore.Action, payload string) auditCore.Event {
+	event := auditCore.Event{Action: action, SourceIP: r.RemoteAddr}
+	if issued, ok := vh.Service.Issuances.FindByPayload(payload); ok {
+		event.Subject = issued.UserID
+		event.Detail = map[string]string{"vhl_id": issued.ID}
+	}
+	return event
 }
 
-// Create Create QR data from VHL issuance.
+// Create Create QR data from VHL issuance. The QR image is returned instead when asked with ?format= or Accept.
 func (vh *Handler) Create(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	// TODO check if user is authenticated and has the permission to create a QR code
+	format, err := utils.QRImageFormat(r)
+	if err != nil {
+		utils.WriteUnsupportedQRFormat(w, err)
+		return
+	}
 
-	// TODO throw correct error body
 	var body VhlRequest
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if !decodeAndValidate(w, r, &body) {
 		return
 	}
 
 	qr, err := vh.Service.CreateQrCode(ctx, &body.ExpiresOn, &body.Content, &body.PassCode)
+	var payload string
+	if qr != nil {
+		payload = qr.Value
+	}
+	vh.Audit.Record(ctx, vh.auditEvent(r, auditCore.ActionVhlCreate, payload), err)
 	if err != nil {
 		var httpErr *customErrors.HttpError
 		if errors.As(err, &httpErr) {
@@ -76,16 +120,28 @@ func (vh *Handler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	decodedPayload, err := utils.DecodeHCert(qr.Value)
+	var decodedPayload map[string]interface{}
+	var certificate *utils.HCertSummary
+	decoded, err := utils.DecodeHCert(qr.Value)
 	if err != nil {
 		fmt.Println("Failed to decode hcert: ", err)
 	} else {
-		walletCache.Set(decodedPayload, qr.Value)
+		decodedPayload = decoded.Claims
+		summary := decoded.Summary()
+		certificate = &summary
+		if userID, err := authMiddleware.GetUserUUIDFromContext(ctx); err == nil {
+			walletCache.Set(userID, walletClient.VerifiableHealthLink, qr.Value)
+		}
+	}
+	if format != "" {
+		utils.WriteQRImage(w, qr.Value, format, vh.QROptions)
+		return
 	}
 
 	res, err := json.Marshal(&VhlResponse{
-		Data:    qr.Value,
-		Payload: decodedPayload,
+		Data:        qr.Value,
+		Payload:     decodedPayload,
+		Certificate: certificate,
 	})
 	if err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -99,19 +155,18 @@ func (vh *Handler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Get IPS Bundle using a valid VHL QR.
+// Get IPS Bundle using a valid VHL QR. With validate=true, a bundle that is not a valid IPS is refused.
 func (vh *Handler) Get(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	validate := r.URL.Query().Get("validate") == "true"
 
-	// TODO throw correct error body
 	var body VhlGetRequest
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		log.Printf("JSON Decode Error: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+	if !decodeAndValidate(w, r, &body) {
 		return
 	}
 
-	ips, err := vh.Service.GetQrIps(ctx, body.Data, body.PassCode)
+	ips, err := vh.Service.GetQrIps(ctx, body.Data, body.PassCode, validate)
+	vh.Audit.Record(ctx, vh.auditEvent(r, auditCore.ActionVhlFetch, body.Data), err)
 	if err != nil {
 		var httpErr *customErrors.HttpError
 		if errors.As(err, &httpErr) {
@@ -151,15 +206,14 @@ func (vh *Handler) Get(w http.ResponseWriter, r *http.Request) {
 func (vh *Handler) Validate(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// TODO throw correct error body
 	var body ICVPValidateRequest
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if !decodeAndValidate(w, r, &body) {
 		return
 	}
 	fmt.Printf("[DEBUG] /qr/validate request received data_len=%d\n", len(body.Data))
 
 	icvpValidationResponseData, err := vh.Service.GetICVPValidation(ctx, body.Data)
+	vh.Audit.Record(ctx, auditCore.Event{Action: auditCore.ActionQrValidate, SourceIP: r.RemoteAddr}, err)
 	if err != nil {
 		var httpErr *customErrors.HttpError
 		if errors.As(err, &httpErr) {
@@ -193,3 +247,133 @@ func (vh *Handler) Validate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// ValidateBatch validates many HC1 payloads at once. Each payload gets its own result, in the request order.
+func (vh *Handler) ValidateBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body VhlBatchValidateRequest
+	if !decodeAndValidate(w, r, &body) {
+		return
+	}
+
+	results, err := vh.Service.ValidateBatch(ctx, body.Data, core.BatchMode(body.Mode))
+	vh.Audit.Record(ctx, auditCore.Event{
+		Action:   auditCore.ActionQrValidate,
+		SourceIP: r.RemoteAddr,
+		Detail:   map[string]string{"batch_size": strconv.Itoa(len(body.Data))},
+	}, err)
+	if err != nil {
+		var httpErr *customErrors.HttpError
+		if errors.As(err, &httpErr) {
+			res, err := json.Marshal(httpErr.Body)
+			if err != nil {
+				http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(httpErr.StatusCode)
+			_, err = w.Write(res)
+			if err != nil {
+				http.Error(w, "Failed to write response", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res, err := json.Marshal(results)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// List VHLs created by the user, with their status.
+func (vh *Handler) List(w http.ResponseWriter, r *http.Request) {
+	vhls, err := vh.Service.ListIssued(r.Context())
+	if err != nil {
+		var httpErr *customErrors.HttpError
+		if errors.As(err, &httpErr) {
+			res, err := json.Marshal(httpErr.Body)
+			if err != nil {
+				http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(httpErr.StatusCode)
+			_, err = w.Write(res)
+			if err != nil {
+				http.Error(w, "Failed to write response", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res, err := json.Marshal(vhls)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Revoke a VHL created by the user so it can no longer be fetched.
+func (vh *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+	var body VhlRevokeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	id := chi.URLParam(r, "id")
+	vhl, err := vh.Service.RevokeIssued(r.Context(), id, body.Data)
+	vh.Audit.Record(r.Context(), auditCore.Event{Action: auditCore.ActionVhlRevoke, SourceIP: r.RemoteAddr, Detail: map[string]string{"vhl_id": id}}, err)
+	if err != nil {
+		var httpErr *customErrors.HttpError
+		if errors.As(err, &httpErr) {
+			res, err := json.Marshal(httpErr.Body)
+			if err != nil {
+				http.Error(w, "Failed to encode error response", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(httpErr.StatusCode)
+			_, err = w.Write(res)
+			if err != nil {
+				http.Error(w, "Failed to write response", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res, err := json.Marshal(vhl)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(res)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
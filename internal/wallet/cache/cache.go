@@ -1,66 +1,91 @@
 package cache
 
 import (
-	"crypto/sha256"
-	"encoding/json"
-	"fmt"
+	"ips-lacpass-backend/internal/wallet/client"
 	"sync"
 	"time"
 )
 
+// entry is a credential the backend issued to a user, such as the HC1 of an ICVP, MEOW or VHL.
 type entry struct {
-	raw       string
-	expiresAt time.Time
+	credentialType client.CredentialType
+	raw            string
+	storedAt       time.Time
+	expiresAt      time.Time
 }
 
 var (
 	mu    sync.RWMutex
-	store = make(map[string]entry)
+	store = make(map[string][]entry)
 	ttl   = 24 * time.Hour
 )
 
-func keyFromClaims(claims map[string]interface{}) (string, error) {
-	data, err := json.Marshal(claims)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal claims: %w", err)
-	}
-	hash := sha256.Sum256(data)
-	return fmt.Sprintf("%x", hash), nil
-}
-
-func Set(claims map[string]interface{}, raw string) {
-	k, err := keyFromClaims(claims)
-	if err != nil {
+// Set stores a credential issued to a user, so that a wallet link can later be generated for it.
+func Set(userID string, credentialType client.CredentialType, raw string) {
+	if userID == "" || raw == "" {
 		return
 	}
+	deleteExpired()
+
+	now := time.Now()
 	mu.Lock()
-	store[k] = entry{raw: raw, expiresAt: time.Now().Add(ttl)}
-	mu.Unlock()
+	defer mu.Unlock()
+	entries := store[userID][:0:0]
+	for _, e := range store[userID] {
+		if e.credentialType != credentialType || e.raw != raw {
+			entries = append(entries, e)
+		}
+	}
+	store[userID] = append(entries, entry{credentialType: credentialType, raw: raw, storedAt: now, expiresAt: now.Add(ttl)})
 }
 
-func Get(claims map[string]interface{}) (string, bool) {
+// Has reports whether the credential was issued to the user with the given type and has not expired.
+func Has(userID string, credentialType client.CredentialType, raw string) bool {
 	deleteExpired()
 
-	k, err := keyFromClaims(claims)
-	if err != nil {
-		return "", false
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, e := range store[userID] {
+		if e.credentialType == credentialType && e.raw == raw {
+			return true
+		}
 	}
+	return false
+}
+
+// Latest returns the credential of the given type most recently issued to the user.
+func Latest(userID string, credentialType client.CredentialType) (string, bool) {
+	deleteExpired()
+
 	mu.RLock()
-	e, ok := store[k]
-	mu.RUnlock()
-	if !ok || time.Now().After(e.expiresAt) {
+	defer mu.RUnlock()
+	var latest *entry
+	for i, e := range store[userID] {
+		if e.credentialType == credentialType && (latest == nil || !e.storedAt.Before(latest.storedAt)) {
+			latest = &store[userID][i]
+		}
+	}
+	if latest == nil {
 		return "", false
 	}
-	return e.raw, true
+	return latest.raw, true
 }
 
 func deleteExpired() {
 	mu.Lock()
 	defer mu.Unlock()
 	now := time.Now()
-	for k, e := range store {
-		if now.After(e.expiresAt) {
-			delete(store, k)
+	for userID, entries := range store {
+		kept := entries[:0]
+		for _, e := range entries {
+			if !now.After(e.expiresAt) {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(store, userID)
+		} else {
+			store[userID] = kept
 		}
 	}
 }
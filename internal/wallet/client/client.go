@@ -12,15 +12,20 @@ import (
 	"net/http"
 )
 
+// ClientInterface is a wallet provider, issuing the claims of a credential to a wallet and returning the link the
+// wallet opens to receive it. A required PIN is asked by the wallet before the credential is issued.
 type ClientInterface interface {
 	GenerateWalletLink(ctx context.Context, claims map[string]interface{}, credentialType CredentialType, raw string) (*GenerateWalletLinkResponse, error)
 }
 
+// WalletClient is the wallet provider of the /credentials/{identifier} API of the wallet service.
 type WalletClient struct {
 	Client     *http.Client
 	BaseURL    string
 	Identifier string
 	APIKey     string
+	// PinRequired asks the wallet service to protect the credentials with a PIN.
+	PinRequired bool
 }
 
 func NewClient(baseURL string, identifier string, apiKey string) WalletClient {
@@ -46,7 +51,7 @@ func (c *WalletClient) GenerateWalletLink(ctx context.Context, claims map[string
 	reqBody := GenerateWalletLinkRequest{
 		Claims:         claims,
 		CredentialType: credentialType,
-		PinRequired:    false,
+		PinRequired:    c.PinRequired,
 		Raw:            encodedRaw,
 	}
 
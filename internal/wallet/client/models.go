@@ -22,4 +22,46 @@ type GenerateWalletLinkResponse struct {
 	QrURL             string `json:"qrUrl"`
 	CoURL             string `json:"coUrl"`
 	Location          string `json:"location"`
+	// TxCode is the PIN the wallet asks for, when one was required and generated by the provider. Anyone
+	// holding both the offer and the PIN can claim the credential, so the PIN must never be shown with the QR code.
+	TxCode string `json:"txCode,omitempty"`
+	// CredentialOffer is the OpenID4VCI credential offer of the link, for OpenID4VCI providers.
+	CredentialOffer *CredentialOffer `json:"credentialOffer,omitempty"`
+}
+
+// PreAuthorizedCodeGrantType is the OpenID4VCI grant of credential offers whose code was authorized by the issuer.
+const PreAuthorizedCodeGrantType = "urn:ietf:params:oauth:grant-type:pre-authorized_code"
+
+// CredentialOffer is an OpenID4VCI credential offer.
+type CredentialOffer struct {
+	CredentialIssuer           string                `json:"credential_issuer"`
+	CredentialConfigurationIDs []string              `json:"credential_configuration_ids"`
+	Grants                     CredentialOfferGrants `json:"grants"`
+}
+
+// CredentialOfferGrants are the grants a wallet may use to redeem a credential offer.
+type CredentialOfferGrants struct {
+	PreAuthorizedCode *PreAuthorizedCodeGrant `json:"urn:ietf:params:oauth:grant-type:pre-authorized_code,omitempty"`
+}
+
+// PreAuthorizedCodeGrant is the pre-authorized code of a credential offer and the PIN the wallet must send with it.
+type PreAuthorizedCodeGrant struct {
+	PreAuthorizedCode string  `json:"pre-authorized_code"`
+	TxCode            *TxCode `json:"tx_code,omitempty"`
+}
+
+// TxCode describes the PIN a wallet asks its user for, without its value.
+type TxCode struct {
+	InputMode   string `json:"input_mode,omitempty"`
+	Length      int    `json:"length,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// PreAuthorizationRequest registers a pre-authorized code with the credential issuer, along with the claims it issues.
+type PreAuthorizationRequest struct {
+	PreAuthorizedCode         string                 `json:"pre-authorized_code"`
+	TxCode                    string                 `json:"tx_code,omitempty"`
+	CredentialConfigurationID string                 `json:"credential_configuration_id"`
+	Claims                    map[string]interface{} `json:"claims"`
+	Raw                       string                 `json:"raw,omitempty"`
 }
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"ips-lacpass-backend/pkg/errors"
+	"ips-lacpass-backend/pkg/utils"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultTxCodeLength is the number of digits of the PINs of credential offers.
+const DefaultTxCodeLength = 6
+
+// CredentialOfferScheme is the URI scheme wallets register to receive OpenID4VCI credential offers.
+const CredentialOfferScheme = "openid-credential-offer://"
+
+// OpenID4VCIClient is the wallet provider of OpenID4VCI credential issuers. Every link is a credential offer with
+// a pre-authorized code, registered with the issuer along with the claims to issue before the offer is returned.
+type OpenID4VCIClient struct {
+	Client *http.Client
+	// CredentialIssuer is the credential_issuer of offers, the URL wallets read the issuer metadata from.
+	CredentialIssuer string
+	// PreAuthorizationURL is the endpoint of the issuer registering pre-authorized codes.
+	PreAuthorizationURL string
+	APIKey              string
+	// ConfigurationIDs maps credential types to the credential configuration IDs of the issuer metadata.
+	// Types without one are offered under their own name.
+	ConfigurationIDs map[CredentialType]string
+	// PinRequired adds a tx_code to every offer, so that wallets ask for a PIN of TxCodeLength digits before
+	// the credential is issued.
+	PinRequired  bool
+	TxCodeLength int
+}
+
+func NewOpenID4VCIClient(credentialIssuer string, preAuthorizationURL string, apiKey string) OpenID4VCIClient {
+	return OpenID4VCIClient{
+		Client:              &http.Client{Timeout: 10 * time.Second},
+		CredentialIssuer:    credentialIssuer,
+		PreAuthorizationURL: preAuthorizationURL,
+		APIKey:              apiKey,
+		TxCodeLength:        DefaultTxCodeLength,
+	}
+}
+
+func (c *OpenID4VCIClient) GenerateWalletLink(ctx context.Context, claims map[string]interface{}, credentialType CredentialType, raw string) (*GenerateWalletLinkResponse, error) {
+	if c.CredentialIssuer == "" || c.PreAuthorizationURL == "" {
+		return nil, &errors.HttpError{
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       []map[string]interface{}{{"error": "wallet_not_configured", "message": "No OpenID4VCI credential issuer is configured"}},
+			Err:        fmt.Errorf("OpenID4VCI credential issuer or pre-authorization URL not configured"),
+		}
+	}
+
+	configurationID := c.configurationID(credentialType)
+	code, err := preAuthorizedCode()
+	if err != nil {
+		return nil, &errors.HttpError{
+			StatusCode: http.StatusInternalServerError,
+			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to generate pre-authorized code"}},
+			Err:        err,
+		}
+	}
+	grant := &PreAuthorizedCodeGrant{PreAuthorizedCode: code}
+	txCode := ""
+	if c.PinRequired {
+		length := c.TxCodeLength
+		if length <= 0 {
+			length = DefaultTxCodeLength
+		}
+		if txCode, err = numericTxCode(length); err != nil {
+			return nil, &errors.HttpError{
+				StatusCode: http.StatusInternalServerError,
+				Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to generate PIN"}},
+				Err:        err,
+			}
+		}
+		grant.TxCode = &TxCode{InputMode: "numeric", Length: length, Description: "PIN shown when the credential was requested"}
+	}
+
+	encodedRaw := ""
+	if raw != "" {
+		encodedRaw = base64.StdEncoding.EncodeToString([]byte(raw))
+	}
+	if err := c.preAuthorize(ctx, PreAuthorizationRequest{
+		PreAuthorizedCode:         code,
+		TxCode:                    txCode,
+		CredentialConfigurationID: configurationID,
+		Claims:                    claims,
+		Raw:                       encodedRaw,
+	}); err != nil {
+		return nil, err
+	}
+
+	offer := &CredentialOffer{
+		CredentialIssuer:           c.CredentialIssuer,
+		CredentialConfigurationIDs: []string{configurationID},
+		Grants:                     CredentialOfferGrants{PreAuthorizedCode: grant},
+	}
+	location, err := CredentialOfferURI(offer)
+	if err != nil {
+		return nil, &errors.HttpError{
+			StatusCode: http.StatusInternalServerError,
+			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to encode credential offer"}},
+			Err:        err,
+		}
+	}
+	return &GenerateWalletLinkResponse{
+		PreAuthorizedCode: code,
+		Location:          location,
+		TxCode:            txCode,
+		CredentialOffer:   offer,
+	}, nil
+}
+
+// preAuthorize registers the pre-authorized code of an offer with the credential issuer.
+func (c *OpenID4VCIClient) preAuthorize(ctx context.Context, preAuthorization PreAuthorizationRequest) error {
+	body, err := json.Marshal(preAuthorization)
+	if err != nil {
+		return &errors.HttpError{
+			StatusCode: http.StatusInternalServerError,
+			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to marshal request body"}},
+			Err:        err,
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.PreAuthorizationURL, bytes.NewBuffer(body))
+	if err != nil {
+		return &errors.HttpError{
+			StatusCode: http.StatusInternalServerError,
+			Body:       []map[string]interface{}{{"error": "internal_error", "message": "Failed to create request"}},
+			Err:        err,
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("x-api-key", c.APIKey)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return &errors.HttpError{
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       []map[string]interface{}{{"error": "service_unavailable", "message": "Failed to connect to credential issuer"}},
+			Err:        err,
+		}
+	}
+	defer utils.CloseBody(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &errors.HttpError{
+			StatusCode: http.StatusBadGateway,
+			Body:       []map[string]interface{}{{"error": "wallet_service_error", "message": "Credential issuer refused the pre-authorized code"}},
+			Err:        fmt.Errorf("credential issuer returned status code %d", resp.StatusCode),
+		}
+	}
+	return nil
+}
+
+func (c *OpenID4VCIClient) configurationID(credentialType CredentialType) string {
+	if id := c.ConfigurationIDs[credentialType]; id != "" {
+		return id
+	}
+	return string(credentialType)
+}
+
+// CredentialOfferURI returns the openid-credential-offer URI passing the offer by value.
+func CredentialOfferURI(offer *CredentialOffer) (string, error) {
+	encoded, err := json.Marshal(offer)
+	if err != nil {
+		return "", err
+	}
+	return CredentialOfferScheme + "?credential_offer=" + url.QueryEscape(string(encoded)), nil
+}
+
+// preAuthorizedCode returns a random code of 256 bits, unguessable as RFC 6749 requires of authorization codes.
+func preAuthorizedCode() (string, error) {
+	code := make([]byte, 32)
+	if _, err := rand.Read(code); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(code), nil
+}
+
+func numericTxCode(length int) (string, error) {
+	digits := make([]byte, length)
+	for i := range digits {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		digits[i] = byte('0' + n.Int64())
+	}
+	return string(digits), nil
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOpenID4VCIGenerateWalletLink(t *testing.T) {
+	var registered PreAuthorizationRequest
+	var apiKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("x-api-key")
+		_ = json.NewDecoder(r.Body).Decode(&registered)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	c := NewOpenID4VCIClient("https://issuer.example.com", server.URL, "key")
+	c.ConfigurationIDs = map[CredentialType]string{ICVP: "icvp_cwt"}
+	c.PinRequired = true
+	claims := map[string]interface{}{"1": "XCL"}
+
+	response, err := c.GenerateWalletLink(context.Background(), claims, ICVP, "HC1:raw")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	offer := response.CredentialOffer
+	if offer == nil || offer.CredentialIssuer != "https://issuer.example.com" || len(offer.CredentialConfigurationIDs) != 1 || offer.CredentialConfigurationIDs[0] != "icvp_cwt" {
+		t.Fatalf("Unexpected credential offer %+v", offer)
+	}
+	grant := offer.Grants.PreAuthorizedCode
+	if grant == nil || grant.PreAuthorizedCode == "" || grant.PreAuthorizedCode != response.PreAuthorizedCode {
+		t.Fatalf("Expected a pre-authorized code grant, got %+v", grant)
+	}
+	if grant.TxCode == nil || grant.TxCode.InputMode != "numeric" || grant.TxCode.Length != DefaultTxCodeLength {
+		t.Errorf("Expected a numeric tx_code, got %+v", grant.TxCode)
+	}
+	if len(response.TxCode) != DefaultTxCodeLength || strings.Trim(response.TxCode, "0123456789") != "" {
+		t.Errorf("Expected a PIN of %d digits, got %q", DefaultTxCodeLength, response.TxCode)
+	}
+
+	if registered.PreAuthorizedCode != grant.PreAuthorizedCode || registered.TxCode != response.TxCode || registered.CredentialConfigurationID != "icvp_cwt" || registered.Claims["1"] != "XCL" || apiKey != "key" {
+		t.Errorf("Expected the code, PIN and claims to be registered with the issuer, got %+v", registered)
+	}
+	if raw, _ := base64.StdEncoding.DecodeString(registered.Raw); string(raw) != "HC1:raw" {
+		t.Errorf("Expected the raw credential to be registered, got %q", registered.Raw)
+	}
+
+	location, err := url.Parse(response.Location)
+	if err != nil || location.Scheme != "openid-credential-offer" {
+		t.Fatalf("Expected a credential offer URI, got %q", response.Location)
+	}
+	var byValue map[string]interface{}
+	if err := json.Unmarshal([]byte(location.Query().Get("credential_offer")), &byValue); err != nil {
+		t.Fatalf("Expected the offer by value, got %q", response.Location)
+	}
+	grants, _ := byValue["grants"].(map[string]interface{})
+	if _, ok := grants[PreAuthorizedCodeGrantType]; !ok {
+		t.Errorf("Expected the pre-authorized code grant type, got %v", byValue["grants"])
+	}
+
+	registered = PreAuthorizationRequest{}
+	c.PinRequired = false
+	response, err = c.GenerateWalletLink(context.Background(), claims, MEOW, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.TxCode != "" || response.CredentialOffer.Grants.PreAuthorizedCode.TxCode != nil || registered.TxCode != "" {
+		t.Errorf("Expected no PIN when none is required, got %+v", response)
+	}
+	if response.CredentialOffer.CredentialConfigurationIDs[0] != "MEOW" {
+		t.Errorf("Expected the credential type as configuration ID, got %v", response.CredentialOffer.CredentialConfigurationIDs)
+	}
+}
+
+func TestOpenID4VCIGenerateWalletLinkErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		client OpenID4VCIClient
+		status int
+	}{
+		{"not configured", NewOpenID4VCIClient("https://issuer.example.com", "", ""), http.StatusServiceUnavailable},
+		{"refused by the issuer", NewOpenID4VCIClient("https://issuer.example.com", server.URL, ""), http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.client.GenerateWalletLink(context.Background(), map[string]interface{}{}, ICVP, "")
+			var httpErr *customErrors.HttpError
+			if !errors.As(err, &httpErr) || httpErr.StatusCode != tt.status {
+				t.Errorf("Expected %d, got %v", tt.status, err)
+			}
+		})
+	}
+}
@@ -2,25 +2,126 @@ package core
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	revocationCore "ips-lacpass-backend/internal/revocation/core"
 	"ips-lacpass-backend/internal/wallet/cache"
 	"ips-lacpass-backend/internal/wallet/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"ips-lacpass-backend/pkg/utils"
+	"net/http"
+	"time"
 )
 
 type WalletService struct {
-	Repository *client.WalletClient
+	// Repository is the wallet provider issuing the credentials.
+	Repository client.ClientInterface
+	// Keys resolves the trusted keys of credentials. Wallet links cannot be generated without it.
+	Keys utils.HCertKeyResolver
+	// Revocations refuses revoked credentials. Nil disables the check.
+	Revocations *revocationCore.RevocationService
 }
 
-func NewService(r *client.WalletClient) WalletService {
+func NewService(r client.ClientInterface) WalletService {
 	return WalletService{
 		Repository: r,
 	}
 }
 
-func (ws *WalletService) GenerateWalletLink(ctx context.Context, claims map[string]interface{}, credentialType client.CredentialType) (*client.GenerateWalletLinkResponse, error) {
-	raw, _ := cache.Get(claims)
-	walletResponse, err := ws.Repository.GenerateWalletLink(ctx, claims, credentialType, raw)
+// GenerateWalletLink issues a wallet credential for a credential the backend issued to the user, the given HC1
+// or, when empty, the latest one of the credential type. Its claims are taken from the credential once its
+// signature is verified, never from the client. Expired and revoked credentials are refused. Whether the wallet asks for a PIN is configured in the provider.
+func (ws *WalletService) GenerateWalletLink(ctx context.Context, credentialType client.CredentialType, data string) (*client.GenerateWalletLinkResponse, error) {
+	userID, err := authMiddleware.GetUserUUIDFromContext(ctx)
+	if err != nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusUnauthorized,
+			Body:       []map[string]interface{}{{"error": "unauthorized", "message": "User not found in request"}},
+			Err:        err,
+		}
+	}
+
+	raw := data
+	if raw == "" {
+		latest, ok := cache.Latest(userID, credentialType)
+		if !ok {
+			return nil, &customErrors.HttpError{
+				StatusCode: http.StatusNotFound,
+				Body:       []map[string]interface{}{{"error": "credential_not_found", "message": "No " + string(credentialType) + " was issued to the user in the last 24 hours"}},
+				Err:        errors.New("no stored credential for the user"),
+			}
+		}
+		raw = latest
+	} else if !cache.Has(userID, credentialType, raw) {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusForbidden,
+			Body:       []map[string]interface{}{{"error": "credential_not_owned", "message": "The credential was not issued to the user by this service"}},
+			Err:        errors.New("credential not stored for the user"),
+		}
+	}
+
+	hcert, err := ws.verify(raw)
+	if err != nil {
+		return nil, err
+	}
+	if hcert.ExpiresAt != nil && time.Now().After(*hcert.ExpiresAt) {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusGone,
+			Body:       []map[string]interface{}{{"error": "hcert_expired", "message": "The credential has expired"}},
+			Err:        fmt.Errorf("credential expired at %s", hcert.ExpiresAt.Format(time.RFC3339)),
+		}
+	}
+	if status := ws.Revocations.Check(raw); status.Revoked {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusGone,
+			Body:       []map[string]interface{}{{"error": "hcert_revoked", "message": "The credential has been revoked"}},
+			Err:        errors.New("credential revoked: " + status.Hash),
+		}
+	}
+
+	walletResponse, err := ws.Repository.GenerateWalletLink(ctx, hcert.Claims, credentialType, raw)
 	if err != nil {
 		return nil, err
 	}
 	return walletResponse, nil
 }
+
+// verify decodes a stored credential and checks its signature against the trusted keys.
+func (ws *WalletService) verify(raw string) (*utils.HCert, error) {
+	if ws.Keys == nil {
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       []map[string]interface{}{{"error": "signature_verification_unavailable", "message": "No trust list is configured to verify credentials"}},
+			Err:        errors.New("no HCERT key resolver configured"),
+		}
+	}
+	hcert, err := utils.VerifyHCert(raw, ws.Keys)
+	switch {
+	case err == nil:
+		return hcert, nil
+	case errors.Is(err, utils.ErrHCertUntrustedKey):
+		kid := ""
+		if signature, err := utils.DecodeHCertSignature(raw); err == nil {
+			kid = hex.EncodeToString(signature.KeyID)
+		}
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusUnprocessableEntity,
+			Body:       []map[string]interface{}{{"error": "untrusted_credential", "message": "The credential is not signed by a trusted key", "kid": kid}},
+			Err:        err,
+		}
+	case errors.Is(err, utils.ErrHCertInvalidSignature):
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusUnprocessableEntity,
+			Body:       []map[string]interface{}{{"error": "invalid_signature", "message": "The credential signature does not verify"}},
+			Err:        err,
+		}
+	default:
+		return nil, &customErrors.HttpError{
+			StatusCode: http.StatusUnprocessableEntity,
+			Body:       []map[string]interface{}{{"error": "invalid_hcert", "message": "The credential cannot be decoded"}},
+			Err:        err,
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	revocationCore "ips-lacpass-backend/internal/revocation/core"
+	"ips-lacpass-backend/internal/wallet/cache"
+	"ips-lacpass-backend/internal/wallet/client"
+	customErrors "ips-lacpass-backend/pkg/errors"
+	authMiddleware "ips-lacpass-backend/pkg/middleware"
+	"ips-lacpass-backend/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/veraison/go-cose"
+)
+
+func userContext(userID string) context.Context {
+	return context.WithValue(context.Background(), authMiddleware.UserUUIDKey, userID)
+}
+
+// signedHCert encodes an HC1 with the given issuer and CWT exp, none when zero, signed by the key with the kid 2350.
+func signedHCert(t *testing.T, key *ecdsa.PrivateKey, issuer string, exp int64) string {
+	t.Helper()
+	signer, err := cose.NewSigner(cose.AlgorithmES256, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	msg := cose.NewSign1Message()
+	msg.Headers.Protected.SetAlgorithm(cose.AlgorithmES256)
+	msg.Headers.Protected[cose.HeaderLabelKeyID] = []byte{0x23, 0x50}
+	claims := map[int]interface{}{1: issuer, -260: map[int]string{-6: "ICVP"}}
+	if exp != 0 {
+		claims[4] = exp
+	}
+	msg.Payload, _ = cbor.Marshal(claims)
+	if err := msg.Sign(rand.Reader, nil, signer); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := msg.MarshalCBOR()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return "HC1:" + utils.EncodeBase45(compressed.Bytes())
+}
+
+func TestGenerateWalletLink(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issued := signedHCert(t, key, "XCL", time.Now().Add(time.Hour).Unix())
+	forged := signedHCert(t, other, "XCL", 0)
+	revoked := signedHCert(t, key, "XRV", 0)
+	expired := signedHCert(t, key, "XCL", time.Now().Add(-time.Hour).Unix())
+	cache.Set("wallet-owner", client.ICVP, issued)
+	cache.Set("wallet-owner", client.MEOW, forged)
+	cache.Set("wallet-revoked", client.ICVP, revoked)
+	cache.Set("wallet-expired", client.ICVP, expired)
+
+	var forwarded client.GenerateWalletLinkRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&forwarded)
+		_ = json.NewEncoder(w).Encode(client.GenerateWalletLinkResponse{PreAuthorizedCode: "code"})
+	}))
+	defer server.Close()
+
+	repository := client.NewClient(server.URL, "test", "key")
+	repository.PinRequired = true
+	store, _ := revocationCore.NewStore("")
+	revocations := revocationCore.NewService(store)
+	if _, err := revocations.Revoke(context.Background(), revoked, "test"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	s := NewService(&repository)
+	s.Revocations = &revocations
+	s.Keys = func(kid []byte) []crypto.PublicKey {
+		return []crypto.PublicKey{key.Public()}
+	}
+
+	response, err := s.GenerateWalletLink(userContext("wallet-owner"), client.ICVP, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.PreAuthorizedCode != "code" {
+		t.Errorf("Expected the wallet response, got %+v", response)
+	}
+	if forwarded.Claims["1"] != "XCL" || forwarded.CredentialType != client.ICVP || forwarded.Raw == "" || !forwarded.PinRequired {
+		t.Errorf("Expected the claims of the stored credential to be forwarded, got %+v", forwarded)
+	}
+	if _, err := s.GenerateWalletLink(userContext("wallet-owner"), client.ICVP, issued); err != nil {
+		t.Errorf("Expected the given credential of the user to be accepted, got %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		credentialType client.CredentialType
+		data           string
+		status         int
+		error          string
+	}{
+		{"no user", context.Background(), client.ICVP, "", http.StatusUnauthorized, "unauthorized"},
+		{"nothing stored", userContext("wallet-owner"), client.VerifiableHealthLink, "", http.StatusNotFound, "credential_not_found"},
+		{"credential of another user", userContext("wallet-other"), client.ICVP, issued, http.StatusForbidden, "credential_not_owned"},
+		{"credential of another type", userContext("wallet-owner"), client.MEOW, issued, http.StatusForbidden, "credential_not_owned"},
+		{"signed by another key", userContext("wallet-owner"), client.MEOW, "", http.StatusUnprocessableEntity, "invalid_signature"},
+		{"revoked", userContext("wallet-revoked"), client.ICVP, "", http.StatusGone, "hcert_revoked"},
+		{"expired", userContext("wallet-expired"), client.ICVP, "", http.StatusGone, "hcert_expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.GenerateWalletLink(tt.ctx, tt.credentialType, tt.data)
+			var httpErr *customErrors.HttpError
+			if !errors.As(err, &httpErr) {
+				t.Fatalf("Expected an HttpError, got %v", err)
+			}
+			if httpErr.StatusCode != tt.status || httpErr.Body[0]["error"] != tt.error {
+				t.Errorf("Expected %d %s, got %d %v", tt.status, tt.error, httpErr.StatusCode, httpErr.Body)
+			}
+		})
+	}
+
+	s.Keys = nil
+	_, err = s.GenerateWalletLink(userContext("wallet-owner"), client.ICVP, "")
+	var httpErr *customErrors.HttpError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected wallet links to be refused without trusted keys, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	auditCore "ips-lacpass-backend/internal/audit/core"
 	"ips-lacpass-backend/internal/wallet/client"
 	"ips-lacpass-backend/internal/wallet/core"
 	customErrors "ips-lacpass-backend/pkg/errors"
@@ -11,6 +12,8 @@ import (
 
 type Handler struct {
 	WalletService *core.WalletService
+	// Audit records wallet link generation. Nil disables it.
+	Audit *auditCore.AuditService
 }
 
 func NewHandler(s *core.WalletService) *Handler {
@@ -19,12 +22,35 @@ func NewHandler(s *core.WalletService) *Handler {
 	}
 }
 
+// writeError writes the JSON body of an HttpError, or a generic internal error for any other error.
+func writeError(w http.ResponseWriter, err error) {
+	var httpErr *customErrors.HttpError
+	if errors.As(err, &httpErr) {
+		res, err := json.Marshal(httpErr.Body)
+		if err != nil {
+			http.Error(w, `{"error": "internal_server_error"}`, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(httpErr.StatusCode)
+		_, err = w.Write(res)
+		if err != nil {
+			http.Error(w, `{"error": "internal_server_error"}`, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		http.Error(w, `{"error": "internal_server_error"}`, http.StatusInternalServerError)
+	}
+}
+
+// GenerateWalletLinkRequest selects a credential issued to the user. Data is the HC1 of the credential, the latest
+// one of the type when empty. Claims are derived from the credential and a request carrying them is refused.
 type GenerateWalletLinkRequest struct {
-	Claims         map[string]interface{} `json:"claims"`
 	CredentialType client.CredentialType  `json:"credentialType"`
+	Data           string                 `json:"data,omitempty"`
+	Claims         map[string]interface{} `json:"claims,omitempty"`
 }
 
-// GenerateWalletLink Generate a new wallet link with the given claims. Must enable wallet in config.
+// GenerateWalletLink Generate a new wallet link for a verified credential issued to the user. Must enable wallet in config.
 func (h *Handler) GenerateWalletLink(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var reqBody GenerateWalletLinkRequest
@@ -38,25 +64,24 @@ func (h *Handler) GenerateWalletLink(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if reqBody.Claims != nil {
+		writeError(w, &customErrors.HttpError{
+			StatusCode: http.StatusBadRequest,
+			Body:       []map[string]interface{}{{"error": "claims_not_allowed", "message": "claims are derived from the credential and cannot be supplied"}},
+			Err:        errors.New("wallet link request carries claims"),
+		})
+		return
+	}
+
 	ctx := r.Context()
-	walletResponse, err := h.WalletService.GenerateWalletLink(ctx, reqBody.Claims, reqBody.CredentialType)
+	walletResponse, err := h.WalletService.GenerateWalletLink(ctx, reqBody.CredentialType, reqBody.Data)
+	h.Audit.Record(ctx, auditCore.Event{
+		Action:   auditCore.ActionWalletLink,
+		SourceIP: r.RemoteAddr,
+		Detail:   map[string]string{"credential_type": string(reqBody.CredentialType)},
+	}, err)
 	if err != nil {
-		var httpErr *customErrors.HttpError
-		if errors.As(err, &httpErr) {
-			res, err := json.Marshal(httpErr.Body)
-			if err != nil {
-				http.Error(w, `{"error": "internal_server_error"}`, http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(httpErr.StatusCode)
-			_, err = w.Write(res)
-			if err != nil {
-				http.Error(w, `{"error": "internal_server_error"}`, http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, `{"error": "internal_server_error"}`, http.StatusInternalServerError)
-		}
+		writeError(w, err)
 		return
 	}
 
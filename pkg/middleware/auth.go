@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +21,12 @@ type AuthMiddleware struct {
 	Realm         string
 	KeySet        jwk.Set
 	Issuer        string
+	// NodeClaim is the token claim holding the user's home node. Empty disables it.
+	NodeClaim string
+	// AllowNodeHeaderOverride lets the Node-Name header take precedence over the home node.
+	AllowNodeHeaderOverride bool
+	// Nodes are the configured node ids. Any other node resolves to the default services.
+	Nodes []string
 }
 
 type contextKey string
@@ -32,6 +40,12 @@ const (
 
 const NodeNameHeader = "Node-Name"
 
+// ServedNodeHeader is set on authenticated responses with the node that served the request.
+const ServedNodeHeader = "Served-Node"
+
+// DefaultNodeName is reported when the request was served by the default service configuration.
+const DefaultNodeName = "default"
+
 func NodeNameFromHeader(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nodeName := strings.TrimSpace(r.Header.Get(NodeNameHeader))
@@ -68,6 +82,18 @@ func GetNodeNameFromContext(ctx context.Context) string {
 	return nodeName
 }
 
+// ResolveNodeName picks the node for a request. The home node from the token wins
+// unless the requested header value is allowed to override it.
+func ResolveNodeName(requested string, home string, allowOverride bool) string {
+	if home == "" {
+		return requested
+	}
+	if allowOverride && requested != "" {
+		return requested
+	}
+	return home
+}
+
 func NewAuthMiddleware(baseURL string, realm string, hostName string) *AuthMiddleware {
 	WebKeySetsUrl := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/certs", baseURL, realm)
 
@@ -244,9 +270,25 @@ func (kam *AuthMiddleware) Authenticator(next http.Handler) http.Handler {
 			return
 		}
 
+		var homeNode string
+		if kam.NodeClaim != "" {
+			homeNode, _ = token.PrivateClaims()[kam.NodeClaim].(string)
+		}
+		nodeName := ResolveNodeName(GetNodeNameFromContext(r.Context()), strings.TrimSpace(homeNode), kam.AllowNodeHeaderOverride)
+		if !slices.Contains(kam.Nodes, nodeName) {
+			nodeName = ""
+		}
+		servedNode := nodeName
+		if servedNode == "" {
+			servedNode = DefaultNodeName
+		}
+		w.Header().Set(ServedNodeHeader, servedNode)
+		slog.Info("Serving request", "method", r.Method, "path", r.URL.Path, "user", userUUID, "node", servedNode, "homeNode", homeNode)
+
 		ctx := context.WithValue(r.Context(), UserDocIdKey, userId)
 		ctx = context.WithValue(ctx, RolesKey, roles)
 		ctx = context.WithValue(ctx, UserUUIDKey, userUUID)
+		ctx = context.WithValue(ctx, NodeNameKey, nodeName)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
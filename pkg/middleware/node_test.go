@@ -36,3 +36,28 @@ func TestNodeNameFromHeaderIgnoresQueryParam(t *testing.T) {
 		t.Fatalf("expected empty node name, got %q", got)
 	}
 }
+
+func TestResolveNodeName(t *testing.T) {
+	tests := []struct {
+		name          string
+		requested     string
+		home          string
+		allowOverride bool
+		want          string
+	}{
+		{"no home node uses header", "node-1", "", false, "node-1"},
+		{"home node without header", "", "node-2", true, "node-2"},
+		{"header overrides home node", "node-1", "node-2", true, "node-1"},
+		{"home node wins when override disabled", "node-1", "node-2", false, "node-2"},
+		{"nothing requested", "", "", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ResolveNodeName(tt.requested, tt.home, tt.allowOverride)
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
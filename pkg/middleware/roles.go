@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// GetRolesFromContext returns the realm roles of the authenticated user.
+func GetRolesFromContext(ctx context.Context) []string {
+	roles, _ := ctx.Value(RolesKey).([]string)
+	return roles
+}
+
+// RequireRole only lets through users whose token has the given realm role, answering 403 otherwise.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(GetRolesFromContext(r.Context()), role) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			res, _ := json.Marshal([]map[string]string{
+				{
+					"error":             "insufficient_role",
+					"error_description": "The " + role + " role is required for this request",
+				},
+			})
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write(res)
+		})
+	}
+}
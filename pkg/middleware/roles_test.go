@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name  string
+		roles []string
+		want  int
+	}{
+		{name: "Admin", roles: []string{"user", "admin"}, want: http.StatusNoContent},
+		{name: "Other roles", roles: []string{"user"}, want: http.StatusForbidden},
+		{name: "No roles", roles: nil, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			if tt.roles != nil {
+				req = req.WithContext(context.WithValue(req.Context(), RolesKey, tt.roles))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
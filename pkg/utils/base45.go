@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const base45Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// base45Values maps every byte to its Base45 value, -1 for bytes outside the alphabet.
+var base45Values [256]int8
+
+func init() {
+	for i := range base45Values {
+		base45Values[i] = -1
+	}
+	for i, c := range []byte(base45Alphabet) {
+		base45Values[c] = int8(i)
+	}
+}
+
+// Base45Error reports invalid Base45 input at Offset, the index of the first character of the faulty chunk.
+type Base45Error struct {
+	Offset int
+	Reason string
+}
+
+func (e *Base45Error) Error() string {
+	return fmt.Sprintf("invalid base45 at offset %d: %s", e.Offset, e.Reason)
+}
+
+// EncodeBase45 encodes data as RFC 9285 Base45.
+func EncodeBase45(data []byte) string {
+	out := make([]byte, 0, len(data)/2*3+len(data)%2*2)
+	for i := 0; i+1 < len(data); i += 2 {
+		n := int(data[i])<<8 | int(data[i+1])
+		out = append(out, base45Alphabet[n%45], base45Alphabet[n/45%45], base45Alphabet[n/2025])
+	}
+	if len(data)%2 == 1 {
+		n := int(data[len(data)-1])
+		out = append(out, base45Alphabet[n%45], base45Alphabet[n/45])
+	}
+	return string(out)
+}
+
+// DecodeBase45 decodes strict RFC 9285 Base45: only the 45 characters of the alphabet, uppercase,
+// without whitespace other than the space of the alphabet, and without chunks encoding values out of range.
+func DecodeBase45(s string) ([]byte, error) {
+	if len(s)%3 == 1 {
+		return nil, &Base45Error{Offset: len(s) - 1, Reason: "dangling character"}
+	}
+	out := make([]byte, 0, len(s)/3*2+len(s)%3/2)
+	for i := 0; i < len(s); i += 3 {
+		end := min(i+3, len(s))
+		decoded, err := decodeBase45Chunk(s[i:end], i)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, decoded...)
+	}
+	return out, nil
+}
+
+// decodeBase45Chunk decodes a chunk of 3 characters into 2 bytes or a final chunk of 2 characters into 1 byte.
+func decodeBase45Chunk(chunk string, offset int) ([]byte, error) {
+	n := 0
+	weight := 1
+	for j := 0; j < len(chunk); j++ {
+		v := base45Values[chunk[j]]
+		if v < 0 {
+			return nil, &Base45Error{Offset: offset + j, Reason: fmt.Sprintf("character %q is not in the alphabet", chunk[j])}
+		}
+		n += int(v) * weight
+		weight *= 45
+	}
+	if len(chunk) == 2 {
+		if n > 0xFF {
+			return nil, &Base45Error{Offset: offset, Reason: "2-character chunk above 255"}
+		}
+		return []byte{byte(n)}, nil
+	}
+	if n > 0xFFFF {
+		return nil, &Base45Error{Offset: offset, Reason: "3-character chunk above 65535"}
+	}
+	return []byte{byte(n >> 8), byte(n)}, nil
+}
+
+// base45Reader decodes Base45 read from an underlying reader, one chunk at a time.
+type base45Reader struct {
+	r       *bufio.Reader
+	offset  int
+	pending []byte
+	err     error
+}
+
+// NewBase45Reader returns a reader decoding the strict Base45 read from r. Errors are *Base45Error,
+// with offsets counted from the first character read.
+func NewBase45Reader(r io.Reader) io.Reader {
+	return &base45Reader{r: bufio.NewReader(r)}
+}
+
+func (b *base45Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(b.pending) > 0 {
+			c := copy(p[n:], b.pending)
+			b.pending = b.pending[c:]
+			n += c
+			continue
+		}
+		if b.err != nil {
+			break
+		}
+		var chunk [3]byte
+		read, err := io.ReadFull(b.r, chunk[:])
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			b.err = io.EOF
+			continue
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			b.err = io.EOF
+			if read == 1 {
+				b.err = &Base45Error{Offset: b.offset, Reason: "dangling character"}
+				continue
+			}
+		default:
+			b.err = err
+			continue
+		}
+		decoded, derr := decodeBase45Chunk(string(chunk[:read]), b.offset)
+		if derr != nil {
+			b.err = derr
+			continue
+		}
+		b.offset += read
+		b.pending = decoded
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, b.err
+}
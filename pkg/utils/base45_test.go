@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecodeBase45(t *testing.T) {
+	// examples of RFC 9285
+	valid := map[string]string{
+		"BB8":         "AB",
+		"%69 VD92EX0": "Hello!!",
+		"UJCLQE7W581": "base-45",
+		"QED8WEX0":    "ietf!",
+		"":            "",
+	}
+	for encoded, decoded := range valid {
+		got, err := DecodeBase45(encoded)
+		if err != nil {
+			t.Errorf("Unexpected error decoding %q: %v", encoded, err)
+			continue
+		}
+		if string(got) != decoded {
+			t.Errorf("Expected %q to decode to %q, got %q", encoded, decoded, got)
+		}
+		if EncodeBase45([]byte(decoded)) != encoded {
+			t.Errorf("Expected %q to encode to %q, got %q", decoded, encoded, EncodeBase45([]byte(decoded)))
+		}
+	}
+
+	invalid := map[string]int{
+		"GGW":          0, // 65536
+		"GGWBB8":       0,
+		"BB8:;":        4,
+		"bb8":          0,
+		"BB8\n":        3,
+		"BB8 ":         3, // dangling character
+		"QED8WEX0\r\n": 9,
+		"BB8GW":        3, // above 255
+	}
+	for encoded, offset := range invalid {
+		_, err := DecodeBase45(encoded)
+		var base45Err *Base45Error
+		if !errors.As(err, &base45Err) {
+			t.Errorf("Expected a Base45Error for %q, got %v", encoded, err)
+			continue
+		}
+		if base45Err.Offset != offset {
+			t.Errorf("Expected the error of %q at offset %d, got %d", encoded, offset, base45Err.Offset)
+		}
+	}
+}
+
+func TestBase45Reader(t *testing.T) {
+	encoded := "BB8UJCLQE7W581"
+	got, err := io.ReadAll(NewBase45Reader(iotest.OneByteReader(strings.NewReader(encoded))))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(got) != "ABbase-45" {
+		t.Errorf("Expected ABbase-45, got %q", got)
+	}
+
+	got, err = io.ReadAll(NewBase45Reader(strings.NewReader("BB8bb8")))
+	var base45Err *Base45Error
+	if !errors.As(err, &base45Err) || base45Err.Offset != 3 {
+		t.Errorf("Expected a Base45Error at offset 3, got %v", err)
+	}
+	if string(got) != "AB" {
+		t.Errorf("Expected the bytes before the error, got %q", got)
+	}
+}
+
+func FuzzDecodeBase45(f *testing.F) {
+	for _, seed := range []string{"BB8", "%69 VD92EX0", "GGW", "bb8", "A", "QED8WEX0\n", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		decoded, err := DecodeBase45(s)
+		streamed, streamErr := io.ReadAll(NewBase45Reader(strings.NewReader(s)))
+		if (err == nil) != (streamErr == nil) {
+			t.Fatalf("DecodeBase45 and the reader disagree on %q: %v, %v", s, err, streamErr)
+		}
+		if err != nil {
+			var base45Err *Base45Error
+			if !errors.As(err, &base45Err) || base45Err.Offset < 0 || base45Err.Offset >= len(s) {
+				t.Fatalf("Expected a Base45Error within the input for %q, got %v", s, err)
+			}
+			return
+		}
+		if !bytes.Equal(decoded, streamed) {
+			t.Fatalf("DecodeBase45 and the reader decode %q differently", s)
+		}
+		// strict Base45 has a single encoding per input
+		if EncodeBase45(decoded) != s {
+			t.Fatalf("Expected %q to encode back to itself, got %q", s, EncodeBase45(decoded))
+		}
+	})
+}
+
+func FuzzBase45RoundTrip(f *testing.F) {
+	f.Add([]byte("Hello!!"))
+	f.Add([]byte{0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoded, err := DecodeBase45(EncodeBase45(data))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatalf("Expected %x after a round trip, got %x", data, decoded)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/veraison/go-cose"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// hcertClaimKey is the CWT claim carrying the health certificate.
+const hcertClaimKey = "-260"
+
+// millisecondTimestamp is the smallest numeric date read as milliseconds rather than seconds, around 1973
+// in milliseconds and year 5138 in seconds. Some issuers, such as the VHL service, write iat in milliseconds.
+const millisecondTimestamp = 1e11
+
+// maxCWTTime is the last second RFC 3339 can write, in year 9999.
+var maxCWTTime = float64(time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC).Unix())
+
+// HCertHeader is the COSE header of an HCERT, protected values taking precedence over unprotected ones.
+type HCertHeader struct {
+	// Algorithm is the COSE alg, such as -7 for ES256.
+	Algorithm int64
+	KeyID     []byte
+}
+
+// HCert is a decoded HCERT: its COSE header, its standard CWT claims and its health payload.
+type HCert struct {
+	Header HCertHeader
+	// Issuer is the iss claim, the country code of the issuer for HCERTs.
+	Issuer    string
+	IssuedAt  *time.Time
+	ExpiresAt *time.Time
+	// Payload is the health certificate claim, -260.
+	Payload map[string]interface{}
+	// Claims are all the CWT claims, keyed by their label in decimal.
+	Claims map[string]interface{}
+}
+
+// HCertSummary is the readable metadata of an HCERT shown to users.
+type HCertSummary struct {
+	Algorithm     string     `json:"alg"`
+	KeyID         string     `json:"kid,omitempty"`
+	Issuer        string     `json:"issuer,omitempty"`
+	IssuerCountry string     `json:"issuer_country,omitempty"`
+	IssuedAt      *time.Time `json:"issued_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+func newHCert(header HCertHeader, claims map[string]interface{}) *HCert {
+	hcert := &HCert{Header: header, Claims: claims}
+	hcert.Issuer, _ = claims[strconv.FormatInt(cose.CWTClaimIssuer, 10)].(string)
+	hcert.IssuedAt = cwtTime(claims[strconv.FormatInt(cose.CWTClaimIssuedAt, 10)])
+	hcert.ExpiresAt = cwtTime(claims[strconv.FormatInt(cose.CWTClaimExpirationTime, 10)])
+	hcert.Payload, _ = claims[hcertClaimKey].(map[string]interface{})
+	return hcert
+}
+
+// cwtTime reads a CWT date, a number of seconds since the epoch or a tagged date.
+func cwtTime(v interface{}) *time.Time {
+	var t time.Time
+	switch value := v.(type) {
+	case time.Time:
+		t = value.UTC()
+	case uint64:
+		if value > math.MaxInt64 {
+			return nil
+		}
+		t = unixTime(int64(value))
+	case int64:
+		t = unixTime(value)
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) || math.Abs(value) > maxCWTTime*1000 {
+			return nil
+		}
+		if math.Abs(value) >= millisecondTimestamp {
+			value /= 1000
+		}
+		sec, frac := math.Modf(value)
+		t = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	default:
+		return nil
+	}
+	// dates beyond what RFC 3339 can write are not dates
+	if t.Year() < 0 || t.Year() > 9999 {
+		return nil
+	}
+	return &t
+}
+
+func unixTime(n int64) time.Time {
+	if n >= millisecondTimestamp || n <= -millisecondTimestamp {
+		return time.UnixMilli(n).UTC()
+	}
+	return time.Unix(n, 0).UTC()
+}
+
+// AlgorithmName is the name of the COSE algorithm, such as ES256.
+func (h HCertHeader) AlgorithmName() string {
+	return cose.Algorithm(h.Algorithm).String()
+}
+
+// IssuerName is the English name of the issuer country followed by its code, or the code alone
+// when it is not an ISO 3166 code, as for test issuers such as XCL.
+func (h *HCert) IssuerName() string {
+	if h.Issuer == "" {
+		return ""
+	}
+	region, err := language.ParseRegion(h.Issuer)
+	if err != nil || !region.IsCountry() {
+		return h.Issuer
+	}
+	name := display.English.Regions().Name(region)
+	if name == "" || strings.EqualFold(name, h.Issuer) {
+		return h.Issuer
+	}
+	return fmt.Sprintf("%s (%s)", name, h.Issuer)
+}
+
+// Summary returns the readable metadata of the HCERT.
+func (h *HCert) Summary() HCertSummary {
+	return HCertSummary{
+		Algorithm:     h.Header.AlgorithmName(),
+		KeyID:         hex.EncodeToString(h.Header.KeyID),
+		Issuer:        h.IssuerName(),
+		IssuerCountry: h.Issuer,
+		IssuedAt:      h.IssuedAt,
+		ExpiresAt:     h.ExpiresAt,
+	}
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestHCertMetadata(t *testing.T) {
+	claims := map[interface{}]interface{}{
+		1:    "CL",
+		4:    1760140221,
+		6:    uint64(1757271643804), // milliseconds
+		-260: map[interface{}]interface{}{-6: map[interface{}]interface{}{"n": "Aulo Agerio"}},
+	}
+	hcert, err := DecodeHCert(buildHCert(t, cbor.Tag{Number: coseSign1Tag, Content: sign1(t, claims)}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if hcert.Header.Algorithm != -7 || hcert.Header.AlgorithmName() != "ES256" {
+		t.Errorf("Expected ES256, got %d %s", hcert.Header.Algorithm, hcert.Header.AlgorithmName())
+	}
+	if hcert.Payload["-6"] == nil {
+		t.Errorf("Expected the health payload, got %v", hcert.Payload)
+	}
+
+	summary := hcert.Summary()
+	if summary.Issuer != "Chile (CL)" || summary.IssuerCountry != "CL" || summary.KeyID != "2350" {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if summary.IssuedAt == nil || !summary.IssuedAt.Equal(time.UnixMilli(1757271643804)) {
+		t.Errorf("Expected iat read as milliseconds, got %v", summary.IssuedAt)
+	}
+	if summary.ExpiresAt == nil || summary.ExpiresAt.Unix() != 1760140221 {
+		t.Errorf("Expected exp read as seconds, got %v", summary.ExpiresAt)
+	}
+}
+
+func TestHCertIssuerName(t *testing.T) {
+	tests := map[string]string{
+		"CL":  "Chile (CL)",
+		"CHL": "Chile (CHL)",
+		"XCL": "XCL",
+		"XJ":  "XJ",
+		"":    "",
+	}
+	for issuer, expected := range tests {
+		hcert := &HCert{Issuer: issuer}
+		if name := hcert.IssuerName(); name != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, issuer, name)
+		}
+	}
+}
+
+func TestCWTTime(t *testing.T) {
+	tagged := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value    interface{}
+		expected *time.Time
+	}{
+		{uint64(1700000000), ptr(time.Unix(1700000000, 0).UTC())},
+		{int64(-1), ptr(time.Unix(-1, 0).UTC())},
+		{1700000000.5, ptr(time.Unix(1700000000, 5e8).UTC())},
+		{tagged, &tagged},
+		{"1700000000", nil},
+		{1e300, nil},
+	}
+	for _, tt := range tests {
+		got := cwtTime(tt.value)
+		if (got == nil) != (tt.expected == nil) || (got != nil && !got.Equal(*tt.expected)) {
+			t.Errorf("Expected %v for %v, got %v", tt.expected, tt.value, got)
+		}
+	}
+}
+
+func ptr(t time.Time) *time.Time {
+	return &t
+}
@@ -1,10 +1,13 @@
 package utils
 
 import (
-	"bytes"
+	"bufio"
 	"compress/zlib"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"strconv"
 	"strings"
 
@@ -12,175 +15,368 @@ import (
 	"github.com/veraison/go-cose"
 )
 
-const base45Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+const hcertPrefix = "HC1:"
 
-var base45reverse map[byte]int
+// MaxHCertCOSESize bounds the decompressed COSE message, so a QR code cannot inflate into a zlib bomb.
+const MaxHCertCOSESize = 1 << 20
 
-func init() {
-	base45reverse = make(map[byte]int)
-	for i, c := range []byte(base45Alphabet) {
-		base45reverse[c] = i
+// CBOR tags of HCERT COSE messages. The CWT tag may wrap either COSE tag.
+const (
+	coseSign1Tag = 18
+	cwtTag       = 61
+	coseSignTag  = 98
+)
+
+// HCertStage is the layer of the HCERT pipeline that failed to decode.
+type HCertStage string
+
+const (
+	HCertStagePrefix HCertStage = "prefix"
+	HCertStageBase45 HCertStage = "base45"
+	HCertStageZlib   HCertStage = "zlib"
+	HCertStageCOSE   HCertStage = "cose"
+	HCertStageCBOR   HCertStage = "cbor"
+)
+
+// HCertError is returned by every HCERT decoding function, with the stage that failed.
+// Base45 failures wrap a *Base45Error with the offset of the faulty character.
+type HCertError struct {
+	Stage HCertStage
+	Err   error
+}
+
+func (e *HCertError) Error() string {
+	return fmt.Sprintf("HCERT %s decoding failed: %v", e.Stage, e.Err)
+}
+
+func (e *HCertError) Unwrap() error {
+	return e.Err
+}
+
+var hcertDecMode = func() cbor.DecMode {
+	mode, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[interface{}]interface{}{}),
+		DupMapKey:      cbor.DupMapKeyEnforcedAPF,
+	}.DecMode()
+	if err != nil {
+		panic(err)
 	}
+	return mode
+}()
+
+// coseMessage is a COSE_Sign1 or COSE_Sign message, with its protected header still encoded.
+type coseMessage struct {
+	// Tag is coseSign1Tag or coseSignTag, inferred from the structure for untagged messages.
+	Tag         uint64
+	CWT         bool
+	Protected   []byte
+	Unprotected map[interface{}]interface{}
+	Payload     []byte
+	// Signature is the signature of a COSE_Sign1 message, COSE_Sign messages carry one per signer.
+	Signature []byte
 }
 
-func convertInterfaceMap(m map[interface{}]interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-	for k, v := range m {
-		var keyStr string
-		switch kType := k.(type) {
-		case string:
-			keyStr = kType
-		case float64:
-			keyStr = strconv.FormatFloat(kType, 'f', -1, 64)
-		case int:
-			keyStr = strconv.Itoa(kType)
-		case uint64:
-			keyStr = strconv.FormatUint(kType, 10)
-		case int64:
-			keyStr = strconv.FormatInt(kType, 10)
+// readHCertCOSE undoes the HC1 prefix, base45 and zlib layers of an HCERT read from r, returning the COSE message.
+// Payloads that are not zlib compressed are returned as is.
+func readHCertCOSE(r io.Reader) ([]byte, error) {
+	prefix := make([]byte, len(hcertPrefix))
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix) != hcertPrefix {
+		return nil, &HCertError{Stage: HCertStagePrefix, Err: errors.New("HCERT must start with " + hcertPrefix)}
+	}
+
+	decoded := bufio.NewReader(NewBase45Reader(r))
+	header, err := decoded.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, &HCertError{Stage: HCertStageBase45, Err: err}
+	}
+	if len(header) == 0 {
+		return nil, &HCertError{Stage: HCertStageBase45, Err: errors.New("empty payload")}
+	}
+
+	var source io.Reader = decoded
+	stage := HCertStageBase45
+	if isZlibHeader(header) {
+		zr, err := zlib.NewReader(decoded)
+		if err != nil {
+			return nil, stageError(HCertStageZlib, err)
+		}
+		defer zr.Close()
+		source = zr
+		stage = HCertStageZlib
+	}
+	data, err := io.ReadAll(io.LimitReader(source, MaxHCertCOSESize+1))
+	if err != nil {
+		return nil, stageError(stage, err)
+	}
+	if len(data) > MaxHCertCOSESize {
+		return nil, &HCertError{Stage: stage, Err: fmt.Errorf("COSE message larger than %d bytes", MaxHCertCOSESize)}
+	}
+	// the zlib stream ends on its own, the rest of the input must still be valid and empty
+	if n, err := decoded.Read(make([]byte, 1)); n > 0 {
+		return nil, &HCertError{Stage: HCertStageZlib, Err: errors.New("trailing data after the zlib stream")}
+	} else if err != nil && !errors.Is(err, io.EOF) {
+		return nil, stageError(stage, err)
+	}
+	return data, nil
+}
+
+// stageError attributes err to the base45 stage when the Base45 input was invalid, and to stage otherwise.
+func stageError(stage HCertStage, err error) error {
+	var base45Err *Base45Error
+	if errors.As(err, &base45Err) {
+		return &HCertError{Stage: HCertStageBase45, Err: base45Err}
+	}
+	return &HCertError{Stage: stage, Err: err}
+}
+
+// isZlibHeader reports whether a stream starts with a zlib header using deflate, as RFC 1950 defines it.
+func isZlibHeader(header []byte) bool {
+	return len(header) == 2 && header[0]&0x0F == 8 && header[0]>>4 <= 7 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+// parseCOSE parses a COSE_Sign1 (tag 18) or COSE_Sign (tag 98) message, optionally wrapped in the CWT tag 61.
+// Untagged messages are accepted, their type being inferred from their last element.
+func parseCOSE(data []byte) (*coseMessage, error) {
+	var raw interface{}
+	if err := hcertDecMode.Unmarshal(data, &raw); err != nil {
+		return nil, &HCertError{Stage: HCertStageCOSE, Err: err}
+	}
+
+	msg := &coseMessage{}
+	for {
+		tag, ok := raw.(cbor.Tag)
+		if !ok {
+			break
+		}
+		switch {
+		case tag.Number == cwtTag && !msg.CWT && msg.Tag == 0:
+			msg.CWT = true
+		case (tag.Number == coseSign1Tag || tag.Number == coseSignTag) && msg.Tag == 0:
+			msg.Tag = tag.Number
 		default:
-			fmt.Printf("Encountered unexpected key type: %T with value: %v\n", kType, k)
-			continue
+			return nil, &HCertError{Stage: HCertStageCOSE, Err: fmt.Errorf("unexpected CBOR tag %d", tag.Number)}
 		}
+		raw = tag.Content
+	}
 
-		// Check if the value is a nested map
-		if nestedMap, isMap := v.(map[interface{}]interface{}); isMap {
-			result[keyStr] = convertInterfaceMap(nestedMap)
-		} else if nestedSlice, isSlice := v.([]interface{}); isSlice {
-			result[keyStr] = convertInterfaceSlice(nestedSlice)
-		} else {
-			result[keyStr] = v
+	parts, ok := raw.([]interface{})
+	if !ok || len(parts) != 4 {
+		return nil, &HCertError{Stage: HCertStageCOSE, Err: errors.New("COSE message must be an array of 4 elements")}
+	}
+	if msg.Tag == 0 {
+		msg.Tag = coseSign1Tag
+		if _, ok := parts[3].([]interface{}); ok {
+			msg.Tag = coseSignTag
 		}
 	}
-	return result
+
+	if msg.Protected, ok = parts[0].([]byte); !ok {
+		return nil, &HCertError{Stage: HCertStageCOSE, Err: errors.New("COSE protected header must be a byte string")}
+	}
+	if msg.Unprotected, ok = parts[1].(map[interface{}]interface{}); !ok {
+		return nil, &HCertError{Stage: HCertStageCOSE, Err: errors.New("COSE unprotected header must be a map")}
+	}
+	if msg.Payload, ok = parts[2].([]byte); !ok {
+		return nil, &HCertError{Stage: HCertStageCOSE, Err: errors.New("COSE payload must be a byte string, detached payloads are not supported")}
+	}
+	switch msg.Tag {
+	case coseSign1Tag:
+		if msg.Signature, ok = parts[3].([]byte); !ok || len(msg.Signature) == 0 {
+			return nil, &HCertError{Stage: HCertStageCOSE, Err: errors.New("COSE_Sign1 signature must be a non-empty byte string")}
+		}
+	case coseSignTag:
+		if signatures, ok := parts[3].([]interface{}); !ok || len(signatures) == 0 {
+			return nil, &HCertError{Stage: HCertStageCOSE, Err: errors.New("COSE_Sign signatures must be a non-empty array")}
+		}
+	}
+	return msg, nil
 }
 
-func convertInterfaceSlice(s []interface{}) []interface{} {
-	result := make([]interface{}, len(s))
-	for i, v := range s {
-		if nestedMap, isMap := v.(map[interface{}]interface{}); isMap {
-			result[i] = convertInterfaceMap(nestedMap)
-		} else if nestedSlice, isSlice := v.([]interface{}); isSlice {
-			result[i] = convertInterfaceSlice(nestedSlice)
-		} else {
-			result[i] = v
+// decodeCOSEHeader decodes the protected header of a COSE message. An empty byte string is an empty header.
+func decodeCOSEHeader(protected []byte) (map[interface{}]interface{}, error) {
+	header := map[interface{}]interface{}{}
+	if len(protected) == 0 {
+		return header, nil
+	}
+	if err := hcertDecMode.Unmarshal(protected, &header); err != nil {
+		return nil, &HCertError{Stage: HCertStageCOSE, Err: fmt.Errorf("COSE protected header must be a map: %w", err)}
+	}
+	return header, nil
+}
+
+// cborKey turns a CBOR map key into a JSON object key: integers, floats, booleans and null in decimal
+// or literal form, and byte strings in the h'..' diagnostic notation of RFC 8949.
+func cborKey(k interface{}) (string, error) {
+	switch key := k.(type) {
+	case string:
+		return key, nil
+	case uint64:
+		return strconv.FormatUint(key, 10), nil
+	case int64:
+		return strconv.FormatInt(key, 10), nil
+	case float64:
+		return strconv.FormatFloat(key, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(key), nil
+	case nil:
+		return "null", nil
+	case cbor.ByteString:
+		return "h'" + hex.EncodeToString([]byte(key)) + "'", nil
+	default:
+		return "", fmt.Errorf("unsupported map key type %T", k)
+	}
+}
+
+// convertCBOR turns decoded CBOR into JSON friendly values. Maps get string keys, tags keep their number
+// around their converted content, and byte strings, dates and big integers are kept as decoded.
+func convertCBOR(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		return convertInterfaceMap(value)
+	case []interface{}:
+		return convertInterfaceSlice(value)
+	case cbor.Tag:
+		content, err := convertCBOR(value.Content)
+		if err != nil {
+			return nil, err
 		}
+		return cbor.Tag{Number: value.Number, Content: content}, nil
+	default:
+		return v, nil
 	}
-	return result
 }
 
-func decodeBase45(s string) ([]byte, error) {
-	var out []byte
-	for i := 0; i < len(s); {
-		if len(s)-i < 2 {
-			return nil, fmt.Errorf("invalid base45 string length")
+func convertInterfaceMap(m map[interface{}]interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		key, err := cborKey(k)
+		if err != nil {
+			return nil, err
 		}
-		if len(s)-i < 3 {
-			// 2-character case
-			c1, ok1 := base45reverse[s[i]]
-			c2, ok2 := base45reverse[s[i+1]]
-			if !ok1 || !ok2 {
-				return nil, fmt.Errorf("invalid character in base45 string")
-			}
-			val := c1 + c2*45
-			if val > 255 {
-				return nil, fmt.Errorf("invalid 2-character encoding")
-			}
-			out = append(out, byte(val))
-			i += 2
-		} else {
-			// 3-character case
-			c1, ok1 := base45reverse[s[i]]
-			c2, ok2 := base45reverse[s[i+1]]
-			c3, ok3 := base45reverse[s[i+2]]
-			if !ok1 || !ok2 || !ok3 {
-				return nil, fmt.Errorf("invalid character in base45 string")
-			}
-			val := c1 + c2*45 + c3*45*45
-			if val > 65535 {
-				return nil, fmt.Errorf("invalid 3-character encoding")
-			}
-			out = append(out, byte(val>>8), byte(val&0xFF))
-			i += 3
+		if _, exists := result[key]; exists {
+			return nil, fmt.Errorf("map key %s appears twice", key)
+		}
+		if result[key], err = convertCBOR(v); err != nil {
+			return nil, err
 		}
 	}
-	return out, nil
+	return result, nil
 }
 
-// DecodeHCert decodes a base45 string encoded using Zlib/COSE/CBOR pipeline.
-// The string is an HCERT so it starts with "HC1:".
-func DecodeHCert(hcert string) (map[string]interface{}, error) {
-	if !strings.HasPrefix(hcert, "HC1:") {
-		return nil, fmt.Errorf("invalid HCERT prefix")
+func convertInterfaceSlice(s []interface{}) ([]interface{}, error) {
+	result := make([]interface{}, len(s))
+	for i, v := range s {
+		converted, err := convertCBOR(v)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = converted
 	}
+	return result, nil
+}
 
-	// 1. Remove "HC1:" prefix
-	base45Encoded := strings.TrimPrefix(hcert, "HC1:")
-
-	// 2. Base45 decode
-	compressedCose, err := decodeBase45(base45Encoded)
+// decodeHCertClaims decodes the CWT claims of a COSE payload.
+func decodeHCertClaims(payload []byte) (map[string]interface{}, error) {
+	var raw interface{}
+	if err := hcertDecMode.Unmarshal(payload, &raw); err != nil {
+		return nil, &HCertError{Stage: HCertStageCBOR, Err: err}
+	}
+	if tag, ok := raw.(cbor.Tag); ok && tag.Number == cwtTag {
+		raw = tag.Content
+	}
+	m, ok := raw.(map[interface{}]interface{})
+	if !ok {
+		return nil, &HCertError{Stage: HCertStageCBOR, Err: errors.New("CWT claims must be a map")}
+	}
+	claims, err := convertInterfaceMap(m)
 	if err != nil {
-		return nil, fmt.Errorf("base45 decoding failed: %w", err)
+		return nil, &HCertError{Stage: HCertStageCBOR, Err: err}
 	}
+	return claims, nil
+}
 
-	// 3. Zlib decompress
-	var cosePayload []byte
-	r, err := zlib.NewReader(bytes.NewReader(compressedCose))
+// DecodeHCertReader decodes an HCERT read from r, through its prefix, Base45, zlib, COSE and CBOR layers.
+// Errors are *HCertError naming the failing stage.
+func DecodeHCertReader(r io.Reader) (*HCert, error) {
+	data, err := readHCertCOSE(r)
 	if err != nil {
-		// Not zlib compressed, use as is
-		cosePayload = compressedCose
-	} else {
-		decompressed, err := io.ReadAll(r)
-		if err != nil {
-			return nil, fmt.Errorf("zlib decompression failed: %w", err)
-		}
-		cosePayload = decompressed
-		defer r.Close()
-	}
-
-	// 4. COSE decode
-	var payload []byte
-	var msg cose.Sign1Message
-	if err := msg.UnmarshalCBOR(cosePayload); err == nil {
-		payload = msg.Payload
-	} else {
-		var signMessage cose.SignMessage
-		if err2 := signMessage.UnmarshalCBOR(cosePayload); err2 == nil {
-			payload = signMessage.Payload
-		} else {
-			// Both failed, try manual parsing
-			var rawCoseMessage interface{}
-			if err3 := cbor.Unmarshal(cosePayload, &rawCoseMessage); err3 != nil {
-				return nil, fmt.Errorf("cose unmarshalling failed for both Sign1Message and SignMessage and raw: %v, %v, %v", err, err2, err3)
-			}
-
-			coseArray, ok := rawCoseMessage.([]interface{})
-			if !ok {
-				if tagged, ok := rawCoseMessage.(cbor.Tag); ok {
-					if content, ok := tagged.Content.([]interface{}); ok {
-						coseArray = content
-					}
-				}
-			}
-
-			if coseArray == nil || len(coseArray) < 3 {
-				return nil, fmt.Errorf("cose unmarshalling failed for both Sign1Message and SignMessage: %v, %v", err, err2)
-			}
-
-			p, ok := coseArray[2].([]byte)
-			if !ok {
-				return nil, fmt.Errorf("failed to extract payload from cose message: payload is not a byte string")
-			}
-			payload = p
-		}
+		return nil, err
+	}
+	msg, err := parseCOSE(data)
+	if err != nil {
+		return nil, err
 	}
+	header, err := decodeHCertHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := decodeHCertClaims(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return newHCert(header, claims), nil
+}
 
-	// 5. CBOR decode the payload from COSE message
-	var cborData map[interface{}]interface{}
-	if err := cbor.Unmarshal(payload, &cborData); err != nil {
-		return nil, fmt.Errorf("cbor unmarshalling of payload failed: %w", err)
+// DecodeHCert decodes a base45 string encoded using Zlib/COSE/CBOR pipeline.
+// The string is an HCERT so it starts with "HC1:".
+func DecodeHCert(hcert string) (*HCert, error) {
+	return DecodeHCertReader(strings.NewReader(hcert))
+}
+
+// coseHeaderValue reads a header label, the protected header taking precedence over the unprotected one.
+func coseHeaderValue(protected, unprotected map[interface{}]interface{}, label int64) interface{} {
+	var key interface{} = label
+	if label >= 0 {
+		key = uint64(label)
+	}
+	if v, ok := protected[key]; ok {
+		return v
 	}
+	return unprotected[key]
+}
 
-	cborDataConverted := convertInterfaceMap(cborData)
-	return cborDataConverted, nil
+// decodeHCertHeader reads alg and kid from the headers of the message.
+func decodeHCertHeader(msg *coseMessage) (HCertHeader, error) {
+	protected, err := decodeCOSEHeader(msg.Protected)
+	if err != nil {
+		return HCertHeader{}, err
+	}
+	var header HCertHeader
+	switch alg := coseHeaderValue(protected, msg.Unprotected, cose.HeaderLabelAlgorithm).(type) {
+	case int64:
+		header.Algorithm = alg
+	case uint64:
+		header.Algorithm = int64(alg)
+	}
+	header.KeyID, _ = coseHeaderValue(protected, msg.Unprotected, cose.HeaderLabelKeyID).([]byte)
+	return header, nil
+}
+
+// HCertSignature is the signature of a COSE_Sign1 HCERT with the headers needed to check it.
+type HCertSignature struct {
+	// Algorithm is the COSE alg header, such as -7 for ES256.
+	Algorithm int64
+	KeyID     []byte
+	Signature []byte
+}
+
+// DecodeHCertSignature extracts the signature of an HCERT, reading kid from the protected header
+// and falling back to the unprotected one.
+func DecodeHCertSignature(hcert string) (*HCertSignature, error) {
+	data, err := readHCertCOSE(strings.NewReader(hcert))
+	if err != nil {
+		return nil, err
+	}
+	msg, err := parseCOSE(data)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Tag != coseSign1Tag {
+		return nil, &HCertError{Stage: HCertStageCOSE, Err: errors.New("HCERT is not a COSE_Sign1 message")}
+	}
+	header, err := decodeHCertHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &HCertSignature{Algorithm: header.Algorithm, KeyID: header.KeyID, Signature: msg.Signature}, nil
 }
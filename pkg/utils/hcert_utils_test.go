@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// buildHCert encodes a COSE message the way HCERT issuers do: CBOR, zlib, Base45 and the HC1: prefix.
+func buildHCert(t testing.TB, message interface{}) string {
+	t.Helper()
+	data, err := cbor.Marshal(message)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return hcertPrefix + EncodeBase45(compressed.Bytes())
+}
+
+func sign1(t testing.TB, claims interface{}) []interface{} {
+	t.Helper()
+	protected, _ := cbor.Marshal(map[int]interface{}{1: -7, 4: []byte{0x23, 0x50}})
+	payload, err := cbor.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return []interface{}{protected, map[interface{}]interface{}{}, payload, []byte{1, 2, 3, 4}}
+}
+
+func TestDecodeHCertTags(t *testing.T) {
+	claims := map[interface{}]interface{}{
+		1:                       "XCL",
+		-260:                    map[interface{}]interface{}{-6: map[interface{}]interface{}{"n": "Aulo Agerio"}},
+		true:                    "flag",
+		cbor.ByteString("\x01"): []byte{0xca, 0xfe},
+		"dt":                    cbor.Tag{Number: 1, Content: 1700000000},
+	}
+	message := sign1(t, claims)
+
+	tests := []struct {
+		name    string
+		message interface{}
+	}{
+		{"COSE_Sign1", cbor.Tag{Number: coseSign1Tag, Content: message}},
+		{"untagged COSE_Sign1", message},
+		{"CWT wrapped COSE_Sign1", cbor.Tag{Number: cwtTag, Content: cbor.Tag{Number: coseSign1Tag, Content: message}}},
+		{"COSE_Sign", cbor.Tag{Number: coseSignTag, Content: []interface{}{message[0], message[1], message[2], []interface{}{[]interface{}{[]byte{}, map[interface{}]interface{}{}, []byte{1}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hcert, err := DecodeHCert(buildHCert(t, tt.message))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			decoded := hcert.Claims
+			if decoded["1"] != "XCL" || decoded["true"] != "flag" {
+				t.Errorf("Expected integer and boolean keys, got %v", decoded)
+			}
+			if health, ok := decoded["-260"].(map[string]interface{}); !ok || health["-6"] == nil {
+				t.Errorf("Expected the negative keys of nested maps, got %v", decoded["-260"])
+			}
+			if b, ok := decoded["h'01'"].([]byte); !ok || !bytes.Equal(b, []byte{0xca, 0xfe}) {
+				t.Errorf("Expected the byte string key and value to be kept, got %v", decoded)
+			}
+			if dt, ok := decoded["dt"].(time.Time); !ok || dt.Unix() != 1700000000 {
+				t.Errorf("Expected the date to be kept, got %#v", decoded["dt"])
+			}
+		})
+	}
+}
+
+func TestDecodeHCertStages(t *testing.T) {
+	valid := buildHCert(t, cbor.Tag{Number: coseSign1Tag, Content: sign1(t, map[int]string{1: "XCL"})})
+	var corrupt bytes.Buffer
+	w := zlib.NewWriter(&corrupt)
+	_, _ = w.Write(bytes.Repeat([]byte("cose"), 100))
+	_ = w.Close()
+	truncated := corrupt.Bytes()[:len(corrupt.Bytes())/2]
+
+	tests := []struct {
+		name  string
+		hcert string
+		stage HCertStage
+	}{
+		{"missing prefix", "HC2:" + valid[4:], HCertStagePrefix},
+		{"lowercase", strings.ToLower(valid), HCertStagePrefix},
+		{"lowercase payload", hcertPrefix + strings.ToLower(valid[4:]), HCertStageBase45},
+		{"trailing newline", valid + "\n", HCertStageBase45},
+		{"empty", hcertPrefix, HCertStageBase45},
+		{"truncated zlib", hcertPrefix + EncodeBase45(truncated), HCertStageZlib},
+		{"not CBOR", hcertPrefix + EncodeBase45([]byte{0xff, 0xff}), HCertStageCOSE},
+		{"unexpected tag", buildHCert(t, cbor.Tag{Number: 99, Content: sign1(t, map[int]string{})}), HCertStageCOSE},
+		{"detached payload", buildHCert(t, []interface{}{[]byte{}, map[int]int{}, nil, []byte{1}}), HCertStageCOSE},
+		{"payload not a map", buildHCert(t, []interface{}{[]byte{}, map[int]int{}, []byte{0x01}, []byte{1}}), HCertStageCBOR},
+		{"duplicate key", buildHCert(t, sign1(t, map[interface{}]interface{}{1: "a", 1.0: "b"})), HCertStageCBOR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeHCert(tt.hcert)
+			var hcertErr *HCertError
+			if !errors.As(err, &hcertErr) {
+				t.Fatalf("Expected an HCertError, got %v", err)
+			}
+			if hcertErr.Stage != tt.stage {
+				t.Errorf("Expected the %s stage to fail, got %v", tt.stage, err)
+			}
+		})
+	}
+
+	_, err := DecodeHCert(valid + "\n")
+	var base45Err *Base45Error
+	if !errors.As(err, &base45Err) || base45Err.Offset != len(valid)-len(hcertPrefix) {
+		t.Errorf("Expected the offset of the newline, got %v", err)
+	}
+}
+
+func TestDecodeHCertReader(t *testing.T) {
+	hcert := buildHCert(t, cbor.Tag{Number: coseSign1Tag, Content: sign1(t, map[int]string{1: "XCL"})})
+	decoded, err := DecodeHCertReader(iotest.OneByteReader(strings.NewReader(hcert)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decoded.Claims["1"] != "XCL" {
+		t.Errorf("Expected the claims, got %v", decoded.Claims)
+	}
+}
+
+func TestDecodeHCertSignature(t *testing.T) {
+	signature, err := DecodeHCertSignature(buildHCert(t, cbor.Tag{Number: cwtTag, Content: cbor.Tag{Number: coseSign1Tag, Content: sign1(t, map[int]string{})}}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if signature.Algorithm != -7 || !bytes.Equal(signature.KeyID, []byte{0x23, 0x50}) || len(signature.Signature) != 4 {
+		t.Errorf("Unexpected signature %+v", signature)
+	}
+}
+
+func FuzzDecodeHCert(f *testing.F) {
+	f.Add(buildHCert(f, cbor.Tag{Number: coseSign1Tag, Content: sign1(f, map[int]string{1: "XCL"})}))
+	f.Add(buildHCert(f, sign1(f, map[interface{}]interface{}{-260: map[int][]byte{1: {1}}})))
+	f.Add(hcertPrefix + EncodeBase45([]byte{0xd2, 0x84, 0x40, 0xa0, 0x41, 0xa0, 0x41, 0x01}))
+	f.Add("HC1:")
+	f.Add("HC1:bb8")
+	f.Fuzz(func(t *testing.T, hcert string) {
+		_, err := DecodeHCert(hcert)
+		if err != nil {
+			var hcertErr *HCertError
+			if !errors.As(err, &hcertErr) {
+				t.Fatalf("Expected an HCertError, got %v", err)
+			}
+		}
+		_, streamErr := DecodeHCertReader(iotest.OneByteReader(strings.NewReader(hcert)))
+		if (err == nil) != (streamErr == nil) {
+			t.Fatalf("DecodeHCert and DecodeHCertReader disagree: %v, %v", err, streamErr)
+		}
+		_, _ = DecodeHCertSignature(hcert)
+	})
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/veraison/go-cose"
+)
+
+// HCertStageSignature is the stage of VerifyHCert checking the COSE signature.
+const HCertStageSignature HCertStage = "signature"
+
+var (
+	// ErrHCertUntrustedKey is wrapped by VerifyHCert when no trusted key has the kid of the HCERT.
+	ErrHCertUntrustedKey = errors.New("no trusted key for the HCERT kid")
+	// ErrHCertInvalidSignature is wrapped by VerifyHCert when no trusted key of the kid verifies the signature.
+	ErrHCertInvalidSignature = errors.New("HCERT signature does not verify")
+)
+
+// HCertKeyResolver returns the trusted public keys that may have signed an HCERT with the given kid.
+type HCertKeyResolver func(kid []byte) []crypto.PublicKey
+
+// VerifyHCert decodes an HCERT and checks its COSE_Sign1 signature with the trusted keys of its kid.
+// The alg is only read from the protected header, while the kid may be unprotected.
+// Signature failures are an *HCertError of the signature stage wrapping ErrHCertUntrustedKey or ErrHCertInvalidSignature.
+func VerifyHCert(hcert string, keys HCertKeyResolver) (*HCert, error) {
+	data, err := readHCertCOSE(strings.NewReader(hcert))
+	if err != nil {
+		return nil, err
+	}
+	msg, err := parseCOSE(data)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Tag != coseSign1Tag {
+		return nil, &HCertError{Stage: HCertStageCOSE, Err: errors.New("HCERT is not a COSE_Sign1 message")}
+	}
+	header, err := decodeHCertHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := decodeHCertClaims(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header.KeyID) == 0 {
+		return nil, &HCertError{Stage: HCertStageSignature, Err: fmt.Errorf("%w: HCERT has no kid", ErrHCertUntrustedKey)}
+	}
+	candidates := keys(header.KeyID)
+	if len(candidates) == 0 {
+		return nil, &HCertError{Stage: HCertStageSignature, Err: ErrHCertUntrustedKey}
+	}
+	// only the protected alg is covered by the signature, so an unprotected one is never trusted
+	algorithm, err := protectedAlgorithm(msg)
+	if err != nil {
+		return nil, err
+	}
+	header.Algorithm = algorithm
+	// Sig_structure of RFC 9052, signed with an empty external AAD
+	toBeSigned, err := cbor.Marshal([]interface{}{"Signature1", msg.Protected, []byte{}, msg.Payload})
+	if err != nil {
+		return nil, &HCertError{Stage: HCertStageSignature, Err: err}
+	}
+	for _, key := range candidates {
+		verifier, err := cose.NewVerifier(cose.Algorithm(header.Algorithm), key)
+		if err != nil {
+			continue
+		}
+		if verifier.Verify(toBeSigned, msg.Signature) == nil {
+			return newHCert(header, claims), nil
+		}
+	}
+	return nil, &HCertError{Stage: HCertStageSignature, Err: ErrHCertInvalidSignature}
+}
+
+// protectedAlgorithm reads the alg of the protected header, as RFC 9052 and the EU DCC specification require.
+func protectedAlgorithm(msg *coseMessage) (int64, error) {
+	protected, err := decodeCOSEHeader(msg.Protected)
+	if err != nil {
+		return 0, err
+	}
+	switch alg := coseHeaderValue(protected, nil, cose.HeaderLabelAlgorithm).(type) {
+	case int64:
+		return alg, nil
+	case uint64:
+		return int64(alg), nil
+	}
+	return 0, &HCertError{Stage: HCertStageSignature, Err: fmt.Errorf("%w: HCERT has no protected alg", ErrHCertInvalidSignature)}
+}
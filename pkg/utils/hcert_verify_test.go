@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/veraison/go-cose"
+)
+
+// signedHCert builds an HCERT with the kid 2350 signed by the key.
+func signedHCert(t *testing.T, key *ecdsa.PrivateKey, claims interface{}) string {
+	t.Helper()
+	return signMessage(t, key, sign1(t, claims))
+}
+
+// signMessage signs a COSE_Sign1 message with ES256 and builds its HCERT.
+func signMessage(t *testing.T, key *ecdsa.PrivateKey, message []interface{}) string {
+	t.Helper()
+	signer, err := cose.NewSigner(cose.AlgorithmES256, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	toBeSigned, _ := cbor.Marshal([]interface{}{"Signature1", message[0], []byte{}, message[2]})
+	signature, err := signer.Sign(rand.Reader, toBeSigned)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	message[3] = signature
+	return buildHCert(t, cbor.Tag{Number: coseSign1Tag, Content: message})
+}
+
+func TestVerifyHCert(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	hcert := signedHCert(t, key, map[int]string{1: "XCL"})
+	unprotectedAlg := sign1(t, map[int]string{1: "XCL"})
+	unprotectedAlg[0], _ = cbor.Marshal(map[int]interface{}{4: []byte{0x23, 0x50}})
+	unprotectedAlg[1] = map[interface{}]interface{}{1: -7}
+	trusting := func(keys ...crypto.PublicKey) HCertKeyResolver {
+		return func(kid []byte) []crypto.PublicKey {
+			if !bytes.Equal(kid, []byte{0x23, 0x50}) {
+				return nil
+			}
+			return keys
+		}
+	}
+
+	decoded, err := VerifyHCert(hcert, trusting(other.Public(), key.Public()))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decoded.Issuer != "XCL" {
+		t.Errorf("Expected the claims of the HCERT, got %v", decoded.Claims)
+	}
+
+	tests := []struct {
+		name  string
+		hcert string
+		keys  HCertKeyResolver
+		err   error
+	}{
+		{"untrusted kid", hcert, trusting(), ErrHCertUntrustedKey},
+		{"other key", hcert, trusting(other.Public()), ErrHCertInvalidSignature},
+		{"unprotected alg", signMessage(t, key, unprotectedAlg), trusting(key.Public()), ErrHCertInvalidSignature},
+		{"unsigned", buildHCert(t, sign1(t, map[int]string{1: "XCL"})), trusting(key.Public()), ErrHCertInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyHCert(tt.hcert, tt.keys)
+			var hcertErr *HCertError
+			if !errors.As(err, &hcertErr) || hcertErr.Stage != HCertStageSignature {
+				t.Fatalf("Expected the signature stage to fail, got %v", err)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if _, err := VerifyHCert("HC2:", trusting(key.Public())); err == nil {
+		t.Error("Expected decoding errors to be returned")
+	}
+}
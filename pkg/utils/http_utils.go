@@ -41,3 +41,20 @@ func GetFullURL(baseUrl string, uri string) (string, error) {
 	}
 	return fullUrl, nil
 }
+
+// BundleIDFromURL returns the id of the FHIR Bundle a URL points to, such as the attachment of a
+// DocumentReference, ignoring a trailing _history version. It is empty when the URL is not a Bundle.
+func BundleIDFromURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) >= 4 && segments[len(segments)-2] == "_history" {
+		segments = segments[:len(segments)-2]
+	}
+	if len(segments) < 2 || segments[len(segments)-2] != "Bundle" {
+		return ""
+	}
+	return segments[len(segments)-1]
+}
@@ -0,0 +1,20 @@
+package utils
+
+import "testing"
+
+func TestBundleIDFromURL(t *testing.T) {
+	tests := map[string]string{
+		"http://fhir.example.com/fhir/Bundle/ips-1":            "ips-1",
+		"http://fhir.example.com/fhir/Bundle/ips-1/":           "ips-1",
+		"http://fhir.example.com/fhir/Bundle/ips-1/_history/2": "ips-1",
+		"Bundle/ips-2": "ips-2",
+		"http://fhir.example.com/fhir/Binary/ips-1": "",
+		"http://fhir.example.com/fhir/Bundle":       "",
+		"%zz":                                       "",
+	}
+	for uri, want := range tests {
+		if got := BundleIDFromURL(uri); got != want {
+			t.Errorf("Expected %q for %q, got %q", want, uri, got)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	QRFormatPNG = "png"
+	QRFormatSVG = "svg"
+)
+
+// QROptions sets how QR images are rendered.
+type QROptions struct {
+	Level qrcode.RecoveryLevel
+	// Size is the width and height of the image in pixels.
+	Size int
+}
+
+// DefaultQROptions uses level Q, which HCERT payloads such as EU DCC and ICVP recommend.
+var DefaultQROptions = QROptions{Level: qrcode.High, Size: 512}
+
+// qrLevels maps the names of the QR error-correction levels to the encoder ones.
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// ParseQRLevel parses an error-correction level: L, M, Q or H.
+func ParseQRLevel(level string) (qrcode.RecoveryLevel, error) {
+	if l, ok := qrLevels[strings.ToUpper(strings.TrimSpace(level))]; ok {
+		return l, nil
+	}
+	return 0, fmt.Errorf("unknown QR error correction level %q", level)
+}
+
+// QRCodePNG renders a payload as a square PNG QR code of size pixels. HC1 payloads are Base45,
+// so the encoder fits them in alphanumeric mode.
+func QRCodePNG(payload string, level qrcode.RecoveryLevel, size int) ([]byte, error) {
+	code, err := qrcode.New(payload, level)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding QR code: %w", err)
+	}
+	return code.PNG(size)
+}
+
+// QRCodeSVG renders a payload as a square SVG QR code of size pixels, one path with a
+// rectangle per run of dark modules.
+func QRCodeSVG(payload string, level qrcode.RecoveryLevel, size int) ([]byte, error) {
+	code, err := qrcode.New(payload, level)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding QR code: %w", err)
+	}
+	bitmap := code.Bitmap()
+	modules := len(bitmap)
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, modules, modules)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="%s"/></svg>`, modules, modules, path.String())
+	return buf.Bytes(), nil
+}
+
+// QRImageFormat returns the QR image format asked for with the format query parameter or, when
+// missing, with the Accept header. It is empty when the request expects JSON.
+func QRImageFormat(r *http.Request) (string, error) {
+	switch format := strings.ToLower(r.URL.Query().Get("format")); format {
+	case "":
+	case "json":
+		return "", nil
+	case QRFormatPNG, QRFormatSVG:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q", format)
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accepted, ";")
+		switch strings.TrimSpace(mediaType) {
+		case "application/json":
+			return "", nil
+		case "image/png":
+			return QRFormatPNG, nil
+		case "image/svg+xml":
+			return QRFormatSVG, nil
+		}
+	}
+	return "", nil
+}
+
+// WriteUnsupportedQRFormat answers a request whose format parameter is not json, png or svg.
+func WriteUnsupportedQRFormat(w http.ResponseWriter, err error) {
+	slog.Warn("Unsupported QR format requested", "error", err)
+	res, _ := json.Marshal([]map[string]interface{}{{"error": "unsupported_format", "message": "Format must be one of json, png or svg"}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(res)
+}
+
+// WriteQRImage renders payload in the given format and writes it as the response.
+func WriteQRImage(w http.ResponseWriter, payload string, format string, opts QROptions) {
+	var image []byte
+	var err error
+	contentType := "image/png"
+	if format == QRFormatSVG {
+		contentType = "image/svg+xml"
+		image, err = QRCodeSVG(payload, opts.Level, opts.Size)
+	} else {
+		image, err = QRCodePNG(payload, opts.Level, opts.Size)
+	}
+	if err != nil {
+		slog.Error("Failed to render QR image", "format", format, "error", err)
+		http.Error(w, "Failed to render QR image", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(image)
+	if err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -33,8 +33,11 @@ func TestHCertICVPDecode(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to decode HCert: %v", err)
 	}
+	if summary := decoded.Summary(); summary.Algorithm != "ES256" || summary.KeyID != "2350405fc1404cbb" || summary.Issuer != "XCL" || summary.IssuedAt == nil {
+		t.Errorf("Unexpected certificate metadata: %+v", summary)
+	}
 
-	decodedPretty, err := json.MarshalIndent(decoded, "", "  ")
+	decodedPretty, err := json.MarshalIndent(decoded.Claims, "", "  ")
 	if err != nil {
 		t.Fatalf("Failed to decode JSON: %v", err)
 	}
@@ -65,7 +68,7 @@ func TestHCertVHLDecode(t *testing.T) {
 		t.Fatalf("Failed to decode HCert: %v", err)
 	}
 
-	decodedPretty, err := json.MarshalIndent(decoded, "", "  ")
+	decodedPretty, err := json.MarshalIndent(decoded.Claims, "", "  ")
 	if err != nil {
 		t.Fatalf("Failed to decode JSON: %v", err)
 	}
@@ -41,3 +41,49 @@ func TestBundle_Deserialization(t *testing.T) {
 		t.Errorf("Serialized JSON is not equal to the original.\nOriginal:\n%s\n\nRemarshaled:\n%s", string(originalPretty), string(remarshaledPretty))
 	}
 }
+
+func TestTypedResources_RoundTrip(t *testing.T) {
+	content, _ := os.ReadFile("test_ips.json")
+
+	var bundle core.Bundle
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		t.Fatalf("Failed to unmarshal sample JSON: %v", err)
+	}
+
+	roundTrips := map[string]func(map[string]interface{}) (map[string]interface{}, error){
+		"Composition":         roundTrip[core.Composition],
+		"Patient":             roundTrip[core.Patient],
+		"Immunization":        roundTrip[core.Immunization],
+		"AllergyIntolerance":  roundTrip[core.AllergyIntolerance],
+		"Condition":           roundTrip[core.Condition],
+		"MedicationStatement": roundTrip[core.MedicationStatement],
+		"Organization":        roundTrip[core.Organization],
+		"Practitioner":        roundTrip[core.Practitioner],
+	}
+
+	for _, entry := range bundle.Entry {
+		rtype, _ := entry.Resource["resourceType"].(string)
+		rt, ok := roundTrips[rtype]
+		if !ok {
+			continue
+		}
+		generic, err := rt(entry.Resource)
+		if err != nil {
+			t.Fatalf("Failed to round trip %s: %v", entry.FullURL, err)
+		}
+		if !reflect.DeepEqual(entry.Resource, generic) {
+			original, _ := json.MarshalIndent(entry.Resource, "", "  ")
+			remarshaled, _ := json.MarshalIndent(generic, "", "  ")
+			t.Errorf("%s did not survive a round trip.\nOriginal:\n%s\n\nRemarshaled:\n%s", entry.FullURL, original, remarshaled)
+		}
+	}
+}
+
+// roundTrip decodes a generic resource into its typed form and encodes it back.
+func roundTrip[T any](resource map[string]interface{}) (map[string]interface{}, error) {
+	typed, err := core.DecodeResource[T](resource)
+	if err != nil {
+		return nil, err
+	}
+	return core.EncodeResource(typed)
+}
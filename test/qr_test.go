@@ -0,0 +1,82 @@
+package test
+
+import (
+	"bytes"
+	"image/png"
+	"ips-lacpass-backend/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const qrPayload = "HC1:6BFOXN%TSMAHN-HJM80DOO8W%TG34UE726*2OC9Y.TW1ANU9SCE7JM:UC*ELIQ5B264IM:/42JO2"
+
+func TestQRImageFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		accept  string
+		want    string
+		wantErr bool
+	}{
+		{name: "Default is JSON", want: ""},
+		{name: "PNG from Accept", accept: "image/png", want: utils.QRFormatPNG},
+		{name: "SVG from Accept with parameters", accept: "image/svg+xml;q=0.9, */*", want: utils.QRFormatSVG},
+		{name: "JSON preferred in Accept", accept: "application/json, image/png", want: ""},
+		{name: "Format parameter wins", query: "?format=svg", accept: "image/png", want: utils.QRFormatSVG},
+		{name: "JSON format parameter", query: "?format=json", accept: "image/png", want: ""},
+		{name: "Unsupported format", query: "?format=jpeg", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/qr"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			got, err := utils.QRImageFormat(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected format %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseQRLevel(t *testing.T) {
+	for name, want := range map[string]qrcode.RecoveryLevel{"L": qrcode.Low, "m": qrcode.Medium, "Q": qrcode.High, "H": qrcode.Highest} {
+		if got, err := utils.ParseQRLevel(name); err != nil || got != want {
+			t.Errorf("Expected level %v for %q, got %v (%v)", want, name, got, err)
+		}
+	}
+	if _, err := utils.ParseQRLevel("X"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
+
+func TestWriteQRImage(t *testing.T) {
+	w := httptest.NewRecorder()
+	utils.WriteQRImage(w, qrPayload, utils.QRFormatPNG, utils.QROptions{Level: qrcode.High, Size: 300})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected a PNG response, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	image, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+	if bounds := image.Bounds(); bounds.Dx() != 300 || bounds.Dy() != 300 {
+		t.Errorf("Expected a 300x300 image, got %v", bounds)
+	}
+
+	w = httptest.NewRecorder()
+	utils.WriteQRImage(w, qrPayload, utils.QRFormatSVG, utils.QROptions{Level: qrcode.High, Size: 300})
+	body := w.Body.String()
+	if w.Header().Get("Content-Type") != "image/svg+xml" || !strings.HasPrefix(body, "<svg") || !strings.Contains(body, `width="300"`) {
+		t.Errorf("Expected a 300px SVG, got %s", body)
+	}
+}